@@ -0,0 +1,69 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the access log lines.
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON writes each line as a JSON object.
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogLogfmt writes each line as logfmt key=value pairs.
+	AccessLogLogfmt
+)
+
+// accessLog writes a line per request.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// accessLogEntry holds the fields of an access log line.
+type accessLogEntry struct {
+	Time         string `json:"time"`
+	Method       string `json:"method"`
+	Status       int    `json:"status"`
+	Duration     string `json:"duration"`
+	RequestSize  int64  `json:"request_size"`
+	ResponseSize int64  `json:"response_size"`
+	RequestID    string `json:"request_id,omitempty"`
+}
+
+// log writes the line for a request. The method is empty if the request was
+// rejected before it was known, the id if request ids are disabled.
+func (l *accessLog) log(start time.Time, method, id string, status int, requestSize, responseSize int64) {
+	e := accessLogEntry{
+		Time:         start.UTC().Format(time.RFC3339Nano),
+		Method:       method,
+		Status:       status,
+		Duration:     time.Since(start).String(),
+		RequestSize:  requestSize,
+		ResponseSize: responseSize,
+		RequestID:    id,
+	}
+	var line []byte
+	if l.format == AccessLogLogfmt {
+		line = []byte(fmt.Sprintf("time=%s method=%q status=%d duration=%s request_size=%d response_size=%d\n",
+			e.Time, e.Method, e.Status, e.Duration, e.RequestSize, e.ResponseSize))
+		if e.RequestID != "" {
+			line = append(line[:len(line)-1], fmt.Sprintf(" request_id=%q\n", e.RequestID)...)
+		}
+	} else {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
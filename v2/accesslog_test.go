@@ -0,0 +1,84 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var buf bytes.Buffer
+	s.EnableAccessLog(&buf, AccessLogJSON)
+
+	const body = `{"method":"Service1.Multiply","params":{"A":2,"B":3}}`
+	for _, contentType := range []string{"application/json", "text/plain"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", buf.String())
+	}
+	expected := []accessLogEntry{
+		{Method: "Service1.Multiply", Status: 200, RequestSize: int64(len(body)), ResponseSize: int64(len(`{"Result":6}`))},
+		{Status: http.StatusUnsupportedMediaType},
+	}
+	for i, line := range lines {
+		var e accessLogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Time == "" || e.Duration == "" {
+			t.Errorf("Expected a time and duration in %s", line)
+		}
+		e.Time, e.Duration = "", ""
+		if i == 1 {
+			// The body of rejected requests is not read.
+			e.RequestSize, e.ResponseSize = 0, 0
+		}
+		if e != expected[i] {
+			t.Errorf("Line was %+v, should be %+v", e, expected[i])
+		}
+	}
+}
+
+func TestAccessLogLogfmt(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	m := &sizeMetrics{requests: map[string]int64{}, responses: map[string]int64{}}
+	s.SetMetrics(m)
+	var buf bytes.Buffer
+	s.EnableAccessLog(&buf, AccessLogLogfmt)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":2,"B":3}}`))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	for _, field := range []string{"time=", ` method="Service1.Multiply" status=200 duration=`, " request_size=53 response_size=12\n"} {
+		if !strings.Contains(line, field) {
+			t.Errorf("Expected %q in %q", field, line)
+		}
+	}
+	// The sizes are counted once for both.
+	if m.requests["Service1.Multiply"] != 53 || m.responses["Service1.Multiply"] != 12 {
+		t.Errorf("Metrics were %v %v, should be 53 and 12", m.requests, m.responses)
+	}
+}
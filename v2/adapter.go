@@ -0,0 +1,74 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CodecAdapter converts the args and reply of a method to and from the
+// types of a codec, so that a single method serves several wire formats,
+// e.g. JSON with its own args and protobuf with generated messages, while
+// clients migrate from one to the other. See RegisterCodecAdapter.
+type CodecAdapter struct {
+	// ArgsType is the type of the *args the codec decodes the request
+	// into, e.g. reflect.TypeOf((*pb.MultiplyRequest)(nil)).
+	ArgsType reflect.Type
+	// Args converts wire, the decoded args of type ArgsType, into args,
+	// a pointer to the zero args of the method. An error is written with
+	// status 400, as for a request the codec can't decode.
+	Args func(wire, args interface{}) error
+	// Reply converts reply, the pointer to the reply of the method, nil if
+	// it has none, into the value encoded by the codec. It's only called
+	// for successful calls; an error is written with status 500. If nil,
+	// the reply is encoded as is.
+	Reply func(reply interface{}) (interface{}, error)
+}
+
+// RegisterCodecAdapter registers adapter for the calls of the given method
+// through the codec registered for contentType, e.g.
+// "application/x-protobuf". The codec decodes the request into a new value
+// of adapter.ArgsType, converted by adapter.Args for the method; the reply
+// of the method is converted by adapter.Reply before being encoded. Calls
+// through the other codecs are not affected.
+//
+// The adapter is chosen by the media type of the request, from the
+// "Content-Type" header or the header set with SetCodecHeader, so requests
+// defaulted to a codec or served by the fallback chain are not adapted.
+// The validator and the InterceptWithArgsFunc get the converted args, as
+// the method does. Registering another adapter for the same media type
+// replaces it, a nil adapter removes it.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) RegisterCodecAdapter(method, contentType string, adapter *CodecAdapter) error {
+	_, methodSpec, err := s.services.get(method)
+	if err != nil {
+		return err
+	}
+	if adapter != nil {
+		if adapter.ArgsType == nil || adapter.ArgsType.Kind() != reflect.Ptr {
+			return fmt.Errorf("rpc: adapter args type %v of %q is not a pointer", adapter.ArgsType, method)
+		}
+		if adapter.Args == nil {
+			return fmt.Errorf("rpc: adapter of %q has no args conversion", method)
+		}
+		if methodSpec.stream {
+			return fmt.Errorf("rpc: %q reads the request body itself, it can't be adapted", method)
+		}
+	}
+	mediaType, _ := parseContentType(contentType)
+	return s.services.setAdapter(method, mediaType, adapter)
+}
+
+// adapter returns the adapter of the method for the media type, nil if
+// none.
+func (m *serviceMethod) adapter(mediaType string) *CodecAdapter {
+	adapters := m.adapters.Load()
+	if adapters == nil {
+		return nil
+	}
+	return (*adapters)[mediaType]
+}
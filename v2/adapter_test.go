@@ -0,0 +1,95 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// CompactMultiply and CompactProduct are the wire types of Service1.Multiply
+// for the "application/x-compact" codec.
+type CompactMultiply struct {
+	Factors []int
+}
+
+type CompactProduct struct {
+	P int
+}
+
+func TestCodecAdapter(t *testing.T) {
+	s := NewServer()
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterCodec(NewTestCodec(), "application/x-compact")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &CodecAdapter{
+		ArgsType: reflect.TypeOf((*CompactMultiply)(nil)),
+		Args: func(wire, args interface{}) error {
+			factors := wire.(*CompactMultiply).Factors
+			if len(factors) != 2 {
+				return errors.New("two factors needed")
+			}
+			*args.(*Service1Request) = Service1Request{A: factors[0], B: factors[1]}
+			return nil
+		},
+		Reply: func(reply interface{}) (interface{}, error) {
+			return &CompactProduct{P: reply.(*Service1Response).Result}, nil
+		},
+	}
+	if err := s.RegisterCodecAdapter("Service1.Multiply", "application/X-Compact; charset=utf-8", adapter); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		contentType, params string
+		code                int
+		expected            string
+	}{
+		{"application/json", `{"A":2,"B":3}`, http.StatusOK, `{"Result":6}`},
+		{"application/x-compact", `{"Factors":[2,3]}`, http.StatusOK, `{"P":6}`},
+		{"application/x-compact; charset=utf-8", `{"Factors":[2,3]}`, http.StatusOK, `{"P":6}`},
+		{"application/x-compact", `{"Factors":[2]}`, http.StatusBadRequest, `two factors needed`},
+	}
+	for _, test := range tests {
+		body := `{"method":"Service1.Multiply","params":` + test.params + `}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.expected) {
+			t.Errorf("For %s %s, response was %d %s, should be %d %s", test.contentType, test.params, w.Code, w.Body, test.code, test.expected)
+		}
+	}
+
+	if err := s.RegisterCodecAdapter("Service1.Multiply", "application/x-compact", nil); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":2,"B":3}}`))
+	r.Header.Set("Content-Type", "application/x-compact")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Body.String() != `{"Result":6}` {
+		t.Errorf("Response was %s, the adapter should be removed", w.Body)
+	}
+
+	for _, bad := range []*CodecAdapter{
+		{Args: adapter.Args},
+		{ArgsType: reflect.TypeOf(CompactMultiply{}), Args: adapter.Args},
+		{ArgsType: adapter.ArgsType},
+	} {
+		if err := s.RegisterCodecAdapter("Service1.Multiply", "application/x-compact", bad); err == nil {
+			t.Errorf("Expected an error for the adapter %+v", bad)
+		}
+	}
+	if err := s.RegisterCodecAdapter("Service1.Missing", "application/x-compact", adapter); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// bufferedBody is a request body read into memory, so the hooks needing the
+// raw bytes, such as the payload capture, the body transform or the codec
+// fallback chain, and the codec share a single read of the body.
+type bufferedBody struct {
+	bytes.Reader
+	data []byte
+}
+
+func (b *bufferedBody) Close() error {
+	return nil
+}
+
+// bufferBody returns the bytes of the body of r, reading it into memory the
+// first time. The body is rewound, so it's read again from the start. It
+// returns nil if r has no body.
+func bufferBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	if b, ok := r.Body.(*bufferedBody); ok {
+		b.Reset(b.data)
+		return b.data, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	setBody(r, data)
+	return data, nil
+}
+
+// setBody replaces the body of r with data, reusing the buffered body if
+// any.
+func setBody(r *http.Request, data []byte) {
+	if b, ok := r.Body.(*bufferedBody); ok {
+		b.data = data
+		b.Reset(data)
+		return
+	}
+	b := &bufferedBody{data: data}
+	b.Reset(data)
+	r.Body = b
+}
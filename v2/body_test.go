@@ -0,0 +1,72 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBufferBody(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/", strings.NewReader("hello"))
+	for i := 0; i < 2; i++ {
+		b, err := bufferBody(r)
+		if err != nil || string(b) != "hello" {
+			t.Fatalf("Body was %q (%v), should be hello.", b, err)
+		}
+		if b, _ := io.ReadAll(r.Body); string(b) != "hello" {
+			t.Errorf("Body read was %q, should be rewound to hello.", b)
+		}
+	}
+	body := r.Body
+	setBody(r, []byte("bye"))
+	if r.Body != body {
+		t.Error("The buffered body should be reused.")
+	}
+	if b, _ := io.ReadAll(r.Body); string(b) != "bye" {
+		t.Errorf("Body read was %q, should be bye.", b)
+	}
+
+	r, _ = http.NewRequest("POST", "/", nil)
+	if b, err := bufferBody(r); b != nil || err != nil || r.Body != nil {
+		t.Errorf("Expected no body, got %q (%v).", b, err)
+	}
+}
+
+// restoreBody reads the body of r and restores it, as each hook did before
+// the body was buffered once.
+func restoreBody(r *http.Request) ([]byte, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// benchmarkBody reads the body of a request with the payload capture, the
+// body transform and the codec, through read.
+func benchmarkBody(b *testing.B, read func(r *http.Request) ([]byte, error)) {
+	payload := bytes.Repeat([]byte(`{"A":4,"B":5}`), 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := &http.Request{Body: io.NopCloser(bytes.NewReader(payload))}
+		for hook := 0; hook < 2; hook++ {
+			if _, err := read(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBodyRestored(b *testing.B) { benchmarkBody(b, restoreBody) }
+func BenchmarkBodyBuffered(b *testing.B) { benchmarkBody(b, bufferBody) }
@@ -0,0 +1,91 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned when a call is short-circuited by the circuit
+// breaker of its method.
+var errCircuitOpen = errors.New("rpc: circuit breaker open")
+
+const (
+	// DefaultBreakerThreshold is the number of consecutive failures opening
+	// a circuit breaker without an explicit Threshold.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long a circuit breaker without an
+	// explicit Cooldown stays open.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// BreakerOptions configures the circuit breaker of a method, see
+// Server.SetMethodCircuitBreaker.
+type BreakerOptions struct {
+	// Threshold is the number of consecutive failures opening the breaker.
+	// If zero, DefaultBreakerThreshold is used.
+	Threshold int
+	// Cooldown is how long the breaker stays open before letting a probe
+	// call through. If zero, DefaultBreakerCooldown is used.
+	Cooldown time.Duration
+}
+
+// circuitBreaker tracks the consecutive failures of a method. It opens
+// after threshold failures, rejecting calls for cooldown, then lets a
+// single probe call through: its success closes the breaker and its
+// failure opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	failures  int       // consecutive failures
+	openUntil time.Time // end of the cooldown while open
+	probing   bool      // a probe call is running
+}
+
+func newCircuitBreaker(opts BreakerOptions) *circuitBreaker {
+	b := &circuitBreaker{threshold: opts.Threshold, cooldown: opts.Cooldown}
+	if b.threshold <= 0 {
+		b.threshold = DefaultBreakerThreshold
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = DefaultBreakerCooldown
+	}
+	return b
+}
+
+// allow returns true if a call can run. A successful call must be followed
+// by record.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of a call allowed by allow.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.failures = b.threshold
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.probing = false
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type FlakyService struct {
+	calls int
+	fail  bool
+	panic bool
+}
+
+func (t *FlakyService) Call(r *http.Request, req *Service1Request, res *Service1Response) error {
+	t.calls++
+	if t.panic {
+		panic("flaky")
+	}
+	if t.fail {
+		return errors.New("downstream failure")
+	}
+	return nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s := NewServer()
+	flaky := &FlakyService{fail: true}
+	if err := s.RegisterService(flaky, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.SetMethodCircuitBreaker("FlakyService.Missing", BreakerOptions{}); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+	if err := s.SetMethodCircuitBreaker("FlakyService.Call", BreakerOptions{Threshold: 2, Cooldown: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func() int {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"FlakyService.Call","params":{}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w.Status
+	}
+
+	// Two failures open the breaker.
+	for i := 0; i < 2; i++ {
+		if status := call(); status != http.StatusBadRequest {
+			t.Errorf("Status was %d, should be 400.", status)
+		}
+	}
+	if status := call(); status != http.StatusServiceUnavailable || flaky.calls != 2 {
+		t.Errorf("Status was %d after %d calls, should be 503 without calling the method.", status, flaky.calls)
+	}
+
+	// After the cooldown a failing probe opens it again.
+	time.Sleep(30 * time.Millisecond)
+	if status := call(); status != http.StatusBadRequest || flaky.calls != 3 {
+		t.Errorf("Status was %d after %d calls, should be 400 for the probe.", status, flaky.calls)
+	}
+	if status := call(); status != http.StatusServiceUnavailable {
+		t.Errorf("Status was %d, should be 503 after a failed probe.", status)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(30 * time.Millisecond)
+	flaky.fail = false
+	for i := 0; i < 3; i++ {
+		if status := call(); status != http.StatusOK {
+			t.Errorf("Status was %d, should be 200 once closed.", status)
+		}
+	}
+
+	// Panics count as failures.
+	flaky.panic = true
+	for i := 0; i < 2; i++ {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the panic to propagate")
+				}
+			}()
+			call()
+		}()
+	}
+	if status := call(); status != http.StatusServiceUnavailable {
+		t.Errorf("Status was %d, should be 503 after panics.", status)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bufferedResponseWriter buffers the status and body written by a codec,
+// sharing the headers with the underlying ResponseWriter.
+type bufferedResponseWriter struct {
+	w      http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeCacheable writes the buffered response with an ETag computed over
+// the body, or 304 Not Modified if the ETag matches the If-None-Match header
+// of the request. Responses other than 200 OK are written as is.
+func (b *bufferedResponseWriter) writeCacheable(r *http.Request) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.status == http.StatusOK {
+		sum := sha256.Sum256(b.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		b.w.Header().Set("ETag", etag)
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			h := b.w.Header()
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			h.Del("Content-Encoding")
+			b.w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	b.flush()
+}
+
+// flush writes the buffered response as is.
+func (b *bufferedResponseWriter) flush() {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.w.Header().Set("Content-Length", strconv.Itoa(b.body.Len()))
+	b.w.WriteHeader(b.status)
+	b.w.Write(b.body.Bytes())
+}
+
+// headResponseWriter discards the response body of HEAD requests, keeping
+// the status and headers.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// etagMatch reports whether etag matches the If-None-Match header value,
+// using the weak comparison.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// CallMethod invokes the given method registered on s directly, without
+// HTTP or codecs, e.g. to unit test the services registered on a server.
+// The method uses a dotted notation as in "Service.Method".
+//
+// args is the args of the method, as a pointer or a value. The method gets
+// a POST request to "/" with a background context holding its name under
+// MethodContextKey, after its defaults and the ValidateRequestFunc, if
+// registered, and through its middleware. As for the requests served, a
+// panic of the ValidateRequestFunc is logged and returned as an internal
+// error. The other functions registered on the server are not called. The
+// reply is returned as a pointer to the reply type of the method, or nil if
+// it has none.
+func CallMethod(s *Server, method string, args interface{}) (interface{}, error) {
+	_, methodSpec, err := s.services.get(method)
+	if err != nil {
+		return nil, err
+	}
+	argsValue := reflect.ValueOf(args)
+	switch {
+	case !argsValue.IsValid():
+		return nil, fmt.Errorf("rpc: nil args for %q", method)
+	case argsValue.Type() == reflect.PtrTo(methodSpec.argsType):
+	case argsValue.Type() == methodSpec.argsType:
+		ptr := reflect.New(methodSpec.argsType)
+		ptr.Elem().Set(argsValue)
+		argsValue = ptr
+	default:
+		return nil, fmt.Errorf("rpc: args of %q are a %T, should be a *%s", method, args, methodSpec.argsType)
+	}
+	if defaults := methodSpec.defaults.Load(); defaults != nil {
+		applyDefaults(argsValue.Elem(), *defaults)
+	}
+	r, err := http.NewRequestWithContext(context.Background(), "POST", "/", http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if s.validateFunc.IsValid() {
+		info := &RequestInfo{Request: r, Method: method}
+		errValue, _ := s.validate(info, argsValue)
+		if err, _ := errValue[0].Interface().(error); err != nil {
+			return nil, err
+		}
+		r = info.Request
+	}
+	var reply interface{}
+	if methodSpec.replyType != nil {
+		reply = reflect.New(methodSpec.replyType).Interface()
+	}
+	r = withMethod(r, method)
+	if err := methodSpec.methodFunc()(r, argsValue.Interface(), reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
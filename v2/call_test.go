@@ -0,0 +1,65 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestCallMethod(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range []interface{}{&Service1Request{A: 4, B: 5}, Service1Request{A: 4, B: 5}} {
+		reply, err := CallMethod(s, "Service1.Multiply", args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res, ok := reply.(*Service1Response); !ok || res.Result != 20 {
+			t.Errorf("Reply was %#v, should be a *Service1Response of 20.", reply)
+		}
+	}
+
+	for _, args := range []interface{}{nil, "x", &Service1Response{}} {
+		if _, err := CallMethod(s, "Service1.Multiply", args); err == nil {
+			t.Errorf("Expected an error for args %#v", args)
+		}
+	}
+	if _, err := CallMethod(s, "Service1.Missing", &Service1Request{}); !errors.Is(err, ErrMethodNotFound) {
+		t.Errorf("Error was %v, should match ErrMethodNotFound", err)
+	}
+
+	errInvalid := errors.New("A must be positive")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		if i.Method != "Service1.Multiply" {
+			t.Errorf("Method was %q, should be Service1.Multiply", i.Method)
+		}
+		if args.(*Service1Request).A <= 0 {
+			return errInvalid
+		}
+		return nil
+	})
+	if _, err := CallMethod(s, "Service1.Multiply", &Service1Request{A: -1, B: 5}); err != errInvalid {
+		t.Errorf("Error was %v, should be the validation error", err)
+	}
+
+	var logs bytes.Buffer
+	s.SetLogger(log.New(&logs, "", 0))
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		panic("boom")
+	})
+	if _, err := CallMethod(s, "Service1.Multiply", &Service1Request{A: 4, B: 5}); err != errValidatorPanic {
+		t.Errorf("Error was %v, should be %v", err, errValidatorPanic)
+	}
+	if !strings.Contains(logs.String(), "validator panicked for method=Service1.Multiply: boom") {
+		t.Errorf("Expected the panic to be logged, got %q", logs.String())
+	}
+}
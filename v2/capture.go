@@ -0,0 +1,71 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// payloadCapture hands the payloads of a sampled fraction of the requests
+// to a sink, see Server.EnablePayloadCapture.
+type payloadCapture struct {
+	rate float64
+	sink func(method string, req, resp []byte)
+}
+
+// sample reports whether the payloads of a request should be captured.
+func (c *payloadCapture) sample() bool {
+	return c.rate >= 1 || rand.Float64() < c.rate
+}
+
+// captureResponseWriter keeps a copy of the response body written through
+// it.
+type captureResponseWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *captureResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.buf.Write(p[:n])
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (w *captureResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// body returns the captured body, decompressed if the codec compressed it.
+// The compressed body is returned if it can't be decompressed.
+func (w *captureResponseWriter) body() []byte {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(w.Header().Get("Content-Encoding")) {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(w.buf.Bytes()))
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(w.buf.Bytes()))
+	default:
+		return w.buf.Bytes()
+	}
+	if err != nil {
+		return w.buf.Bytes()
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return w.buf.Bytes()
+	}
+	return b
+}
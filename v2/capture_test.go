@@ -0,0 +1,81 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipResponseWriter compresses the response like a compressing codec.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w gzipResponseWriter) Write(p []byte) (int, error) {
+	w.Header().Set("Content-Encoding", "gzip")
+	gw := gzip.NewWriter(w.ResponseWriter)
+	defer gw.Close()
+	return gw.Write(p)
+}
+
+func TestPayloadCapture(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.SetRequestDecompression(1 << 20)
+	s.RegisterResponseInterceptFunc(func(w http.ResponseWriter, i *RequestInfo) http.ResponseWriter {
+		return gzipResponseWriter{w}
+	})
+	type payload struct {
+		method    string
+		req, resp string
+	}
+	var captured []payload
+	sink := func(method string, req, resp []byte) {
+		captured = append(captured, payload{method, string(req), string(resp)})
+	}
+
+	const body = `{"method":"Service1.Multiply","params":{"A":4,"B":5}}`
+	call := func() *httptest.ResponseRecorder {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(body))
+		zw.Close()
+		r, err := http.NewRequest("POST", "", &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	s.EnablePayloadCapture(1, sink)
+	if w := call(); w.Code != 200 {
+		t.Fatalf("Status was %d, should be 200.", w.Code)
+	}
+	if len(captured) != 1 {
+		t.Fatalf("Captured %d payloads, should be 1.", len(captured))
+	}
+	p := captured[0]
+	if p.method != "Service1.Multiply" || p.req != body || strings.TrimSpace(p.resp) != `{"Result":20}` {
+		t.Errorf("Captured %+v, should be the uncompressed payloads.", p)
+	}
+
+	s.EnablePayloadCapture(0, sink)
+	call()
+	if len(captured) != 1 {
+		t.Errorf("Captured %d payloads, should be 1 once disabled.", len(captured))
+	}
+}
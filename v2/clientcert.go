@@ -0,0 +1,38 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+// contextKey is the type of the context keys defined by this package.
+type contextKey struct {
+	name string
+}
+
+// ClientCertContextKey is the request context key under which the server
+// stores the verified *x509.Certificate of the client when enabled with
+// EnableClientCertContext.
+var ClientCertContextKey = &contextKey{"client-cert"}
+
+// ClientCertificate returns the verified client certificate stored in ctx
+// by a server with EnableClientCertContext, if any.
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(ClientCertContextKey).(*x509.Certificate)
+	return cert, ok
+}
+
+// withClientCert returns r with the leaf certificate of its first verified
+// chain in the context, or r itself if there is none.
+func withClientCert(r *http.Request) *http.Request {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), ClientCertContextKey, r.TLS.VerifiedChains[0][0])
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,50 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+)
+
+func TestClientCertContext(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.EnableClientCertContext()
+
+	var cert *x509.Certificate
+	var found bool
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		cert, found = ClientCertificate(i.Request.Context())
+	})
+
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+	tests := []struct {
+		state *tls.ConnectionState
+		cert  *x509.Certificate
+	}{
+		{nil, nil},
+		{&tls.ConnectionState{}, nil},
+		{&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}, leaf},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock")
+		r.TLS = tt.state
+		s.ServeHTTP(NewMockResponseWriter(), r)
+		if found != (tt.cert != nil) || cert != tt.cert {
+			t.Errorf("Certificate was %v (%v), should be %v.", cert, found, tt.cert)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RegisterCodecChecked is like RegisterCodec, but first runs a self-test of
+// the codec on a trivial request: a POST to "/" with the content type and
+// an empty JSON object as body. The codec must return a request for it
+// whose Method and WriteError don't panic; Method may return an error. The
+// codec isn't registered if the test fails, so a misconfigured codec is
+// reported at startup rather than on the first real request.
+//
+// Use RegisterCodec to skip the test.
+func (s *Server) RegisterCodecChecked(codec Codec, contentType string) error {
+	if err := checkCodec(codec, contentType); err != nil {
+		return err
+	}
+	s.RegisterCodec(codec, contentType)
+	return nil
+}
+
+// errCodecCheck is the error written by the codecs under test.
+var errCodecCheck = errors.New("rpc: codec self-test")
+
+// checkCodec runs the self-test of RegisterCodecChecked on codec.
+func checkCodec(codec Codec, contentType string) (err error) {
+	if codec == nil {
+		return fmt.Errorf("rpc: nil codec for %q", contentType)
+	}
+	step := "NewRequest"
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("rpc: codec for %q panics in %s: %v", contentType, step, v)
+		}
+	}()
+	r, err := http.NewRequest("POST", "/", strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", contentType)
+	codecReq := codec.NewRequest(r)
+	if codecReq == nil {
+		return fmt.Errorf("rpc: codec for %q returns a nil request", contentType)
+	}
+	step = "Method"
+	codecReq.Method()
+	step = "WriteError"
+	codecReq.WriteError(&discardResponseWriter{header: make(http.Header)}, http.StatusBadRequest, errCodecCheck)
+	return nil
+}
+
+// discardResponseWriter discards the response written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return io.Discard.Write(p)
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {
+}
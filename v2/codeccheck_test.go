@@ -0,0 +1,80 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// brokenCodec panics in the given step of the codec self-test.
+type brokenCodec struct {
+	panicIn string
+}
+
+func (c brokenCodec) NewRequest(r *http.Request) CodecRequest {
+	switch c.panicIn {
+	case "NewRequest":
+		panic("no request")
+	case "nil":
+		return nil
+	}
+	return brokenCodecRequest(c)
+}
+
+type brokenCodecRequest brokenCodec
+
+func (c brokenCodecRequest) Method() (string, error) {
+	if c.panicIn == "Method" {
+		panic("no method")
+	}
+	return "", nil
+}
+
+func (c brokenCodecRequest) ReadRequest(args interface{}) error {
+	return nil
+}
+
+func (c brokenCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+}
+
+func (c brokenCodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	if c.panicIn == "WriteError" {
+		var m map[string]int
+		m["status"] = status
+	}
+}
+
+func TestRegisterCodecChecked(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterCodecChecked(NewTestCodec(), "application/json"); err != nil {
+		t.Errorf("Expected the test codec to pass, got %v", err)
+	}
+	if err := s.RegisterCodecChecked(brokenCodec{}, "application/x-ok"); err != nil {
+		t.Errorf("Expected a working codec to pass, got %v", err)
+	}
+
+	tests := []struct {
+		codec Codec
+		error string
+	}{
+		{nil, "nil codec"},
+		{brokenCodec{"NewRequest"}, "panics in NewRequest: no request"},
+		{brokenCodec{"nil"}, "returns a nil request"},
+		{brokenCodec{"Method"}, "panics in Method: no method"},
+		{brokenCodec{"WriteError"}, "panics in WriteError: assignment to entry in nil map"},
+	}
+	for _, tt := range tests {
+		err := s.RegisterCodecChecked(tt.codec, "application/x-broken")
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("Error was %v, should contain %q", err, tt.error)
+		}
+	}
+	codecs := strings.Join(s.RegisteredCodecs(), ", ")
+	if codecs != "application/json, application/x-ok" {
+		t.Errorf("Registered codecs were %s, should not include the broken ones", codecs)
+	}
+}
@@ -8,7 +8,10 @@ package rpc
 import (
 	"compress/flate"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 	"unicode"
@@ -26,10 +29,15 @@ func (gw *gzipWriter) Write(p []byte) (n int, err error) {
 
 // gzipEncoder implements the gzip compressed http encoder.
 type gzipEncoder struct {
+	skip []string // content types not compressed
 }
 
 func (enc *gzipEncoder) Encode(w http.ResponseWriter) io.Writer {
+	if skipCompression(w, enc.skip) {
+		return w
+	}
 	w.Header().Set("Content-Encoding", "gzip")
+	addVary(w.Header(), "Accept-Encoding")
 	return &gzipWriter{gzip.NewWriter(w)}
 }
 
@@ -45,23 +53,92 @@ func (fw *flateWriter) Write(p []byte) (n int, err error) {
 
 // flateEncoder implements the flate compressed http encoder.
 type flateEncoder struct {
+	skip []string // content types not compressed
 }
 
 func (enc *flateEncoder) Encode(w http.ResponseWriter) io.Writer {
+	if skipCompression(w, enc.skip) {
+		return w
+	}
 	fw, err := flate.NewWriter(w, flate.DefaultCompression)
 	if err != nil {
 		return w
 	}
 	w.Header().Set("Content-Encoding", "deflate")
+	addVary(w.Header(), "Accept-Encoding")
 	return &flateWriter{fw}
 }
 
+// addVary adds name to the "Vary" header unless it's already there, so
+// caches don't serve the compressed body to clients that can't decode it.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// DefaultSkipContentTypes are the content types of already compressed
+// responses, not compressed again by a CompressionSelector without explicit
+// SkipContentTypes.
+var DefaultSkipContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"video/*",
+	"audio/*",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"font/woff2",
+}
+
 // CompressionSelector generates the compressed http encoder.
+//
+// The decision to compress is taken when the codec encodes the response,
+// once it has set the Content-Type: responses with one of the
+// SkipContentTypes, like RawResponse replies with an already compressed
+// body, are written as is.
 type CompressionSelector struct {
+	// SkipContentTypes are the media types not compressed, either exact,
+	// like "application/zip", or with a wildcard subtype, like "image/*".
+	// If nil, DefaultSkipContentTypes is used.
+	SkipContentTypes []string
+}
+
+// skipCompression returns true if the Content-Type of the response matches
+// one of the skip patterns.
+func skipCompression(w http.ResponseWriter, skip []string) bool {
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range skip {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType ||
+			strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
 }
 
 // Select method selects the correct compression encoder based on http HEADER.
-func (*CompressionSelector) Select(r *http.Request) Encoder {
+func (s *CompressionSelector) Select(r *http.Request) Encoder {
+	skip := s.SkipContentTypes
+	if skip == nil {
+		skip = DefaultSkipContentTypes
+	}
 	encHeader := r.Header.Get("Accept-Encoding")
 	encTypes := strings.FieldsFunc(encHeader, func(r rune) bool {
 		return unicode.IsSpace(r) || r == ','
@@ -70,11 +147,131 @@ func (*CompressionSelector) Select(r *http.Request) Encoder {
 	for _, enc := range encTypes {
 		switch enc {
 		case "gzip":
-			return &gzipEncoder{}
+			return &gzipEncoder{skip: skip}
 		case "deflate":
-			return &flateEncoder{}
+			return &flateEncoder{skip: skip}
 		}
 	}
 
 	return DefaultEncoder
 }
+
+// DefaultCompressionThreshold is the minimum response body size, in bytes,
+// compressed by a ThresholdCompressionSelector without an explicit Threshold.
+const DefaultCompressionThreshold = 1024
+
+// ThresholdCompressionSelector generates the compressed http encoder like
+// CompressionSelector, but only compresses response bodies of at least
+// Threshold bytes. Smaller bodies are written as is, since compressing them
+// wastes CPU and often grows the payload.
+type ThresholdCompressionSelector struct {
+	CompressionSelector
+	// Threshold is the minimum body size in bytes to compress. If zero,
+	// DefaultCompressionThreshold is used.
+	Threshold int
+}
+
+// Select method selects the compression encoder based on http HEADER,
+// deferring the decision to compress until the body is written.
+func (s *ThresholdCompressionSelector) Select(r *http.Request) Encoder {
+	enc := s.CompressionSelector.Select(r)
+	if enc == DefaultEncoder {
+		return DefaultEncoder
+	}
+	threshold := s.Threshold
+	if threshold == 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return &thresholdEncoder{enc: enc, threshold: threshold}
+}
+
+// thresholdEncoder wraps a compressing encoder, only using it for bodies of
+// at least threshold bytes.
+type thresholdEncoder struct {
+	enc       Encoder
+	threshold int
+}
+
+func (e *thresholdEncoder) Encode(w http.ResponseWriter) io.Writer {
+	return &thresholdWriter{w: w, enc: e.enc, threshold: e.threshold}
+}
+
+// thresholdWriter picks the plain or compressed writer once the body is
+// written, as the compressed writers do it in a single Write.
+type thresholdWriter struct {
+	w         http.ResponseWriter
+	enc       Encoder
+	threshold int
+}
+
+func (tw *thresholdWriter) Write(p []byte) (n int, err error) {
+	if len(p) < tw.threshold {
+		return tw.w.Write(p)
+	}
+	return tw.enc.Encode(tw.w).Write(p)
+}
+
+// ErrRequestTooLarge is returned reading a decompressed request body past
+// the limit set with SetRequestDecompression.
+var ErrRequestTooLarge = errors.New("rpc: decompressed request body too large")
+
+// gzipBody reads the decompressed request body, failing past remaining
+// bytes, and closes the original one.
+type gzipBody struct {
+	*gzip.Reader
+	body      io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrRequestTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.Reader.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.exceeded = true
+		return n, ErrRequestTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// status returns the status of an error reading the request body: 413 if
+// the decompressed body exceeded its limit, status otherwise. b may be nil
+// for requests that weren't decompressed.
+func (b *gzipBody) status(status int) int {
+	if b != nil && b.exceeded {
+		return http.StatusRequestEntityTooLarge
+	}
+	return status
+}
+
+// decompressRequest replaces the body of a request with a "Content-Encoding"
+// of gzip by the decompressed body, limited to max bytes, so codecs can
+// read it as is. Nothing is done if max is zero. It returns the new body,
+// or nil if the request isn't decompressed.
+func decompressRequest(r *http.Request, max int64) (*gzipBody, error) {
+	if max <= 0 || r.Body == nil || !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return nil, nil
+	}
+	gr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("rpc: invalid gzip request body: %v", err)
+	}
+	body := &gzipBody{Reader: gr, body: r.Body, remaining: max}
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return body, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThresholdCompressionSelector(t *testing.T) {
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+	s := &ThresholdCompressionSelector{Threshold: 16}
+
+	// Small bodies are written as is.
+	w := httptest.NewRecorder()
+	if _, err := s.Select(r).Encode(w).Write([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding was %q, should be empty.", enc)
+	}
+	if w.Body.String() != "small" {
+		t.Errorf("Response body was %q, should be %q.", w.Body, "small")
+	}
+
+	// Large bodies are compressed.
+	large := strings.Repeat("large", 10)
+	w = httptest.NewRecorder()
+	if _, err := s.Select(r).Encode(w).Write([]byte(large)); err != nil {
+		t.Fatal(err)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("Content-Encoding was %q, should be gzip.", enc)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(gr); err != nil || string(b) != large {
+		t.Errorf("Decompressed body was %q (%v), should be %q.", b, err, large)
+	}
+
+	// Without compression support the default encoder is used.
+	r.Header.Del("Accept-Encoding")
+	if enc := s.Select(r); enc != DefaultEncoder {
+		t.Errorf("Encoder was %T, should be the DefaultEncoder.", enc)
+	}
+}
+
+func TestCompressionSkipContentTypes(t *testing.T) {
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	tests := []struct {
+		selector    EncoderSelector
+		contentType string
+		compressed  bool
+	}{
+		{&CompressionSelector{}, "application/json; charset=utf-8", true},
+		{&CompressionSelector{}, "image/png", false},
+		{&CompressionSelector{}, "Application/Zip", false},
+		{&CompressionSelector{}, "video/mp4", false},
+		{&CompressionSelector{}, "", true},
+		{&CompressionSelector{SkipContentTypes: []string{"text/*"}}, "text/csv", false},
+		{&CompressionSelector{SkipContentTypes: []string{"text/*"}}, "image/png", true},
+		{&ThresholdCompressionSelector{Threshold: 1}, "application/gzip", false},
+		{&ThresholdCompressionSelector{Threshold: 1}, "application/json", true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		if tt.contentType != "" {
+			w.Header().Set("Content-Type", tt.contentType)
+		}
+		if _, err := tt.selector.Select(r).Encode(w).Write([]byte("body")); err != nil {
+			t.Fatal(err)
+		}
+		if compressed := w.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Errorf("%T %q: compressed was %v, should be %v.", tt.selector, tt.contentType, compressed, tt.compressed)
+		}
+		if !tt.compressed && w.Body.String() != "body" {
+			t.Errorf("%T %q: body was %q, should be written as is.", tt.selector, tt.contentType, w.Body)
+		}
+	}
+}
+
+func TestCompressionVary(t *testing.T) {
+	s := &CompressionSelector{}
+	for _, tt := range []struct {
+		acceptEncoding string
+		vary           string
+	}{
+		{"gzip", "Accept-Encoding"},
+		{"deflate", "Accept-Encoding"},
+		{"", ""},
+	} {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		w.Header().Set("Vary", "Origin")
+		enc := s.Select(r)
+		enc.Encode(w)
+		enc.Encode(w)
+
+		expected := []string{"Origin"}
+		if tt.vary != "" {
+			expected = append(expected, tt.vary)
+		}
+		if vary := w.Header().Values("Vary"); strings.Join(vary, ",") != strings.Join(expected, ",") {
+			t.Errorf("Accept-Encoding %q: Vary was %q, should be %q.", tt.acceptEncoding, vary, expected)
+		}
+	}
+}
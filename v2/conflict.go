@@ -0,0 +1,37 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+// ConflictPolicy tells how RegisterServiceWithOptions handles the methods
+// already defined in the service being registered.
+type ConflictPolicy int
+
+const (
+	// ConflictError rejects the registration, as RegisterService does: it's
+	// an error if the service is already defined.
+	ConflictError ConflictPolicy = iota
+	// ConflictSkip keeps the methods already defined and adds the new ones.
+	ConflictSkip
+	// ConflictOverwrite replaces the methods already defined, dropping
+	// their settings such as pooling, middleware or timeouts, and adds the
+	// new ones.
+	ConflictOverwrite
+)
+
+// ServiceOption configures the registration of a service, see
+// RegisterServiceWithOptions.
+type ServiceOption func(*serviceOptions)
+
+type serviceOptions struct {
+	conflict ConflictPolicy
+}
+
+// OnConflict sets how the methods already defined in the service are
+// handled. The default is ConflictError.
+func OnConflict(policy ConflictPolicy) ServiceOption {
+	return func(o *serviceOptions) {
+		o.conflict = policy
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+)
+
+// MethodContextKey is the request context key under which the server
+// stores the resolved name of the method, in the "Service.Method" notation,
+// for the method and its middleware.
+var MethodContextKey = &contextKey{"method"}
+
+// MethodFromContext returns the name of the method stored in ctx by the
+// server, if any.
+func MethodFromContext(ctx context.Context) (string, bool) {
+	method, ok := ctx.Value(MethodContextKey).(string)
+	return method, ok
+}
+
+// withMethod returns r with the name of the method in the context.
+func withMethod(r *http.Request, method string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), MethodContextKey, method))
+}
@@ -0,0 +1,55 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type WhoAmIService struct {
+}
+
+func (t *WhoAmIService) Name(r *http.Request, args *struct{}, reply *string) error {
+	*reply, _ = MethodFromContext(r.Context())
+	return nil
+}
+
+func TestMethodFromContext(t *testing.T) {
+	s := NewServer()
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterService(new(WhoAmIService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterAliasWithTransform("WhoAmI.Name", "WhoAmIService.Name", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"WhoAmIService.Name", "WhoAmI.Name"} {
+		body := `{"method":"` + method + `","params":{}}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != `"WhoAmIService.Name"` {
+			t.Errorf("For %s, response was %d %s, should be the resolved method name", method, w.Code, w.Body)
+		}
+	}
+
+	reply, err := CallMethod(s, "WhoAmIService.Name", struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := *reply.(*string); name != "WhoAmIService.Name" {
+		t.Errorf("CallMethod stored %q, should store the method name", name)
+	}
+
+	if _, ok := MethodFromContext(context.Background()); ok {
+		t.Error("Expected no method in an empty context")
+	}
+}
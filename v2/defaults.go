@@ -0,0 +1,68 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// SetMethodDefaults sets default args for the given method: defaults is a
+// value of its args type, or a pointer to one. After the args of a request
+// are decoded, and before the ValidateRequestFunc is called, each non-zero
+// field of defaults is copied onto the corresponding field of the args if
+// it's zero. Nested structs are handled field by field. Nil removes the
+// defaults.
+//
+// A field explicitly set to its zero value in a request, such as 0 or
+// false, can't be told apart from a missing one and gets the default. Use
+// a pointer field where the zero value is meaningful: a nil pointer gets
+// the default, a pointer to zero is kept. Pointer, slice and map defaults
+// are shared by the requests, not copied, so methods must not modify them.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodDefaults(method string, defaults interface{}) error {
+	_, methodSpec, err := s.services.get(method)
+	if err != nil {
+		return err
+	}
+	if defaults == nil {
+		return s.services.setDefaults(method, nil)
+	}
+	if methodSpec.stream {
+		return fmt.Errorf("rpc: %q reads the request body itself, it has no defaults", method)
+	}
+	v := reflect.ValueOf(defaults)
+	if v.Type() == reflect.PtrTo(methodSpec.argsType) {
+		if v.IsNil() {
+			return s.services.setDefaults(method, nil)
+		}
+		v = v.Elem()
+	}
+	if v.Type() != methodSpec.argsType {
+		return fmt.Errorf("rpc: defaults of %q are a %T, should be a %s", method, defaults, methodSpec.argsType)
+	}
+	// Keep a copy, so that later changes to defaults don't race with the
+	// requests.
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return s.services.setDefaults(method, &c)
+}
+
+// applyDefaults copies the non-zero values of src onto the zero values of
+// dst, field by field for structs.
+func applyDefaults(dst, src reflect.Value) {
+	if src.Kind() == reflect.Struct {
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).IsExported() {
+				applyDefaults(dst.Field(i), src.Field(i))
+			}
+		}
+		return
+	}
+	if dst.IsZero() && !src.IsZero() {
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ListOptions struct {
+	Order string
+	Limit *int
+}
+
+type ListArgs struct {
+	Query string
+	Page  int
+	Size  int
+	ListOptions
+}
+
+type ListReply struct {
+	Args ListArgs
+}
+
+type ListService struct {
+}
+
+func (t *ListService) List(r *http.Request, args *ListArgs, reply *ListReply) error {
+	reply.Args = *args
+	return nil
+}
+
+func TestMethodDefaults(t *testing.T) {
+	s := NewServer()
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterService(new(ListService), ""); err != nil {
+		t.Fatal(err)
+	}
+	limit := 50
+	if err := s.SetMethodDefaults("ListService.List", ListArgs{Page: 1, Size: 20, ListOptions: ListOptions{Order: "asc", Limit: &limit}}); err != nil {
+		t.Fatal(err)
+	}
+	var validated ListArgs
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		validated = *args.(*ListArgs)
+		return nil
+	})
+
+	tests := []struct {
+		params, expected string
+	}{
+		{`{}`, `{"Query":"","Page":1,"Size":20,"Order":"asc","Limit":50}`},
+		{`{"Query":"x","Size":5,"Order":"desc"}`, `{"Query":"x","Page":1,"Size":5,"Order":"desc","Limit":50}`},
+		// An explicit zero can't be told apart from a missing value, except
+		// behind a pointer.
+		{`{"Page":0,"Limit":0}`, `{"Query":"","Page":1,"Size":20,"Order":"asc","Limit":0}`},
+	}
+	for _, test := range tests {
+		body := `{"method":"ListService.List","params":` + test.params + `}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Status was %d, should be 200: %s", w.Code, w.Body)
+		}
+		expected := `{"Args":` + test.expected + "}"
+		if w.Body.String() != expected {
+			t.Errorf("For %s, response was %s, should be %s", test.params, w.Body, expected)
+		}
+		if validated.Page != 1 {
+			t.Errorf("For %s, validator got %+v, should get the defaults", test.params, validated)
+		}
+	}
+	if limit != 50 {
+		t.Errorf("Default limit was changed to %d", limit)
+	}
+
+	reply, err := CallMethod(s, "ListService.List", ListArgs{Query: "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := reply.(*ListReply).Args; args.Query != "y" || args.Size != 20 {
+		t.Errorf("CallMethod got %+v, should get the defaults", args)
+	}
+
+	if err := s.SetMethodDefaults("ListService.List", nil); err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := CallMethod(s, "ListService.List", ListArgs{}); reply.(*ListReply).Args.Size != 0 {
+		t.Errorf("Defaults were not removed")
+	}
+
+	if err := s.SetMethodDefaults("ListService.List", &ListReply{}); err == nil {
+		t.Error("Expected an error for defaults of the wrong type")
+	}
+	if err := s.SetMethodDefaults("ListService.Missing", ListArgs{}); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
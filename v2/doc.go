@@ -74,6 +74,46 @@ and make available the ones that follow these rules:
 
 All other methods are ignored.
 
+Methods that have side effects but no meaningful result can omit the *reply
+argument. For those, the codec is asked to write a nil reply:
+
+	func (h *HelloService) Notify(r *http.Request, args *HelloArgs) error {
+		return nil
+	}
+
+Codecs that support it, like json2, write the response through an Encoder
+chosen per request by an EncoderSelector. DefaultEncoderSelector never
+encodes the response and CompressionSelector compresses it according to the
+"Accept-Encoding" header. A custom selector only needs to implement both
+interfaces, e.g. to compress responses only when a header asks for it:
+
+	type headerSelector struct{}
+
+	func (headerSelector) Select(r *http.Request) rpc.Encoder {
+		if r.Header.Get("X-Compress") == "gzip" {
+			return gzipEncoder{}
+		}
+		return rpc.DefaultEncoder
+	}
+
+	type gzipEncoder struct{}
+
+	func (gzipEncoder) Encode(w http.ResponseWriter) io.Writer {
+		w.Header().Set("Content-Encoding", "gzip")
+		return &gzipWriter{gzip.NewWriter(w)}
+	}
+
+	type gzipWriter struct {
+		w *gzip.Writer
+	}
+
+	func (gw *gzipWriter) Write(p []byte) (int, error) {
+		defer gw.w.Close()
+		return gw.w.Write(p)
+	}
+
+	s.RegisterCodec(json2.NewCustomCodec(headerSelector{}), "application/json")
+
 Gorilla has packages with common RPC codecs. Check out their documentation:
 
 	JSON: http://gorilla-web.appspot.com/pkg/rpc/json
@@ -12,6 +12,10 @@ import (
 
 // Encoder interface contains the encoder for http response.
 // Eg. gzip, flate compressions.
+//
+// Encode is called by codecs right before writing the response body. It may
+// set response headers, e.g. "Content-Encoding", and returns the writer the
+// body must be written to.
 type Encoder interface {
 	Encode(w http.ResponseWriter) io.Writer
 }
@@ -23,12 +27,17 @@ func (_ *encoder) Encode(w http.ResponseWriter) io.Writer {
 	return w
 }
 
+// DefaultEncoder is the identity Encoder: it writes the response body to
+// the http.ResponseWriter as is.
 var DefaultEncoder = &encoder{}
 
 // EncoderSelector interface provides a way to select encoder using the http
 // request. Typically people can use this to check HEADER of the request and
 // figure out client capabilities.
 // Eg. "Accept-Encoding" tells about supported compressions.
+//
+// Codecs that support encoders, like json2, accept an EncoderSelector on
+// construction and call Select once per request.
 type EncoderSelector interface {
 	Select(r *http.Request) Encoder
 }
@@ -40,4 +49,6 @@ func (_ *encoderSelector) Select(_ *http.Request) Encoder {
 	return DefaultEncoder
 }
 
+// DefaultEncoderSelector is the identity EncoderSelector: it always selects
+// the DefaultEncoder, so responses are never encoded.
 var DefaultEncoderSelector = &encoderSelector{}
@@ -0,0 +1,200 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes of the errors implementing ErrorCoder.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ErrorCoder is implemented by errors carrying a JSON-RPC error code, such
+// as the ones written when the method isn't found or a validator rejects the
+// args, so codecs can report it.
+type ErrorCoder interface {
+	ErrorCode() int
+}
+
+// ErrorStatuser is implemented by the codec requests choosing the HTTP
+// status of the errors returned by the methods, 400 Bad Request otherwise,
+// e.g. from the code they report. HTTPStatuser errors, timeouts and
+// MultiError keep their status.
+type ErrorStatuser interface {
+	ErrorStatus(err error) int
+}
+
+// ErrMethodNotFound is matched, using errors.Is, by the errors written when
+// the requested method isn't registered, so codecs can report them with
+// the matching code of their protocol.
+var ErrMethodNotFound = errors.New("rpc: method not found")
+
+// methodNotFoundError is an error matching ErrMethodNotFound with a message
+// naming the method.
+type methodNotFoundError struct {
+	msg string
+}
+
+func (e *methodNotFoundError) Error() string {
+	return e.msg
+}
+
+func (e *methodNotFoundError) Is(target error) bool {
+	return target == ErrMethodNotFound
+}
+
+// ErrorCode implements ErrorCoder.
+func (e *methodNotFoundError) ErrorCode() int {
+	return CodeMethodNotFound
+}
+
+// ErrMethodMalformed is matched, using errors.Is, by the error written with
+// status 400 Bad Request when the codec reads an empty method name, e.g.
+// from a request missing it that a lenient codec accepted.
+var ErrMethodMalformed = errors.New("rpc: method malformed")
+
+// errEmptyMethod is the error written for an empty method name.
+var errEmptyMethod error = &malformedError{fmt.Errorf("%w: empty method name", ErrMethodMalformed)}
+
+// malformedError is an error matching ErrMethodMalformed, reported as an
+// invalid request.
+type malformedError struct {
+	error
+}
+
+func (e *malformedError) Unwrap() error {
+	return e.error
+}
+
+// ErrorCode implements ErrorCoder.
+func (e *malformedError) ErrorCode() int {
+	return CodeInvalidRequest
+}
+
+// invalidParamsError is an error of the validator, reported as invalid
+// params.
+type invalidParamsError struct {
+	error
+}
+
+func (e *invalidParamsError) Unwrap() error {
+	return e.error
+}
+
+// ErrorCode implements ErrorCoder.
+func (e *invalidParamsError) ErrorCode() int {
+	return CodeInvalidParams
+}
+
+// invalidParams returns err, an error of the validator, carrying the
+// CodeInvalidParams code unless it carries its own.
+func invalidParams(err error) error {
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return err
+	}
+	return &invalidParamsError{err}
+}
+
+// HTTPStatuser is implemented by errors that choose the HTTP status of their
+// response, so a method can report e.g. 404 Not Found or 409 Conflict. The
+// server writes the error with this status, if between 400 and 599,
+// instead of the one it chose, whatever the codec, and reports it in
+// RequestInfo.StatusCode and to the metrics.
+type HTTPStatuser interface {
+	HTTPStatus() int
+}
+
+// RetryableError is returned by a method to throttle the client, e.g. when
+// its own rate limit is exceeded. The server writes it with status 429 Too
+// Many Requests, whatever the codec, and a "Retry-After" header with
+// RetryAfter in seconds, rounded up, if positive. The message written is
+// the one of Err.
+type RetryableError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RetryableError) Error() string {
+	if e.Err == nil {
+		return "rpc: too many requests"
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns Err, so errors.Is and errors.As match it.
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus implements HTTPStatuser.
+func (e *RetryableError) HTTPStatus() int {
+	return http.StatusTooManyRequests
+}
+
+// retryAfter returns the value of the "Retry-After" header, empty if
+// RetryAfter isn't positive.
+func (e *RetryableError) retryAfter() string {
+	if e.RetryAfter <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64((e.RetryAfter+time.Second-1)/time.Second), 10)
+}
+
+// MultiError collects several errors, e.g. the problems with each field of
+// a request found by a validator, so they are all reported to the client.
+// The server writes it with status 400 Bad Request; codecs may report each
+// entry, as the json2 codec does in the error Data.
+//
+// The zero value is an empty MultiError ready to use.
+type MultiError struct {
+	Errors []error
+}
+
+// Add appends err to the errors, unless it's nil.
+func (m *MultiError) Add(err error) {
+	if err != nil {
+		m.Errors = append(m.Errors, err)
+	}
+}
+
+// Err returns m, or nil if it has no errors, so a validator can return it
+// without wrapping a nil *MultiError in a non-nil error.
+func (m *MultiError) Err() error {
+	if len(m.Errors) == 0 {
+		return nil
+	}
+	return m
+}
+
+// Error returns the messages of the errors, separated by semicolons.
+func (m *MultiError) Error() string {
+	msgs := make([]string, len(m.Errors))
+	for i, err := range m.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors, so errors.Is and errors.As match any of them.
+func (m *MultiError) Unwrap() []error {
+	return m.Errors
+}
+
+// ErrorCode implements ErrorCoder: the errors are about the args.
+func (m *MultiError) ErrorCode() int {
+	return CodeInvalidParams
+}
@@ -0,0 +1,45 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/formdata provides a codec for multipart/form-data
+requests, to call RPC methods that accept file uploads.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/formdata"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(formdata.NewCodec(), "multipart/form-data")
+		// [...]
+		http.Handle("/rpc/", s)
+	}
+
+The method is taken from the "method" form field or, if absent, from the
+last element of the request path, e.g. "/rpc/Service.Method". The "json"
+part, a form field or a file, is decoded into the args of the method. Args
+implementing FileReceiver receive the file parts of the form:
+
+	type UploadArgs struct {
+		Name  string
+		Files []*multipart.FileHeader
+	}
+
+	func (a *UploadArgs) SetFiles(files map[string][]*multipart.FileHeader) {
+		a.Files = files["file"]
+	}
+
+Responses are JSON encoded, as {"result": reply} on success and
+{"error": message} on failure.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package formdata
@@ -0,0 +1,198 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+type UploadArgs struct {
+	Name  string
+	Files []*multipart.FileHeader
+}
+
+func (a *UploadArgs) SetFiles(files map[string][]*multipart.FileHeader) {
+	a.Files = files["file"]
+}
+
+type UploadReply struct {
+	Name string
+	Size int
+	Data string
+}
+
+type Upload struct{}
+
+func (u *Upload) Save(r *http.Request, args *UploadArgs, reply *UploadReply) error {
+	reply.Name = args.Name
+	for _, fh := range args.Files {
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		reply.Size += len(b)
+		reply.Data += string(b)
+	}
+	return nil
+}
+
+func execute(t *testing.T, s *rpc.Server, url string, fields map[string]string, files map[string]string) (int, map[string]json.RawMessage) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for k, v := range files {
+		fw, err := mw.CreateFormFile(k, k+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(v))
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", url, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var res map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+func TestUpload(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "multipart/form-data")
+	if err := s.RegisterService(new(Upload), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		fields map[string]string
+		files  map[string]string
+	}{
+		{"/rpc", map[string]string{"method": "Upload.Save", "json": `{"Name":"a"}`}, map[string]string{"file": "hello"}},
+		{"/rpc/Upload.Save", map[string]string{"json": `{"Name":"a"}`}, map[string]string{"file": "hello"}},
+		{"/rpc/Upload.Save", nil, map[string]string{"json": `{"Name":"a"}`, "file": "hello"}},
+	}
+	for _, tt := range tests {
+		code, res := execute(t, s, tt.url, tt.fields, tt.files)
+		if code != 200 {
+			t.Fatalf("Status was %d, should be 200: %s", code, res["error"])
+		}
+		var reply UploadReply
+		if err := json.Unmarshal(res["result"], &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply != (UploadReply{"a", 5, "hello"}) {
+			t.Errorf("Reply was %+v", reply)
+		}
+	}
+}
+
+func TestUploadTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	s := rpc.NewServer()
+	codec := NewCodec()
+	codec.SetMaxMemory(1)
+	s.RegisterCodec(codec, "multipart/form-data")
+	if err := s.RegisterService(new(Upload), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(bytes.Repeat([]byte("x"), 1<<20))
+	code, res := execute(t, s, "/rpc/Upload.Save", nil, map[string]string{"file": data})
+	if code != 200 {
+		t.Fatalf("Status was %d, should be 200: %s", code, res["error"])
+	}
+	var reply UploadReply
+	if err := json.Unmarshal(res["result"], &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Size != len(data) {
+		t.Errorf("Reply size was %d, should be %d", reply.Size, len(data))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Temporary files were left: %v", entries)
+	}
+}
+
+func TestUploadTempFilesNotification(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	s := rpc.NewServer()
+	codec := NewCodec()
+	codec.SetMaxMemory(1)
+	s.RegisterCodec(codec, "multipart/form-data")
+	if err := s.RegisterService(new(Upload), ""); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is written by the codec for notifications.
+	if err := s.RegisterNotification("Upload.Save"); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bytes.Repeat([]byte("x"), 1<<20))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/rpc/Upload.Save", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Status was %d, should be 204: %s", w.Code, w.Body)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Temporary files were left: %v", entries)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "multipart/form-data")
+	if err := s.RegisterService(new(Upload), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	code, res := execute(t, s, "/rpc", map[string]string{"json": "{}"}, nil)
+	if code != 400 || res["error"] == nil {
+		t.Errorf("Missing method: status %d, error %s", code, res["error"])
+	}
+	code, res = execute(t, s, "/rpc/Upload.Save", map[string]string{"json": "{"}, nil)
+	if code != 400 || res["error"] == nil {
+		t.Errorf("Invalid json: status %d, error %s", code, res["error"])
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formdata
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+// DefaultMaxMemory is the default number of bytes of the file parts kept in
+// memory, the rest being stored in temporary files.
+const DefaultMaxMemory = 32 << 20
+
+// ContentType is the Content-Type of the responses written by the codec.
+const ContentType = "application/json; charset=utf-8"
+
+// FileReceiver is implemented by args that accept the file parts of the
+// form, keyed by field name.
+type FileReceiver interface {
+	SetFiles(files map[string][]*multipart.FileHeader)
+}
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new multipart/form-data Codec.
+func NewCodec() *Codec {
+	return &Codec{maxMemory: DefaultMaxMemory}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+	maxMemory int64
+}
+
+// SetMaxMemory sets the number of bytes of the file parts kept in memory
+// while parsing the form. The default is DefaultMaxMemory.
+func (c *Codec) SetMaxMemory(n int64) {
+	c.maxMemory = n
+}
+
+// NewRequest returns a CodecRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &CodecRequest{
+		request: r,
+		err:     r.ParseMultipartForm(c.maxMemory),
+	}
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return ContentType
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	request *http.Request
+	err     error
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	if m := c.request.MultipartForm.Value["method"]; len(m) > 0 {
+		return m[0], nil
+	}
+	if m := path.Base(c.request.URL.Path); path.Ext(m) != "" {
+		return m, nil
+	}
+	return "", errors.New("rpc: missing method")
+}
+
+// ReadRequest fills the request object for the RPC method.
+//
+// The "json" part is decoded into args, which are left untouched if there
+// is none. If args implements FileReceiver, it is given the file parts.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	form := c.request.MultipartForm
+	var data []byte
+	if v := form.Value["json"]; len(v) > 0 {
+		data = []byte(v[0])
+	} else if f := form.File["json"]; len(f) > 0 {
+		file, err := f[0].Open()
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, args); err != nil {
+			return err
+		}
+	}
+	if fr, ok := args.(FileReceiver); ok {
+		fr.SetFiles(form.File)
+	}
+	return nil
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	c.writeServerResponse(w, http.StatusOK, map[string]interface{}{"result": reply})
+}
+
+// WriteError writes the error as {"error": message} with the given status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	c.writeServerResponse(w, status, map[string]interface{}{"error": err.Error()})
+}
+
+// Close removes the temporary files of the file parts once the request is
+// served, whether a response was written or not, so the methods must not
+// keep the FileHeaders. It implements io.Closer.
+func (c *CodecRequest) Close() error {
+	if form := c.request.MultipartForm; form != nil {
+		return form.RemoveAll()
+	}
+	return nil
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res interface{}) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(b)
+}
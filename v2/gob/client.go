@@ -0,0 +1,119 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// EncodeClientRequest encodes the method and its args for a gob client
+// request.
+func EncodeClientRequest(method string, args interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(&requestHeader{Method: method}); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(args); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeClientResponse decodes the response body of a client request into
+// reply, which may be nil for methods without a reply. Errors returned by
+// the server are decoded as *Error values.
+func DecodeClientResponse(r io.Reader, reply interface{}) error {
+	dec := gob.NewDecoder(r)
+	var header responseHeader
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Error != "" {
+		return &Error{Message: header.Error}
+	}
+	if reply == nil {
+		return nil
+	}
+	return dec.Decode(reply)
+}
+
+// ----------------------------------------------------------------------------
+// Client
+// ----------------------------------------------------------------------------
+
+// NewClient returns a new Client calling the server at url. If httpClient
+// is nil, http.DefaultClient is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{url: url, httpClient: httpClient}
+}
+
+// Client calls the methods of a gob RPC server over HTTP, reusing the
+// underlying http.Client. It is safe for concurrent use.
+type Client struct {
+	url           string
+	httpClient    *http.Client
+	gzipThreshold int
+}
+
+// SetGzipThreshold enables gzip compression of the request bodies of at
+// least n bytes, sent with "Content-Encoding: gzip". Smaller bodies are
+// sent uncompressed. Zero, the default, disables compression. The server
+// must enable decompression with rpc.Server.SetRequestDecompression.
+func (c *Client) SetGzipThreshold(n int) {
+	c.gzipThreshold = n
+}
+
+// Call calls the method with args and decodes the result into reply.
+//
+// The method uses a dotted notation as in "Service.Method". Errors returned
+// by the server are decoded as *Error values. Compressed responses are
+// decoded by the http.Client, which asks for gzip by default.
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	b, err := EncodeClientRequest(method, args)
+	if err != nil {
+		return err
+	}
+	compress := c.gzipThreshold > 0 && len(b) >= c.gzipThreshold
+	if compress {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), ContentType) {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("rpc: unexpected response %s: %s", res.Status, msg)
+	}
+	return DecodeClientResponse(res.Body, reply)
+}
@@ -0,0 +1,47 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/gob provides a codec for gob encoded RPC over HTTP, for
+services called by Go clients, without the overhead of JSON.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/gob"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(gob.NewCodec(), "application/x-gob")
+		// [...]
+		http.Handle("/rpc", s)
+	}
+
+The request body is a gob stream holding a header with the method name,
+in the dotted notation as in "Service.Method", followed by the args of the
+method. The response body is a gob stream holding a header with the error
+message, empty on success, followed by the reply unless there is an error
+or the method has no reply. As with encoding/gob, the concrete types of
+interface values must be registered with gob.Register.
+
+Responses are compressed by codecs created with NewCustomCodec and an
+rpc.EncoderSelector such as rpc.CompressionSelector. Requests sent with
+"Content-Encoding: gzip" are decompressed by servers enabling it with
+rpc.Server.SetRequestDecompression.
+
+The Client, or EncodeClientRequest and DecodeClientResponse, call the
+server from Go:
+
+	c := gob.NewClient("http://localhost:8080/rpc", nil)
+	var reply Service1Response
+	err := c.Call(ctx, "Service1.Multiply", &Service1Request{4, 2}, &reply)
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package gob
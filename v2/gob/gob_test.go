@@ -0,0 +1,194 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+var ErrResponseError = errors.New("response error")
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct {
+}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+func (t *Service1) ResponseError(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return ErrResponseError
+}
+
+func (t *Service1) Notify(r *http.Request, req *Service1Request) error {
+	return nil
+}
+
+func newServer(t *testing.T, codec *Codec) *rpc.Server {
+	s := rpc.NewServer()
+	s.RegisterCodec(codec, ContentType)
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestService(t *testing.T) {
+	s := newServer(t, NewCodec())
+
+	b, err := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	r.Header.Set("Content-Type", ContentType)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != 200 || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("Expected a 200 gob response, but got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var res Service1Response
+	if err := DecodeClientResponse(w.Body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	tests := []struct {
+		body   []byte
+		status int
+	}{
+		{[]byte("not gob"), 400},
+		{b[:len(b)-4], 400},
+	}
+	if b, err = EncodeClientRequest("Service1.Missing", &Service1Request{}); err != nil {
+		t.Fatal(err)
+	}
+	tests = append(tests, struct {
+		body   []byte
+		status int
+	}{b, 400})
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(tt.body))
+		r.Header.Set("Content-Type", ContentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("Expected status %d, but got %d", tt.status, w.Code)
+		}
+		var gobErr *Error
+		if err := DecodeClientResponse(w.Body, &res); !errors.As(err, &gobErr) {
+			t.Errorf("Expected an *Error, but got %v", err)
+		}
+	}
+}
+
+func TestBeforeFunc(t *testing.T) {
+	s := newServer(t, NewCodec())
+	var method string
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		method = i.Method
+	})
+
+	b, err := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	r.Header.Set("Content-Type", ContentType)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, but got %d: %s", w.Code, w.Body)
+	}
+	var res Service1Response
+	if err := DecodeClientResponse(w.Body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 || method != "Service1.Multiply" {
+		t.Errorf("Wrong response: %v, method %q.", res.Result, method)
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := newServer(t, NewCustomCodec(&rpc.CompressionSelector{}))
+	s.SetRequestDecompression(1 << 20)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	for _, threshold := range []int{0, 1} {
+		c.SetGzipThreshold(threshold)
+		var res Service1Response
+		if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Result != 8 {
+			t.Errorf("Wrong response: %v.", res.Result)
+		}
+	}
+
+	var res Service1Response
+	err := c.Call(context.Background(), "Service1.ResponseError", &Service1Request{4, 2}, &res)
+	if gobErr, ok := err.(*Error); !ok || gobErr.Message != ErrResponseError.Error() {
+		t.Errorf("Expected to get %q, but got %v", ErrResponseError, err)
+	}
+
+	if err := c.Call(context.Background(), "Service1.Notify", &Service1Request{4, 2}, nil); err != nil {
+		t.Errorf("Expected no error for a method without reply, but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Call(ctx, "Service1.Multiply", &Service1Request{4, 2}, &res); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected to get %v, but got %v", context.Canceled, err)
+	}
+}
+
+func TestCompressedResponse(t *testing.T) {
+	s := newServer(t, NewCustomCodec(&rpc.CompressionSelector{}))
+
+	b, err := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Expected a gzip response, but got Content-Encoding %q", ce)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Service1Response
+	if err := DecodeClientResponse(gr, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+}
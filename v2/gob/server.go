@@ -0,0 +1,157 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gob
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+// ContentType is the Content-Type of the requests and responses.
+const ContentType = "application/x-gob"
+
+// Error is the error returned by the client when the server responds with
+// an error.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// requestHeader is the first value of a request, before the args.
+type requestHeader struct {
+	Method string
+}
+
+// responseHeader is the first value of a response, before the reply.
+type responseHeader struct {
+	Error string
+}
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCustomCodec returns a new gob Codec encoding the responses with the
+// encoder chosen by encSel.
+func NewCustomCodec(encSel rpc.EncoderSelector) *Codec {
+	return &Codec{encSel: encSel}
+}
+
+// NewCodec returns a new gob Codec.
+func NewCodec() *Codec {
+	return NewCustomCodec(rpc.DefaultEncoderSelector)
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+	encSel rpc.EncoderSelector
+}
+
+// NewRequest returns a CodecRequest.
+//
+// The body is read and put back on the request, so that it can be decoded
+// again once the before funcs have run.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	req := &CodecRequest{encoder: c.encSel.Select(r)}
+	if r.Body == nil {
+		req.err = errors.New("rpc: empty request body")
+		return req
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		req.err = err
+		return req
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	req.decoder = gob.NewDecoder(bytes.NewReader(b))
+	req.err = req.decoder.Decode(&req.header)
+	return req
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return ContentType
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	header  requestHeader
+	decoder *gob.Decoder
+	encoder rpc.Encoder
+	err     error
+}
+
+// SetEncoder implements rpc.EncoderSetter, replacing the encoder selected
+// for the response.
+func (c *CodecRequest) SetEncoder(enc rpc.Encoder) {
+	c.encoder = enc
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	return c.header.Method, nil
+}
+
+// ReadRequest fills the request object for the RPC method.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil {
+		c.err = c.decoder.Decode(args)
+	}
+	return c.err
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+// The reply is omitted if nil, for methods without a reply.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	c.writeServerResponse(w, http.StatusOK, &responseHeader{}, reply)
+}
+
+// WriteError writes the error message in the response header, with the
+// given status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	c.writeServerResponse(w, status, &responseHeader{Error: err.Error()}, nil)
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, header *responseHeader, reply interface{}) {
+	// Encode to a buffer first, so an encoding error can still be written
+	// as a response, and the encoder gets the body in a single write.
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(header)
+	if err == nil && reply != nil {
+		err = enc.Encode(reply)
+	}
+	if err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	ew := c.encoder.Encode(w)
+	w.WriteHeader(status)
+	ew.Write(buf.Bytes())
+}
@@ -0,0 +1,45 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/grpcweb provides a codec for gRPC-Web clients over HTTP
+services.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/grpcweb"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(grpcweb.NewCodec(), "application/grpc-web+json")
+		// [...]
+		http.Handle("/", s)
+	}
+
+A codec is tied to a content type. In the example above, the server
+will use the gRPC-Web codec for requests with "application/grpc-web+json"
+as the value for the "Content-Type" header.
+
+The request and response messages are JSON encoded and wrapped in gRPC-Web
+length-prefixed frames: a flag byte followed by the 4-byte big-endian length
+of the message. The method is taken from the request path, which follows the
+gRPC convention of "/package.Service/Method" and is mapped to the
+"Service.Method" of the registered service.
+
+The response always has HTTP status 200. The outcome of the call is reported
+in a trailer frame carrying the "grpc-status" and "grpc-message" values
+expected by gRPC-Web clients. Errors returned by a method are mapped to gRPC
+status codes: an *Error keeps its own code, other errors are mapped from the
+HTTP status the server would have used.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package grpcweb
@@ -0,0 +1,140 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+var ErrResponseError = errors.New("response error")
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct {
+}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+func (t *Service1) ResponseError(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return ErrResponseError
+}
+
+func (t *Service1) Denied(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return &Error{Code: PermissionDenied, Message: "denied"}
+}
+
+func execute(t *testing.T, s *rpc.Server, path string, req interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	b, _ := json.Marshal(req)
+	if err := writeFrame(&buf, dataFlag, b); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("POST", "http://localhost:8080"+path, &buf)
+	r.Header.Set("Content-Type", ContentType)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// readFrames splits a response body into its message and trailers.
+func readFrames(t *testing.T, b []byte) (msg []byte, trailers string) {
+	for len(b) > 0 {
+		if len(b) < headerLen {
+			t.Fatalf("Truncated frame: %q", b)
+		}
+		flag := b[0]
+		n := int(b[1])<<24 | int(b[2])<<16 | int(b[3])<<8 | int(b[4])
+		payload := b[headerLen : headerLen+n]
+		if flag == trailerFlag {
+			trailers = string(payload)
+		} else {
+			msg = payload
+		}
+		b = b[headerLen+n:]
+	}
+	return msg, trailers
+}
+
+func TestService(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), ContentType)
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := execute(t, s, "/pkg.Service1/Multiply", &Service1Request{4, 2})
+	if w.Code != 200 {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	msg, trailers := readFrames(t, w.Body.Bytes())
+	var res Service1Response
+	if err := json.Unmarshal(msg, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+	if !strings.Contains(trailers, "grpc-status:0\r\n") {
+		t.Errorf("Expected grpc-status 0, got %q", trailers)
+	}
+
+	w = execute(t, s, "/Service1/ResponseError", &Service1Request{4, 2})
+	msg, trailers = readFrames(t, w.Body.Bytes())
+	if msg != nil {
+		t.Errorf("Expected no message, got %q", msg)
+	}
+	if !strings.Contains(trailers, "grpc-status:3\r\n") || !strings.Contains(trailers, "grpc-message:response error\r\n") {
+		t.Errorf("Expected InvalidArgument trailers, got %q", trailers)
+	}
+	if got := w.Header().Get("grpc-status"); got != "3" {
+		t.Errorf("Expected grpc-status header 3, got %q", got)
+	}
+
+	w = execute(t, s, "/Service1/Denied", &Service1Request{4, 2})
+	if _, trailers = readFrames(t, w.Body.Bytes()); !strings.Contains(trailers, "grpc-status:7\r\n") {
+		t.Errorf("Expected PermissionDenied trailers, got %q", trailers)
+	}
+
+	w = execute(t, s, "/Service1/Unknown", &Service1Request{4, 2})
+	if _, trailers = readFrames(t, w.Body.Bytes()); !strings.Contains(trailers, "grpc-status:3\r\n") {
+		t.Errorf("Expected InvalidArgument trailers, got %q", trailers)
+	}
+}
+
+func TestMethodFromPath(t *testing.T) {
+	tests := map[string]string{
+		"/helloworld.Greeter/SayHello": "Greeter.SayHello",
+		"/Greeter/SayHello":            "Greeter.SayHello",
+		"/api/Greeter/SayHello":        "Greeter.SayHello",
+	}
+	for path, expected := range tests {
+		if method, err := methodFromPath(path); err != nil || method != expected {
+			t.Errorf("methodFromPath(%q) = %q, %v; want %q", path, method, err, expected)
+		}
+	}
+	if _, err := methodFromPath("/SayHello"); err == nil {
+		t.Error("Expected error for a path without service")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+const (
+	// dataFlag marks a frame carrying a message.
+	dataFlag byte = 0x00
+	// trailerFlag marks a frame carrying the trailers.
+	trailerFlag byte = 0x80
+	// headerLen is the size of the flag byte plus the message length.
+	headerLen = 5
+)
+
+// ContentType is the Content-Type of the responses written by the codec.
+const ContentType = "application/grpc-web+json"
+
+// ----------------------------------------------------------------------------
+// Status codes
+// ----------------------------------------------------------------------------
+
+// Code is a gRPC status code.
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// Error is an error carrying an explicit gRPC status code. Service methods
+// can return it to control the "grpc-status" sent to the client.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// codeFromStatus maps an HTTP status used by the server to a gRPC code.
+func codeFromStatus(status int) Code {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusConflict:
+		return AlreadyExists
+	case http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusNotImplemented:
+		return Unimplemented
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return DeadlineExceeded
+	case http.StatusInternalServerError:
+		return Internal
+	}
+	return Unknown
+}
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new gRPC-Web Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+}
+
+// NewRequest returns a CodecRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return newCodecRequest(r)
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return ContentType
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// newCodecRequest returns a new CodecRequest.
+func newCodecRequest(r *http.Request) rpc.CodecRequest {
+	method, err := methodFromPath(r.URL.Path)
+	if err != nil {
+		return &CodecRequest{err: err}
+	}
+
+	// Copy request body for decoding and access of underlying methods
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &CodecRequest{method: method, err: err}
+	}
+	// Close original body
+	r.Body.Close()
+
+	msg, err := readFrame(b)
+
+	// Add close method to buffer and pass as request body
+	r.Body = io.NopCloser(bytes.NewBuffer(b))
+
+	return &CodecRequest{method: method, message: msg, err: err}
+}
+
+// methodFromPath maps a gRPC path such as "/package.Service/Method" to the
+// "Service.Method" notation used by the server.
+func methodFromPath(path string) (string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[len(parts)-1] == "" {
+		return "", fmt.Errorf("rpc: no method: %s", path)
+	}
+	service := parts[len(parts)-2]
+	if idx := strings.LastIndex(service, "."); idx != -1 {
+		service = service[idx+1:]
+	}
+	return service + "." + parts[len(parts)-1], nil
+}
+
+// readFrame returns the message of the first data frame in b.
+func readFrame(b []byte) ([]byte, error) {
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("rpc: gRPC-Web frame too short: %d bytes", len(b))
+	}
+	if b[0] != dataFlag {
+		return nil, fmt.Errorf("rpc: unsupported gRPC-Web frame flag: %#x", b[0])
+	}
+	n := binary.BigEndian.Uint32(b[1:headerLen])
+	if uint64(len(b)-headerLen) < uint64(n) {
+		return nil, fmt.Errorf("rpc: gRPC-Web frame truncated: want %d bytes, got %d", n, len(b)-headerLen)
+	}
+	return b[headerLen : headerLen+int(n)], nil
+}
+
+// writeFrame writes a frame with the given flag and payload to w.
+func writeFrame(w io.Writer, flag byte, payload []byte) error {
+	header := make([]byte, headerLen)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	method  string
+	message []byte
+	err     error
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err == nil {
+		return c.method, nil
+	}
+	return "", c.err
+}
+
+// ReadRequest fills the request object for the RPC method.
+//
+// An empty message leaves the args with their zero value.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil && len(c.message) > 0 {
+		c.err = json.Unmarshal(c.message, args)
+	}
+	return c.err
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	b, err := json.Marshal(reply)
+	if err != nil {
+		c.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, dataFlag, b); err != nil {
+		c.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	c.writeServerResponse(w, buf.Bytes(), OK, "")
+}
+
+// WriteError writes a trailers-only response carrying the gRPC status code
+// mapped from err and the HTTP status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	code := codeFromStatus(status)
+	if grpcErr, ok := err.(*Error); ok {
+		code = grpcErr.Code
+	} else if code == OK {
+		code = Unknown
+	}
+	c.writeServerResponse(w, nil, code, err.Error())
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, body []byte, code Code, message string) {
+	var trailers bytes.Buffer
+	fmt.Fprintf(&trailers, "grpc-status:%d\r\n", code)
+	fmt.Fprintf(&trailers, "grpc-message:%s\r\n", encodeMessage(message))
+
+	buf := bytes.NewBuffer(body)
+	if err := writeFrame(buf, trailerFlag, trailers.Bytes()); err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	if body == nil {
+		// Trailers-only responses also carry the status as headers.
+		h.Set("grpc-status", strconv.Itoa(int(code)))
+		h.Set("grpc-message", encodeMessage(message))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// encodeMessage percent-encodes the grpc-message value as required by the
+// gRPC protocol.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -6,6 +6,9 @@
 package json
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -13,6 +16,9 @@ import (
 	"log"
 	"math"
 	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
 )
 
 // ----------------------------------------------------------------------------
@@ -44,10 +50,15 @@ func EncodeClientRequest(method string, args interface{}) ([]byte, error) {
 		log.Fatal(err)
 	}
 
+	return encodeClientRequest(method, args, val.Uint64())
+}
+
+// encodeClientRequest encodes parameters for a request with the given id.
+func encodeClientRequest(method string, args interface{}, id uint64) ([]byte, error) {
 	c := &clientRequest{
 		Method: method,
 		Params: [1]interface{}{args},
-		Id:     val.Uint64(),
+		Id:     id,
 	}
 	return json.Marshal(c)
 }
@@ -67,3 +78,74 @@ func DecodeClientResponse(r io.Reader, reply interface{}) error {
 	}
 	return json.Unmarshal(*c.Result, reply)
 }
+
+// ----------------------------------------------------------------------------
+// Client
+// ----------------------------------------------------------------------------
+
+// NewClient returns a new Client calling the server at url. If httpClient
+// is nil, http.DefaultClient is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{url: url, httpClient: httpClient}
+}
+
+// Client calls the methods of a JSON-RPC server over HTTP, reusing the
+// underlying http.Client. It is safe for concurrent use.
+type Client struct {
+	url           string
+	httpClient    *http.Client
+	id            uint64
+	gzipThreshold int
+}
+
+// SetGzipThreshold enables gzip compression of the request bodies of at
+// least n bytes, sent with "Content-Encoding: gzip". Smaller bodies are
+// sent uncompressed. Zero, the default, disables compression. The server
+// must enable decompression with rpc.Server.SetRequestDecompression.
+func (c *Client) SetGzipThreshold(n int) {
+	c.gzipThreshold = n
+}
+
+// Call calls the method with args and decodes the result into reply.
+//
+// The method uses a dotted notation as in "Service.Method". Errors returned
+// by the server are decoded as *Error values.
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	b, err := encodeClientRequest(method, args, atomic.AddUint64(&c.id, 1))
+	if err != nil {
+		return err
+	}
+	compress := c.gzipThreshold > 0 && len(b) >= c.gzipThreshold
+	if compress {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(b); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("rpc: unexpected response %s: %s", res.Status, msg)
+	}
+	return DecodeClientResponse(res.Body, reply)
+}
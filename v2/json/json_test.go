@@ -7,6 +7,7 @@ package json
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -132,3 +133,100 @@ func TestClientNullResult(t *testing.T) {
 		t.Fatalf("Unexpected error: %s", err)
 	}
 }
+
+func TestClient(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	var res Service1Response
+	if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	err := c.Call(context.Background(), "Service1.ResponseError", &Service1Request{4, 2}, &res)
+	if jsonErr, ok := err.(*Error); !ok || jsonErr.Data != ErrResponseError.Error() {
+		t.Errorf("Expected to get %q, but got %v", ErrResponseError, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Call(ctx, "Service1.Multiply", &Service1Request{4, 2}, &res); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected to get %v, but got %v", context.Canceled, err)
+	}
+}
+
+func TestClientGzip(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetRequestDecompression(1 << 20)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	c.SetGzipThreshold(1)
+	var res Service1Response
+	if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+}
+
+func TestStrictCodec(t *testing.T) {
+	const body = `{"method":"Service1.Multiply","params":[{"A":4,"Bb":2}],"id":1}`
+	for _, strict := range []bool{false, true} {
+		s := rpc.NewServer()
+		if strict {
+			s.RegisterCodec(NewStrictCodec(), "application/json")
+		} else {
+			s.RegisterCodec(NewCodec(), "application/json")
+		}
+		if err := s.RegisterService(new(Service1), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		code, res := executeRaw(t, s, json.RawMessage(body))
+		e, _ := field("error", res.Bytes())
+		if strict {
+			if code != http.StatusBadRequest || e != `json: unknown field "Bb"` {
+				t.Errorf("Strict: expected 400 with an unknown field error, got %d %v", code, e)
+			}
+		} else if code != http.StatusOK || e != nil {
+			t.Errorf("Lenient: expected 200 without error, got %d %v", code, e)
+		}
+	}
+}
+
+type RawService struct {
+}
+
+func (t *RawService) Report(r *http.Request, req *string, res *rpc.RawResponse) error {
+	res.Body = []byte(*req)
+	return nil
+}
+
+func TestRawResponse(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(RawService), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	code, res := executeRaw(t, s, json.RawMessage(`{"method":"RawService.Report","params":["\u0000\u0001"],"id":1}`))
+	if code != http.StatusOK || res.String() != "\x00\x01" {
+		t.Errorf("Response was %d %q, should be 200 with the raw body", code, res)
+	}
+}
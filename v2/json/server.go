@@ -66,13 +66,29 @@ func NewCodec() *Codec {
 	return &Codec{}
 }
 
+// NewStrictCodec returns a new JSON Codec rejecting params with object keys
+// that don't match a field of the args, e.g. a misspelled field that would
+// otherwise be silently ignored. NewCodec ignores unknown fields.
+func NewStrictCodec() *Codec {
+	return &Codec{strict: true}
+}
+
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
+	strict bool
 }
 
 // NewRequest returns a CodecRequest.
 func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	return newCodecRequest(r)
+	req := newCodecRequest(r)
+	req.strict = c.strict
+	return req
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return "application/json; charset=utf-8"
 }
 
 // ----------------------------------------------------------------------------
@@ -80,7 +96,7 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 // ----------------------------------------------------------------------------
 
 // newCodecRequest returns a new CodecRequest.
-func newCodecRequest(r *http.Request) rpc.CodecRequest {
+func newCodecRequest(r *http.Request) *CodecRequest {
 	req := new(serverRequest)
 
 	// Copy request body for decoding and access of underlying methods
@@ -104,6 +120,7 @@ func newCodecRequest(r *http.Request) rpc.CodecRequest {
 type CodecRequest struct {
 	request *serverRequest
 	err     error
+	strict  bool
 }
 
 // Method returns the RPC method for the current request.
@@ -116,6 +133,24 @@ func (c *CodecRequest) Method() (string, error) {
 	return "", c.err
 }
 
+// TransformParams replaces the params of the request, the array of
+// arguments, by the ones returned by f. It implements rpc.ParamsTransformer.
+func (c *CodecRequest) TransformParams(f func(json.RawMessage) (json.RawMessage, error)) error {
+	if c.err != nil {
+		return c.err
+	}
+	var params json.RawMessage
+	if c.request.Params != nil {
+		params = *c.request.Params
+	}
+	params, err := f(params)
+	if err != nil {
+		return err
+	}
+	c.request.Params = &params
+	return nil
+}
+
 // ReadRequest fills the request object for the RPC method.
 func (c *CodecRequest) ReadRequest(args interface{}) error {
 	if c.err == nil {
@@ -123,7 +158,13 @@ func (c *CodecRequest) ReadRequest(args interface{}) error {
 			// JSON params is array value. RPC params is struct.
 			// Unmarshal into array containing the request struct.
 			params := [1]interface{}{args}
-			c.err = json.Unmarshal(*c.request.Params, &params)
+			if c.strict {
+				dec := json.NewDecoder(bytes.NewReader(*c.request.Params))
+				dec.DisallowUnknownFields()
+				c.err = dec.Decode(&params)
+			} else {
+				c.err = json.Unmarshal(*c.request.Params, &params)
+			}
 		} else {
 			c.err = errors.New("rpc: method request ill-formed: missing params field")
 		}
@@ -144,6 +185,20 @@ func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
 	}
 }
 
+// WriteRawResponse writes the body of res as is, with its content type.
+// Notifications don't have a response.
+func (c *CodecRequest) WriteRawResponse(w http.ResponseWriter, res *rpc.RawResponse) {
+	if c.request.Id == nil {
+		return
+	}
+	contentType := res.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(res.Body)
+}
+
 func (c *CodecRequest) WriteError(w http.ResponseWriter, _ int, err error) {
 	res := &serverResponse{
 		Result: &null,
@@ -22,6 +22,15 @@ const (
 
 var ErrNullResult = errors.New("result is null")
 
+// Error is a JSON-RPC 2.0 error object.
+//
+// Service methods can return an *Error to control the error sent to the
+// client: it is written as is, including its Data, e.g. field-level
+// validation details. An *rpc.MultiError is written with the E_BAD_PARAMS
+// code and the message of each of its errors in the Data. Other errors are
+// written with the E_INTERNAL code, or as mapped by the error mapper of the
+// codec. Clients decode the Data of
+// errors returned by the server as the generic values of encoding/json.
 type Error struct {
 	// A Number that indicates the error type that occurred.
 	Code ErrorCode `json:"code"` /* required */
@@ -32,8 +41,28 @@ type Error struct {
 
 	// A Primitive or Structured value that contains additional information about the error.
 	Data interface{} `json:"data"` /* optional */
+
+	// The error that caused this one, if any. It is not sent to clients.
+	cause error
+}
+
+// NewError returns an Error with the given code, message and data, the
+// optional value carrying additional information about the error.
+func NewError(code ErrorCode, message string, data interface{}) *Error {
+	return &Error{Code: code, Message: message, Data: data}
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
+
+// ErrorCode implements rpc.ErrorCoder.
+func (e *Error) ErrorCode() int {
+	return int(e.Code)
+}
+
+// Unwrap returns the error that caused e, e.g. the *json.SyntaxError or
+// *json.UnmarshalTypeError of an E_PARSE error, or nil.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
@@ -7,11 +7,22 @@ package json2
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/rpc/v2"
 )
@@ -115,6 +126,42 @@ func (t *Service1) MappedResponseError(r *http.Request, req *Service1Request, re
 	return ErrMappedResponseError
 }
 
+func (t *Service1) Empty(r *http.Request, req *Service1Request, res *EmptyResponse) error {
+	return nil
+}
+
+func (t *Service1) Notify(r *http.Request, req *Service1Request) error {
+	return nil
+}
+
+type RequiredParams struct {
+	A        int
+	presence ParamsPresence
+}
+
+func (p *RequiredParams) SetParamsPresence(presence ParamsPresence) {
+	p.presence = presence
+}
+
+type Service2 struct {
+}
+
+func (t *Service2) Required(r *http.Request, req *RequiredParams, res *ParamsPresence) error {
+	*res = req.presence
+	if req.presence != ParamsPresent {
+		return &Error{Code: E_BAD_PARAMS, Message: "params are required"}
+	}
+	return nil
+}
+
+type Proxy struct {
+}
+
+func (p *Proxy) Forward(r *http.Request, req *json.RawMessage, res *json.RawMessage) error {
+	*res = *req
+	return nil
+}
+
 func execute(t *testing.T, s *rpc.Server, method string, req, res interface{}) error {
 	if !s.HasMethod(method) {
 		t.Fatal("Expected to be registered:", method)
@@ -289,3 +336,1526 @@ func TestDecodeNullResult(t *testing.T) {
 		t.Error("Expected result to be nil, but got:", result)
 	}
 }
+
+func TestServiceWithoutReply(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("Service1.Notify") {
+		t.Fatal("Expected to be registered: Service1.Notify")
+	}
+
+	buf, _ := EncodeClientRequest("Service1.Notify", &Service1Request{4, 2})
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBuffer(buf))
+	r.Header.Set("Content-Type", "application/json")
+	w := NewRecorder()
+	s.ServeHTTP(w, r)
+
+	var res map[string]*json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := res["result"]; !ok || result != nil {
+		t.Errorf("Expected a null result, got %s", w.Body)
+	}
+	if _, ok := res["error"]; ok {
+		t.Errorf("Expected no error, got %s", w.Body)
+	}
+}
+
+func executeRawBody(t *testing.T, s *rpc.Server, body string) *ResponseRecorder {
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	w := NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdMode(t *testing.T) {
+	tests := []struct {
+		mode     IdMode
+		id       string
+		expected string
+		code     ErrorCode
+	}{
+		{IdRaw, "18446744073709551615", "18446744073709551615", 0},
+		{IdRaw, "9007199254740993", "9007199254740993", 0},
+		{IdRaw, `"abc"`, `"abc"`, 0},
+		{IdUint64, "18446744073709551615", "18446744073709551615", 0},
+		{IdUint64, `"abc"`, `"abc"`, E_INVALID_REQ},
+		{IdUint64, "-1", "-1", E_INVALID_REQ},
+		{IdString, "18446744073709551615", `"18446744073709551615"`, 0},
+		{IdString, `"abc"`, `"abc"`, 0},
+	}
+	for _, test := range tests {
+		s := rpc.NewServer()
+		s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithIdMode(test.mode)), "application/json")
+		if err := s.RegisterService(new(Service1), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		w := executeRawBody(t, s, `{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 2, "B": 3}, "id":  `+test.id+` }`)
+		var res struct {
+			Id    json.RawMessage `json:"id"`
+			Error *Error          `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Id) != test.expected {
+			t.Errorf("Mode %d: id was %s, should be %s", test.mode, res.Id, test.expected)
+		}
+		if test.code == 0 && res.Error != nil {
+			t.Errorf("Mode %d: unexpected error for id %s: %v", test.mode, test.id, res.Error)
+		} else if test.code != 0 && (res.Error == nil || res.Error.Code != test.code) {
+			t.Errorf("Mode %d: expected error %d for id %s, got %v", test.mode, test.code, test.id, res.Error)
+		}
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var methods []string
+	var params []string
+
+	c := NewCodec()
+	c.SetRequestLogger(func(method string, p json.RawMessage) {
+		methods = append(methods, method)
+		params = append(params, string(p))
+	})
+	c.SetRedactor(func(p json.RawMessage) json.RawMessage {
+		var m map[string]interface{}
+		if err := json.Unmarshal(p, &m); err != nil {
+			return p
+		}
+		if _, ok := m["B"]; ok {
+			m["B"] = "***"
+		}
+		b, _ := json.Marshal(m)
+		return b
+	})
+
+	s := rpc.NewServer()
+	s.RegisterCodec(c, "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var res Service1Response
+	if err := execute(t, s, "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := executeInvalidJSON(t, s, &res); err == nil {
+		t.Fatal("Expected to receive an E_PARSE error, but got nil")
+	}
+
+	if len(methods) != 1 || methods[0] != "Service1.Multiply" {
+		t.Errorf("Logged methods were %v, should be [Service1.Multiply]", methods)
+	}
+	if len(params) != 1 || params[0] != `{"A":4,"B":"***"}` {
+		t.Errorf("Logged params were %v, should be redacted", params)
+	}
+}
+
+func TestRawParams(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Proxy), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []string{`{"A": 1, "B": [2, 3]}`, `[1, "two"]`} {
+		w := executeRawBody(t, s, `{"jsonrpc": "2.0", "method": "Proxy.Forward", "params": `+params+`, "id": 1}`)
+		var res struct {
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		var expected bytes.Buffer
+		json.Compact(&expected, []byte(params))
+		if string(res.Result) != expected.String() {
+			t.Errorf("Result was %s, should be %s", res.Result, expected.String())
+		}
+	}
+}
+
+func TestParamsPresence(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service2), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		params string
+		code   ErrorCode
+	}{
+		{``, E_BAD_PARAMS},
+		{`, "params": null`, E_BAD_PARAMS},
+		{`, "params": {"A": 1}`, 0},
+	}
+	for _, test := range tests {
+		w := executeRawBody(t, s, `{"jsonrpc": "2.0", "method": "Service2.Required", "id": 1`+test.params+`}`)
+		var res ParamsPresence
+		err := DecodeClientResponse(w.Body, &res)
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("Params %q: unexpected error %v", test.params, err)
+			} else if res != ParamsPresent {
+				t.Errorf("Params %q: presence was %d, should be %d", test.params, res, ParamsPresent)
+			}
+		} else if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != test.code {
+			t.Errorf("Params %q: expected error %d, got %v", test.params, test.code, err)
+		}
+	}
+
+	for body, expected := range map[string]ParamsPresence{
+		`{"jsonrpc": "2.0", "method": "Service2.Required", "id": 1}`:                     ParamsAbsent,
+		`{"jsonrpc": "2.0", "method": "Service2.Required", "params": null, "id": 1}`:     ParamsNull,
+		`{"jsonrpc": "2.0", "method": "Service2.Required", "params": {"A": 1}, "id": 1}`: ParamsPresent,
+	} {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+		c := NewCodec().NewRequest(r).(*CodecRequest)
+		if presence := c.ParamsPresence(); presence != expected {
+			t.Errorf("Body %s: presence was %d, should be %d", body, presence, expected)
+		}
+	}
+}
+
+type Service3 struct {
+}
+
+func (t *Service3) Echo(r *http.Request, req *string, res *string) error {
+	*res = *req
+	return nil
+}
+
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (t *Service3) Validate(r *http.Request, req *string, res *string) error {
+	return NewError(E_BAD_PARAMS, "invalid params", []FieldError{{"name", "required"}})
+}
+
+func TestErrorData(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var res string
+	err := execute(t, s, "Service3.Validate", "", &res)
+	jsonErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected an *Error, got %#v", err)
+	}
+	if jsonErr.Code != E_BAD_PARAMS || jsonErr.Message != "invalid params" {
+		t.Errorf("Expected code %d and message %q, got %d and %q", E_BAD_PARAMS, "invalid params", jsonErr.Code, jsonErr.Message)
+	}
+	expected := []interface{}{map[string]interface{}{"field": "name", "reason": "required"}}
+	if !reflect.DeepEqual(jsonErr.Data, expected) {
+		t.Errorf("Expected data %v, got %v", expected, jsonErr.Data)
+	}
+}
+
+type quotaError struct {
+	Limit, Used int
+}
+
+func (e *quotaError) Error() string {
+	return "quota exceeded"
+}
+
+func (e *quotaError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]int{"limit": e.Limit, "used": e.Used})
+}
+
+func (t *Service3) Quota(r *http.Request, req *string, res *string) error {
+	if *req == "plain" {
+		return errors.New("quota exceeded")
+	}
+	return fmt.Errorf("checking %s: %w", *req, &quotaError{Limit: 10, Used: 12})
+}
+
+func TestMarshalerError(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		req, message string
+		data         interface{}
+	}{
+		{"bob", "checking bob: quota exceeded", map[string]interface{}{"limit": float64(10), "used": float64(12)}},
+		{"plain", "quota exceeded", nil},
+	}
+	for _, tt := range tests {
+		var res string
+		err := execute(t, s, "Service3.Quota", tt.req, &res)
+		jsonErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("Expected an *Error, got %#v", err)
+		}
+		if jsonErr.Code != E_INTERNAL || jsonErr.Message != tt.message {
+			t.Errorf("Expected code %d and message %q, got %d and %q", E_INTERNAL, tt.message, jsonErr.Code, jsonErr.Message)
+		}
+		if !reflect.DeepEqual(jsonErr.Data, tt.data) {
+			t.Errorf("Expected data %v, got %v", tt.data, jsonErr.Data)
+		}
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		var errs rpc.MultiError
+		errs.Add(errors.New("name: required"))
+		errs.Add(nil)
+		errs.Add(errors.New("age: must be positive"))
+		return errs.Err()
+	})
+
+	var res string
+	err := execute(t, s, "Service3.Echo", "x", &res)
+	jsonErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected an *Error, got %#v", err)
+	}
+	if jsonErr.Code != E_BAD_PARAMS || jsonErr.Message != "name: required; age: must be positive" {
+		t.Errorf("Expected code %d and all messages, got %d and %q", E_BAD_PARAMS, jsonErr.Code, jsonErr.Message)
+	}
+	expected := []interface{}{"name: required", "age: must be positive"}
+	if !reflect.DeepEqual(jsonErr.Data, expected) {
+		t.Errorf("Expected data %v, got %v", expected, jsonErr.Data)
+	}
+}
+
+func TestEncodingOptions(t *testing.T) {
+	body := `{"jsonrpc": "2.0", "method": "Service3.Echo", "params": ["<a&b>"], "id": 1}`
+	tests := []struct {
+		opts     []Option
+		expected string
+	}{
+		{nil, `{"jsonrpc":"2.0","result":"\u003ca\u0026b\u003e","id":1}` + "\n"},
+		{[]Option{WithEscapeHTML(false)}, `{"jsonrpc":"2.0","result":"<a&b>","id":1}` + "\n"},
+		{[]Option{WithEscapeHTML(false), WithIndent("", "  ")}, "{\n  \"jsonrpc\": \"2.0\",\n  \"result\": \"<a&b>\",\n  \"id\": 1\n}\n"},
+	}
+	for _, test := range tests {
+		s := rpc.NewServer()
+		s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, test.opts...), "application/json")
+		if err := s.RegisterService(new(Service3), ""); err != nil {
+			t.Fatal(err)
+		}
+		if w := executeRawBody(t, s, body); w.Body.String() != test.expected {
+			t.Errorf("Response body was %q, should be %q", w.Body, test.expected)
+		}
+	}
+}
+
+func TestMethodInResponse(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		body     string
+		expected string
+	}{
+		{nil, `{"jsonrpc":"2.0","method":"Service3.Echo","params":["a"],"id":1}`, `{"jsonrpc":"2.0","result":"a","id":1}` + "\n"},
+		{[]Option{WithMethodInResponse(true)}, `{"jsonrpc":"2.0","method":"Service3.Echo","params":["a"],"id":1}`, `{"jsonrpc":"2.0","method":"Service3.Echo","result":"a","id":1}` + "\n"},
+		{[]Option{WithMethodInResponse(true)}, `{"jsonrpc":"2.0","method":"Old.Echo","params":["a"],"id":1}`, `{"jsonrpc":"2.0","method":"Service3.Echo","result":"a","id":1}` + "\n"},
+	}
+	for _, test := range tests {
+		s := rpc.NewServer()
+		s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, test.opts...), "application/json")
+		if err := s.RegisterService(new(Service3), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterAliasWithTransform("Old.Echo", "Service3.Echo", nil); err != nil {
+			t.Fatal(err)
+		}
+		if w := executeRawBody(t, s, test.body); w.Body.String() != test.expected {
+			t.Errorf("Response body was %q, should be %q", w.Body, test.expected)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	var res Service1Response
+	if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	err := c.Call(context.Background(), "Service1.ResponseError", &Service1Request{4, 2}, &res)
+	if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != E_INTERNAL || jsonErr.Message != ErrResponseError.Error() {
+		t.Errorf("Expected to get %q, but got %v", ErrResponseError, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Call(ctx, "Service1.Multiply", &Service1Request{4, 2}, &res); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected to get %v, but got %v", context.Canceled, err)
+	}
+}
+
+func TestClientContentType(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithResponseContentType("application/vnd.myapi+json; charset=utf-8")), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var res Service1Response
+	if err := NewClient(ts.URL, nil).Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+}
+
+func TestClientGzip(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetRequestDecompression(1 << 20)
+	var encodings []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	c.SetGzipThreshold(256)
+	for _, arg := range []string{"small", strings.Repeat("large", 100)} {
+		var res string
+		if err := c.Call(context.Background(), "Service3.Echo", &arg, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res != arg {
+			t.Errorf("Response was %q, should be %q", res, arg)
+		}
+	}
+	if len(encodings) != 2 || encodings[0] != "" || encodings[1] != "gzip" {
+		t.Errorf("Request encodings were %q, should be [\"\" \"gzip\"]", encodings)
+	}
+}
+
+func TestEmptyBody(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"", " \n"} {
+		w := executeRawBody(t, s, body)
+		var res Service1Response
+		err := DecodeClientResponse(w.Body, &res)
+		if jsonRpcErr, ok := err.(*Error); !ok {
+			t.Errorf("Expected to receive an Error, but got %T: %v", err, err)
+		} else if jsonRpcErr.Code != E_INVALID_REQ || jsonRpcErr.Message != "empty request body" {
+			t.Errorf("Expected an E_INVALID_REQ empty request body error but got %d %q", jsonRpcErr.Code, jsonRpcErr.Message)
+		}
+	}
+}
+
+func TestMethodHeader(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithMethodHeader("X-RPC-Method")), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		header   string
+		body     string
+		expected string
+	}{
+		{"Service1.Multiply", `{"A":4,"B":2}`, `{"jsonrpc":"2.0","result":{"Result":8},"id":null}`},
+		{"Service1.Multiply", `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":3},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"Result":9999},"id":null}`},
+		{"", `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":3},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"Result":12},"id":1}`},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		if tt.header != "" {
+			r.Header.Set("X-RPC-Method", tt.header)
+		}
+		w := NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := strings.TrimSpace(w.Body.String()); got != tt.expected {
+			t.Errorf("Response was %s, should be %s", got, tt.expected)
+		}
+	}
+}
+
+func TestParseErrorCause(t *testing.T) {
+	tests := []struct {
+		body   string
+		syntax bool
+		offset int64
+	}{
+		{`{"jsonrpc": "2.0", "method": }`, true, 30},
+		{`{"jsonrpc": "2.0", "method": 5}`, false, 30},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(tt.body))
+		_, err := NewCodec().NewRequest(r).Method()
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if tt.syntax && !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: Expected a *json.SyntaxError cause, got %v", tt.body, err)
+		}
+		if !tt.syntax && !errors.As(err, &typeErr) {
+			t.Errorf("%s: Expected a *json.UnmarshalTypeError cause, got %v", tt.body, err)
+		}
+
+		s := rpc.NewServer()
+		s.RegisterCodec(NewCodec(), "application/json")
+		w := executeRawBody(t, s, tt.body)
+		var res struct {
+			Error struct {
+				Code ErrorCode
+				Data struct {
+					Offset int64
+				}
+			}
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error.Code != E_PARSE || res.Error.Data.Offset != tt.offset {
+			t.Errorf("%s: Expected E_PARSE at offset %d, got %d at %d", tt.body, tt.offset, res.Error.Code, res.Error.Data.Offset)
+		}
+	}
+}
+
+func TestResultPresent(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method   string
+		expected string
+	}{
+		{"Service1.Empty", "{}"},
+		{"Service1.Notify", "null"},
+	}
+	for _, tt := range tests {
+		w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"`+tt.method+`","params":{"A":1,"B":2},"id":1}`)
+		var res map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if result, ok := res["result"]; !ok {
+			t.Errorf("%s: Expected a result member in %s", tt.method, w.Body)
+		} else if string(result) != tt.expected {
+			t.Errorf("%s: Result was %s, should be %s", tt.method, result, tt.expected)
+		}
+	}
+}
+
+func TestStrictCodec(t *testing.T) {
+	tests := []struct {
+		params  string
+		code    ErrorCode
+		unknown bool
+	}{
+		{`{"A":4,"B":2}`, 0, false},
+		{`[{"A":4,"B":2}]`, 0, false},
+		{`{"A":4,"Bb":2}`, E_BAD_PARAMS, true},
+		{`[{"A":4,"Bb":2}]`, E_BAD_PARAMS, true},
+		{`"x"`, E_BAD_PARAMS, false},
+	}
+	s := rpc.NewServer()
+	s.RegisterCodec(NewStrictCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	lenient := rpc.NewServer()
+	lenient.RegisterCodec(NewCodec(), "application/json")
+	if err := lenient.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		body := `{"jsonrpc":"2.0","method":"Service1.Multiply","params":` + tt.params + `,"id":1}`
+		var res Service1Response
+		err := DecodeClientResponse(executeRawBody(t, s, body).Body, &res)
+		var code ErrorCode
+		if jsonErr, ok := err.(*Error); ok {
+			code = jsonErr.Code
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("%s: Error code was %d, should be %d", tt.params, code, tt.code)
+		}
+		if tt.unknown {
+			if err := DecodeClientResponse(executeRawBody(t, lenient, body).Body, &res); err != nil {
+				t.Errorf("%s: Expected the default codec to ignore unknown fields, got %v", tt.params, err)
+			}
+		}
+	}
+}
+
+func (t *Service3) Report(r *http.Request, req *string, res *rpc.RawResponse) error {
+	res.ContentType = "text/csv"
+	res.Body = []byte(*req)
+	return nil
+}
+
+func TestRawResponse(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service3.Report","params":"a,b\n1,2\n","id":1}`)
+	if got := w.Body.String(); got != "a,b\n1,2\n" {
+		t.Errorf("Response body was %q, should be verbatim", got)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type was %q, should be text/csv", ct)
+	}
+}
+
+func TestNotificationID(t *testing.T) {
+	n := 0
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithNotificationID(func() string {
+		n++
+		return "notification-" + strconv.Itoa(n)
+	})), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		ids = append(ids, i.CorrelationID)
+	})
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service1.Notify","params":{"A":1,"B":2}}`)
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected no response for a notification, got %s", w.Body)
+	}
+	executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service1.Notify","params":{"A":1,"B":2},"id":"abc"}`)
+
+	expected := []string{"notification-1", `"abc"`}
+	if len(ids) != len(expected) || ids[0] != expected[0] || ids[1] != expected[1] {
+		t.Errorf("Correlation ids were %q, should be %q", ids, expected)
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	const contentType = "application/vnd.myapi+json"
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithResponseContentType(contentType)), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"Service1.Multiply", "Service1.ResponseError"} {
+		w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"`+method+`","params":{"A":1,"B":2},"id":1}`)
+		if ct := w.Header().Get("Content-Type"); ct != contentType {
+			t.Errorf("%s: Content-Type was %q, should be %q", method, ct, contentType)
+		}
+	}
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", nil)
+	r.Header.Set("Content-Type", "application/json")
+	if ct := s.ResponseContentType(r); ct != contentType {
+		t.Errorf("Server ResponseContentType was %q, should be %q", ct, contentType)
+	}
+}
+
+// brokenWriter fails every write, as when the client is gone.
+type brokenWriter struct {
+	*ResponseRecorder
+	writes int
+}
+
+func (w *brokenWriter) Write(buf []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("broken pipe")
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestWriteResponseError(t *testing.T) {
+	codec := NewCodec()
+	logger := &testLogger{}
+	codec.SetLogger(logger)
+	s := rpc.NewServer()
+	s.RegisterCodec(codec, "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, _ := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBuffer(buf))
+	r.Header.Set("Content-Type", "application/json")
+	w := &brokenWriter{ResponseRecorder: NewRecorder()}
+	s.ServeHTTP(w, r)
+
+	if w.writes != 1 {
+		t.Errorf("Expected a single write, got %d", w.writes)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "broken pipe") {
+		t.Errorf("Expected the write error to be logged, got %q", logger.lines)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	// Echo replies larger than the limit can't be written.
+	if err := s.RegisterCacheable("Service3.Echo"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetMaxResponseBytes(64)
+	s.SetLogger(&testLogger{})
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if req, ok := args.(*Service1Request); ok && req.B < 0 {
+			return errors.New("B must not be negative")
+		}
+		return nil
+	})
+	var status int
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		status = i.StatusCode
+	})
+
+	// Requests that can't be read are answered without calling the after
+	// function, so only the status written is checked.
+	tests := []struct {
+		name   string
+		body   string
+		code   ErrorCode
+		status int
+		after  bool
+	}{
+		{"parse", `{"jsonrpc":"2.0",`, E_PARSE, http.StatusBadRequest, false},
+		{"invalid request", `{"jsonrpc":"1.0","method":"Service1.Multiply","id":1}`, E_INVALID_REQ, http.StatusBadRequest, false},
+		{"bad params", `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":"x"},"id":1}`, E_BAD_PARAMS, http.StatusBadRequest, false},
+		{"invalid params", `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":1,"B":-1},"id":1}`, E_BAD_PARAMS, http.StatusBadRequest, true},
+		{"no method", `{"jsonrpc":"2.0","method":"Service1.Missing","params":{},"id":1}`, E_NO_METHOD, http.StatusBadRequest, true},
+		{"no service", `{"jsonrpc":"2.0","method":"Missing.Multiply","params":{},"id":1}`, E_NO_METHOD, http.StatusBadRequest, true},
+		{"method error", `{"jsonrpc":"2.0","method":"Service1.ResponseError","params":{},"id":1}`, E_INTERNAL, http.StatusInternalServerError, true},
+		{"internal", `{"jsonrpc":"2.0","method":"Service3.Echo","params":["` + strings.Repeat("x", 100) + `"],"id":1}`, E_INTERNAL, http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		status = 0
+		w := executeRawBody(t, s, tt.body)
+		var res interface{}
+		err := DecodeClientResponse(w.Body, &res)
+		if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != tt.code {
+			t.Errorf("%s: Expected an error with code %d, got %v", tt.name, tt.code, err)
+		}
+		if w.Code != tt.status {
+			t.Errorf("%s: Status written was %d, should be %d", tt.name, w.Code, tt.status)
+		}
+		if tt.after && status != tt.status {
+			t.Errorf("%s: Status was %d, should be %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestAliasWithTransform(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", func(params json.RawMessage) (json.RawMessage, error) {
+		var v1 struct{ X, Y int }
+		if err := json.Unmarshal(params, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(&Service1Request{A: v1.X, B: v1.Y})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res Service1Response
+	if err := DecodeClientResponse(executeRawBody(t, s, `{"jsonrpc":"2.0","method":"V1.Multiply","params":{"X":6,"Y":7},"id":1}`).Body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != 42 {
+		t.Errorf("Expected res.Result to be 42, but got %d", res.Result)
+	}
+	err = DecodeClientResponse(executeRawBody(t, s, `{"jsonrpc":"2.0","method":"V1.Multiply","params":"x","id":1}`).Body, &res)
+	if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != E_BAD_PARAMS {
+		t.Errorf("Expected an E_BAD_PARAMS error, got %v", err)
+	}
+}
+
+// NumberStream streams the numbers from 0 to N-1, failing at Fail if set.
+type NumberStream struct {
+	N    int
+	Fail int
+	i    int
+}
+
+func (s *NumberStream) Next() (interface{}, error) {
+	if s.Fail > 0 && s.i == s.Fail {
+		return nil, errors.New("stream failed")
+	}
+	if s.i == s.N {
+		return nil, io.EOF
+	}
+	s.i++
+	return s.i - 1, nil
+}
+
+type Service4 struct {
+}
+
+func (t *Service4) Numbers(r *http.Request, req *NumberStream, res *NumberStream) error {
+	*res = *req
+	return nil
+}
+
+func TestStreamResponse(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithStreamFlushInterval(10)), "application/json")
+	s.SetErrorTrailer("X-RPC-Error")
+	s.SetLogger(&testLogger{})
+	if err := s.RegisterService(new(Service4), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service4.Numbers","params":{"N":250},"id":7}`)
+	if !w.Flushed {
+		t.Error("Expected the response to be flushed")
+	}
+	var res []int
+	if err := DecodeClientResponse(w.Body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 250 || res[0] != 0 || res[249] != 249 {
+		t.Errorf("Expected the numbers up to 249, got %d numbers", len(res))
+	}
+
+	w = executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service4.Numbers","params":{"N":0},"id":7}`)
+	if body := w.Body.String(); body != `{"jsonrpc":"2.0","result":[],"id":7}`+"\n" {
+		t.Errorf("Unexpected empty stream response %s", body)
+	}
+
+	w = executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service4.Numbers","params":{"N":50,"Fail":25},"id":7}`)
+	if err := DecodeClientResponse(w.Body, &res); err == nil {
+		t.Error("Expected a failed stream to be an incomplete response")
+	}
+	if trailer := w.Header().Get("X-RPC-Error"); trailer != "stream failed" {
+		t.Errorf("Expected the error in the trailer, got %q", trailer)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodec(&rpc.CompressionSelector{}), "application/json")
+	s.SetErrorTrailer("X-RPC-Error")
+	s.SetLogger(&testLogger{})
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(Service4), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetMaxResponseBytes(40)
+	var status int
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		status = i.StatusCode
+	})
+
+	// A reply too large is replaced by an error, even when compressed.
+	for _, encoding := range []string{"", "gzip"} {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"jsonrpc":"2.0","method":"Service3.Echo","params":["`+strings.Repeat("x", 100)+`"],"id":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if status != http.StatusInternalServerError {
+			t.Errorf("%q: Status was %d, should be 500", encoding, status)
+		}
+		body := io.Reader(w.Body)
+		if w.Header().Get("Content-Encoding") == "gzip" {
+			if body, err = gzip.NewReader(w.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var res string
+		err = DecodeClientResponse(body, &res)
+		if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != E_INTERNAL {
+			t.Errorf("%q: Expected an E_INTERNAL error, got %v", encoding, err)
+		}
+	}
+
+	// A streamed response is cut instead.
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service4.Numbers","params":{"N":250},"id":7}`)
+	if w.Body.Len() != 40 {
+		t.Errorf("Response body was %d bytes, should be cut at 40", w.Body.Len())
+	}
+	if trailer := w.Header().Get("X-RPC-Error"); trailer != "rpc: response too large" {
+		t.Errorf("Expected the error in the trailer, got %q", trailer)
+	}
+}
+
+type Report struct {
+	Title string `json:"title"`
+	PDF   []byte `json:"-"`
+}
+
+func (r *Report) Attachments() []rpc.Attachment {
+	return []rpc.Attachment{{Name: "report.pdf", ContentType: "application/pdf", Data: r.PDF}}
+}
+
+type Service5 struct {
+}
+
+func (t *Service5) Generate(r *http.Request, req *string, res *Report) error {
+	res.Title = *req
+	res.PDF = []byte("%PDF-1.4 " + *req)
+	return nil
+}
+
+func TestAttachments(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service5), ""); err != nil {
+		t.Fatal(err)
+	}
+	const body = `{"jsonrpc":"2.0","method":"Service5.Generate","params":["Q3"],"id":1}`
+
+	// Without multipart, the attachments are embedded base64 encoded.
+	w := executeRawBody(t, s, body)
+	expected := `{"jsonrpc":"2.0","result":{"title":"Q3"},"attachments":[{"name":"report.pdf","contentType":"application/pdf","data":"JVBERi0xLjQgUTM="}],"id":1}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("Response body was %q, should be %q", w.Body, expected)
+	}
+
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "application/json, multipart/mixed")
+	w = NewRecorder()
+	s.ServeHTTP(w, r)
+	mediaType, params, err := mime.ParseMediaType(w.HeaderMap.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type was %q, should be multipart/mixed", w.HeaderMap.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Report
+	if err := DecodeClientResponse(part, &res); err != nil || res.Title != "Q3" {
+		t.Errorf("Expected the JSON response first, got %+v, %v", res, err)
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(part)
+	if part.Header.Get("Content-Type") != "application/pdf" || part.FileName() != "report.pdf" || string(data) != "%PDF-1.4 Q3" {
+		t.Errorf("Attachment was %v %q, should be report.pdf", part.Header, data)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("Expected 2 parts, got %v", err)
+	}
+
+	// The method is included in the JSON part if enabled.
+	s = rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithMethodInResponse(true)), "application/json")
+	if err := s.RegisterService(new(Service5), ""); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept", "multipart/mixed")
+	w = NewRecorder()
+	s.ServeHTTP(w, r)
+	_, params, _ = mime.ParseMediaType(w.HeaderMap.Get("Content-Type"))
+	if part, err = multipart.NewReader(w.Body, params["boundary"]).NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	var envelope struct{ Method string }
+	if err := json.NewDecoder(part).Decode(&envelope); err != nil || envelope.Method != "Service5.Generate" {
+		t.Errorf("Method was %q, should be Service5.Generate (%v)", envelope.Method, err)
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithQueryParams()), "application/json")
+	s.EnableGet()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterCacheable("Service1.Multiply"); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method string, query url.Values, body string) *ResponseRecorder {
+		r, _ := http.NewRequest(method, "http://localhost:8080/?"+query.Encode(), strings.NewReader(body))
+		w := NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	query := url.Values{"method": {"Service1.Multiply"}, "params": {`{"A":2,"B":3}`}}
+	w := call("GET", query, "")
+	if w.Code != 200 || w.Body.String() != `{"jsonrpc":"2.0","result":{"Result":6},"id":null}`+"\n" {
+		t.Errorf("Response was %d %q, should be the product", w.Code, w.Body)
+	}
+	// Array params.
+	w = call("GET", url.Values{"method": {"Service1.Multiply"}, "params": {`[{"A":4,"B":5}]`}}, "")
+	if w.Body.String() != `{"jsonrpc":"2.0","result":{"Result":20},"id":null}`+"\n" {
+		t.Errorf("Response body was %q, should be the product", w.Body)
+	}
+	// A body takes precedence.
+	w = call("POST", query, `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":5},"id":1}`)
+	if w.Body.String() != `{"jsonrpc":"2.0","result":{"Result":20},"id":1}`+"\n" {
+		t.Errorf("Response body was %q, should be read from the body", w.Body)
+	}
+
+	var res Service1Response
+	err := DecodeClientResponse(call("GET", url.Values{"method": {"Service1.Multiply"}, "params": {`{"A":`}}, "").Body, &res)
+	if jsonErr, ok := err.(*Error); !ok || jsonErr.Code != E_PARSE {
+		t.Errorf("Expected an E_PARSE error for invalid params, got %v", err)
+	}
+	err = DecodeClientResponse(call("GET", url.Values{"method": {"Service1.Empty"}}, "").Body, &res)
+	if err == nil || !strings.Contains(err.Error(), "POST method required") {
+		t.Errorf("Expected an error for a method not registered as cacheable, got %v", err)
+	}
+}
+
+func TestCompressedResponseHeaders(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodec(&rpc.CompressionSelector{}), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(Service5), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(body, accept string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Accept", accept)
+		// A real recorder, freezing the headers at the first write.
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		body        string
+		accept      string
+		contentType string
+	}{
+		{`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":5},"id":1}`, "", "application/json; charset=utf-8"},
+		{`{"jsonrpc":"2.0","method":"Service1.Missing","id":1}`, "", "application/json; charset=utf-8"},
+		{`{"jsonrpc":"2.0","method":"Service5.Generate","params":["Q3"],"id":1}`, "multipart/mixed", "multipart/mixed; boundary="},
+	}
+	for _, test := range tests {
+		w := call(test.body, test.accept)
+		res := w.Result()
+		if !strings.HasPrefix(res.Header.Get("Content-Type"), test.contentType) || res.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("%s: headers were %v, should include the Content-Type and Content-Encoding", test.body, res.Header)
+			continue
+		}
+		gr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := io.ReadAll(gr); err != nil || !bytes.Contains(b, []byte(`"id":1`)) {
+			t.Errorf("%s: decompressed body was %q, %v", test.body, b, err)
+		}
+	}
+}
+
+func TestEmptyMethod(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"","params":{},"id":1}`)
+	var res struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error == nil || res.Error.Code != E_INVALID_REQ {
+		t.Errorf("Expected an E_INVALID_REQ error, but got %+v", res.Error)
+	}
+}
+
+func TestInvalidRequestId(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body string
+		id   string
+		code ErrorCode
+	}{
+		{`{"jsonrpc":"1.0","method":"Service1.Multiply","params":{"A":2,"B":3},"id":5}`, "5", E_INVALID_REQ},
+		{`{"method":"Service1.Multiply","params":{"A":2,"B":3},"id":"a"}`, `"a"`, E_INVALID_REQ},
+		// Without an id, the request isn't taken for a notification.
+		{`{"jsonrpc":"1.0","method":"Service1.Multiply","params":{"A":2,"B":3}}`, "null", E_INVALID_REQ},
+		// The id decoded before the error isn't trusted.
+		{`{"jsonrpc":"2.0","id":7,"method":"Service1.Multiply","params":{"A":`, "null", E_PARSE},
+		{`{"jsonrpc":"2.0","id":7,"method":5}`, "null", E_PARSE},
+	}
+	for _, test := range tests {
+		w := executeRawBody(t, s, test.body)
+		var res map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Invalid response %q for %s: %v", w.Body, test.body, err)
+		}
+		if len(res) != 3 || string(res["jsonrpc"]) != `"2.0"` || res["error"] == nil {
+			t.Errorf("Response for %s was %s, should have jsonrpc, error and id only", test.body, w.Body)
+		}
+		if string(res["id"]) != test.id {
+			t.Errorf("Id for %s was %s, should be %s", test.body, res["id"], test.id)
+		}
+		var jsonErr Error
+		if err := json.Unmarshal(res["error"], &jsonErr); err != nil || jsonErr.Code != test.code {
+			t.Errorf("Error for %s was %s, should have code %d", test.body, res["error"], test.code)
+		}
+	}
+}
+
+type Passthrough struct{}
+
+func (p *Passthrough) Echo(r *http.Request, args *map[string]interface{}, reply *string) error {
+	*reply = fmt.Sprintf("%T %v", (*args)["n"], (*args)["n"])
+	return nil
+}
+
+func TestUseNumber(t *testing.T) {
+	const body = `{"jsonrpc":"2.0","method":"Passthrough.Echo","params":{"n":9007199254740993},"id":1}`
+	tests := []struct {
+		codec    *Codec
+		expected string
+	}{
+		{NewCodec(), "float64 9.007199254740992e+15"},
+		{NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithUseNumber()), "json.Number 9007199254740993"},
+		{NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithUseNumber(), WithDisallowUnknownFields()), "json.Number 9007199254740993"},
+	}
+	for _, tt := range tests {
+		s := rpc.NewServer()
+		s.RegisterCodec(tt.codec, "application/json")
+		if err := s.RegisterService(new(Passthrough), ""); err != nil {
+			t.Fatal(err)
+		}
+		var res string
+		if err := DecodeClientResponse(executeRawBody(t, s, body).Body, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.expected {
+			t.Errorf("Param was %q, should be %q", res, tt.expected)
+		}
+	}
+}
+
+func TestRegisterNotification(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"Service1.Multiply", "Service1.ResponseError"} {
+		if err := s.RegisterNotification(method); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204 response, got %d %q", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "" {
+		t.Errorf("Expected no Content-Type, got %q", ct)
+	}
+
+	w = executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service1.ResponseError","params":{"A":4,"B":2},"id":1}`)
+	var res string
+	if err := DecodeClientResponse(w.Body, &res); err == nil || err.Error() != ErrResponseError.Error() {
+		t.Errorf("Expected the error of the method, got %v", err)
+	}
+}
+
+// identitySelector selects an identity encoder other than rpc.DefaultEncoder,
+// to exercise the unpooled write path.
+type identitySelector struct{}
+
+func (identitySelector) Select(*http.Request) rpc.Encoder {
+	return identityEncoder{}
+}
+
+type identityEncoder struct{}
+
+func (identityEncoder) Encode(w http.ResponseWriter) io.Writer {
+	return w
+}
+
+func TestPooledResponse(t *testing.T) {
+	const body = `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`
+	var responses []string
+	for _, encSel := range []rpc.EncoderSelector{rpc.DefaultEncoderSelector, identitySelector{}} {
+		for _, opts := range [][]Option{nil, {WithIndent("", "  ")}} {
+			s := rpc.NewServer()
+			s.RegisterCodec(NewCustomCodecWithOptions(encSel, opts...), "application/json")
+			if err := s.RegisterService(new(Service1), ""); err != nil {
+				t.Fatal(err)
+			}
+			// Twice, the second time with a buffer from the pool.
+			for i := 0; i < 2; i++ {
+				responses = append(responses, executeRawBody(t, s, body).Body.String())
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if responses[i] != responses[i+4] {
+			t.Errorf("Pooled response was %q, should be %q", responses[i], responses[i+4])
+		}
+	}
+	if responses[0] != responses[1] || responses[0] == responses[2] {
+		t.Errorf("Unexpected responses %q", responses)
+	}
+}
+
+func BenchmarkWriteResponse(b *testing.B) {
+	for _, bm := range []struct {
+		name   string
+		encSel rpc.EncoderSelector
+	}{
+		{"pooled", rpc.DefaultEncoderSelector},
+		{"unpooled", identitySelector{}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`))
+			codecReq := NewCustomCodec(bm.encSel).NewRequest(r)
+			w := NewRecorder()
+			reply := &Service1Response{Result: 8}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Body.Reset()
+				codecReq.WriteResponse(w, reply)
+			}
+		})
+	}
+}
+
+func TestEncoderOverride(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCustomCodec(&rpc.CompressionSelector{}), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetEncoderOverride(func(i *rpc.RequestInfo) rpc.Encoder {
+		if i.Method == "Service1.ResponseError" {
+			return rpc.DefaultEncoder
+		}
+		return nil
+	})
+
+	for _, tt := range []struct {
+		method, encoding string
+	}{
+		{"Service1.Multiply", "gzip"},
+		{"Service1.ResponseError", ""},
+	} {
+		body := `{"jsonrpc":"2.0","method":"` + tt.method + `","params":{"A":4,"B":2},"id":1}`
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if encoding := w.Header().Get("Content-Encoding"); encoding != tt.encoding {
+			t.Errorf("%s: Content-Encoding was %q, should be %q", tt.method, encoding, tt.encoding)
+		}
+		if tt.encoding == "" && !strings.Contains(w.Body.String(), ErrResponseError.Error()) {
+			t.Errorf("%s: expected an uncompressed error, got %q", tt.method, w.Body)
+		}
+	}
+}
+
+func (t *Service3) Throttle(r *http.Request, req *string, res *string) error {
+	return fmt.Errorf("throttled: %w", &rpc.RetryableError{RetryAfter: 1500 * time.Millisecond, Err: errors.New("slow down")})
+}
+
+func TestRetryableError(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service3.Throttle","params":"x","id":1}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", w.Code)
+	}
+	if after := w.Header().Get("Retry-After"); after != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", after)
+	}
+	var res string
+	if err := DecodeClientResponse(w.Body, &res); err == nil || err.Error() != "throttled: slow down" {
+		t.Errorf("Expected the error in the envelope, got %v", err)
+	}
+}
+
+type SumService struct{}
+
+func (t *SumService) Sum(r *http.Request, dec *json.Decoder, res *int) error {
+	var numbers []int
+	if err := dec.Decode(&numbers); err != nil {
+		return err
+	}
+	for _, n := range numbers {
+		*res += n
+	}
+	return nil
+}
+
+func TestStreamArgs(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(SumService), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The method reads the params, not the whole envelope.
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"SumService.Sum","params":[1,2,3],"id":1}`)
+	var res int
+	if err := DecodeClientResponse(w.Body, &res); err != nil || res != 6 {
+		t.Errorf("Expected 6, but got %d (%v)", res, err)
+	}
+}
+
+// conflictError is reported with 409 Conflict.
+type conflictError struct{}
+
+func (conflictError) Error() string   { return "version conflict" }
+func (conflictError) HTTPStatus() int { return http.StatusConflict }
+
+func (t *Service3) Update(r *http.Request, req *string, res *string) error {
+	return conflictError{}
+}
+
+func TestHTTPStatuser(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	var status int
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		status = i.StatusCode
+	})
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service3.Update","params":"x","id":1}`)
+	if w.Code != http.StatusConflict || status != http.StatusConflict {
+		t.Errorf("Status was %d (after func %d), should be 409", w.Code, status)
+	}
+	var res string
+	if err := DecodeClientResponse(w.Body, &res); err == nil || err.Error() != "version conflict" {
+		t.Errorf("Expected the error in the envelope, got %v", err)
+	}
+}
+
+func TestAfterFuncNotifications(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	var infos []*rpc.RequestInfo
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		infos = append(infos, i)
+	})
+
+	const (
+		notification = `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2}}`
+		call         = `{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`
+		parseError   = `{"jsonrpc":"2.0",`
+	)
+	for _, body := range []string{notification, call} {
+		executeRawBody(t, s, body)
+	}
+	if len(infos) != 2 || !infos[0].IsNotification || infos[1].IsNotification {
+		t.Fatalf("Expected the after func for a notification then a call, got %d calls", len(infos))
+	}
+	if infos[0].StatusCode != http.StatusOK || infos[0].Method != "Service1.Multiply" {
+		t.Errorf("Unexpected info for the notification: %+v", infos[0])
+	}
+
+	infos = nil
+	s.SetAfterFuncForNotifications(false)
+	for _, body := range []string{notification, call, parseError} {
+		if w := executeRawBody(t, s, body); body == notification && w.Body.Len() != 0 {
+			t.Errorf("Expected no response for the notification, got %q", w.Body)
+		}
+	}
+	if len(infos) != 1 || infos[0].IsNotification {
+		t.Errorf("Expected the after func for the call only, got %d calls", len(infos))
+	}
+}
+
+func TestEarlyErrorStatus(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method      string
+		contentType string
+		status      int
+	}{
+		{"GET", "application/json", http.StatusMethodNotAllowed},
+		{"PUT", "application/json", http.StatusMethodNotAllowed},
+		{"POST", "text/xml", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, "http://localhost:8080/", strings.NewReader(`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`))
+		r.Header.Set("Content-Type", tt.contentType)
+		w := NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: Status was %d, should be %d", tt.method, tt.contentType, w.Code, tt.status)
+		}
+		var res Service1Response
+		if _, ok := DecodeClientResponse(w.Body, &res).(*Error); !ok {
+			t.Errorf("%s %s: Expected a JSON-RPC error", tt.method, tt.contentType)
+		}
+	}
+
+	// Methods not cacheable can't be called with GET.
+	s.EnableGet()
+	r, _ := http.NewRequest("GET", "http://localhost:8080/", strings.NewReader(`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":2},"id":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("Response was %d with Allow %q, should be 405 with Allow POST", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestMethodNotFoundStatus(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	var status int
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		status = i.StatusCode
+	})
+	s.SetMethodNotFoundStatus(http.StatusNotFound)
+
+	w := executeRawBody(t, s, `{"jsonrpc":"2.0","method":"Service1.Missing","params":{},"id":1}`)
+	if w.Code != http.StatusNotFound || status != http.StatusNotFound {
+		t.Errorf("Status was %d (after func %d), should be 404", w.Code, status)
+	}
+	var res Service1Response
+	if jsonErr, ok := DecodeClientResponse(w.Body, &res).(*Error); !ok || jsonErr.Code != E_NO_METHOD {
+		t.Errorf("Expected an E_NO_METHOD error, got %v", jsonErr)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterValidateRequestFunc(func(i *rpc.RequestInfo, args interface{}) error {
+		if req, ok := args.(*Service1Request); ok && req.B == 0 {
+			return errors.New("B must not be zero")
+		}
+		return nil
+	})
+
+	tests := []struct {
+		body    string
+		status  int
+		code    ErrorCode
+		message string
+	}{
+		{`{"jsonrpc":"2.0","method":"Service1.ResponseError","params":{"A":4,"B":2},"id":1}`, http.StatusInternalServerError, E_INTERNAL, ErrResponseError.Error()},
+		{`{"jsonrpc":"2.0","method":"Service1.Multiply","params":{"A":4,"B":0},"id":1}`, http.StatusBadRequest, E_BAD_PARAMS, "B must not be zero"},
+		{`{"jsonrpc":"2.0","method":"Service1.Missing","params":{},"id":1}`, http.StatusBadRequest, E_NO_METHOD, ""},
+	}
+	for _, tt := range tests {
+		w := executeRawBody(t, s, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("Expected status %d for %s, got %d", tt.status, tt.body, w.Code)
+		}
+		var res string
+		err := DecodeClientResponse(w.Body, &res)
+		jsonErr, ok := err.(*Error)
+		if !ok || jsonErr.Code != tt.code || !strings.Contains(jsonErr.Message, tt.message) {
+			t.Errorf("Expected a %d error %q in the envelope for %s, got %v", tt.code, tt.message, tt.body, err)
+		}
+	}
+}
@@ -8,13 +8,23 @@ package json2
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/rpc/v2"
 )
 
-// var null = json.RawMessage([]byte("null"))
+var null = json.RawMessage([]byte("null"))
 var Version = "2.0"
 
 // ----------------------------------------------------------------------------
@@ -30,7 +40,8 @@ type serverRequest struct {
 	Method string `json:"method"`
 
 	// A Structured value to pass as arguments to the method.
-	Params *json.RawMessage `json:"params"`
+	// It is nil if the member is absent and "null" if it is null.
+	Params json.RawMessage `json:"params"`
 
 	// The request id. MUST be a string, number or null.
 	// Our implementation will not do type checking for id.
@@ -38,16 +49,59 @@ type serverRequest struct {
 	Id *json.RawMessage `json:"id"`
 }
 
+// paramsPresence returns whether the params member is absent, null or
+// holding a value.
+func (r *serverRequest) paramsPresence() ParamsPresence {
+	switch {
+	case r.Params == nil:
+		return ParamsAbsent
+	case string(r.Params) == "null":
+		return ParamsNull
+	}
+	return ParamsPresent
+}
+
+// ParamsPresence tells whether the params member of a request was absent,
+// null or holding a value.
+type ParamsPresence int
+
+const (
+	// ParamsAbsent means the request had no params member.
+	ParamsAbsent ParamsPresence = iota
+	// ParamsNull means the params member was an explicit null.
+	ParamsNull
+	// ParamsPresent means the params member held a structured value.
+	ParamsPresent
+)
+
+// ParamsPresenceSetter is implemented by args types that need to know
+// whether the params were absent or null, e.g. so that a validator or the
+// method can reject requests without params. ReadRequest calls
+// SetParamsPresence before decoding the params into the args.
+type ParamsPresenceSetter interface {
+	SetParamsPresence(ParamsPresence)
+}
+
 // serverResponse represents a JSON-RPC response returned by the server.
 type serverResponse struct {
 	// JSON-RPC protocol.
 	Version string `json:"jsonrpc"`
 
+	// The method dispatched for the request, only included when enabled
+	// with WithMethodInResponse. It isn't part of the spec.
+	Method string `json:"method,omitempty"`
+
 	// The Object that was returned by the invoked method. This must be null
 	// in case there was an error invoking the method.
 	// As per spec the member will be omitted if there was an error.
+	// On success it is never nil, a nil reply being written as null, so
+	// omitempty only drops it from error responses.
 	Result interface{} `json:"result,omitempty"`
 
+	// The attachments of the result, base64 encoded, if the client doesn't
+	// accept a multipart response. It isn't part of the spec.
+	Attachments []rpc.Attachment `json:"attachments,omitempty"`
+
 	// An Error object if there was an error invoking the method. It must be
 	// null if there was no error.
 	// As per spec the member will be omitted if there was no error.
@@ -79,20 +133,280 @@ func NewCustomCodecWithErrorMapper(encSel rpc.EncoderSelector, errorMapper func(
 	}
 }
 
+// NewCustomCodecWithOptions returns a new JSON Codec based on the passed
+// encoder selector and configured with the given options.
+func NewCustomCodecWithOptions(encSel rpc.EncoderSelector, opts ...Option) *Codec {
+	c := NewCustomCodec(encSel)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
 // NewCodec returns a new JSON Codec.
 func NewCodec() *Codec {
 	return NewCustomCodec(rpc.DefaultEncoderSelector)
 }
 
+// NewStrictCodec returns a new JSON Codec rejecting params with fields
+// unknown to the args, as with WithDisallowUnknownFields.
+func NewStrictCodec() *Codec {
+	return NewCustomCodecWithOptions(rpc.DefaultEncoderSelector, WithDisallowUnknownFields())
+}
+
+// Option configures a Codec created with NewCustomCodecWithOptions.
+type Option func(*Codec)
+
+// WithIdMode sets how request ids are echoed back in responses.
+// The default is IdRaw.
+func WithIdMode(mode IdMode) Option {
+	return func(c *Codec) {
+		c.idMode = mode
+	}
+}
+
+// WithEscapeHTML sets whether problematic HTML characters, like "<", ">"
+// and "&", are escaped inside JSON strings of the responses. The default is
+// true.
+func WithEscapeHTML(escape bool) Option {
+	return func(c *Codec) {
+		c.noEscapeHTML = !escape
+	}
+}
+
+// WithIndent sets the prefix and indent used to format the responses, e.g.
+// for debugging. By default responses are not indented.
+func WithIndent(prefix, indent string) Option {
+	return func(c *Codec) {
+		c.indentPrefix = prefix
+		c.indent = indent
+	}
+}
+
+// WithDisallowUnknownFields makes the codec reject the params with object
+// keys that don't match a field of the args, e.g. a misspelled field that
+// would otherwise be silently ignored, with an E_BAD_PARAMS error. By default
+// unknown fields are ignored.
+func WithDisallowUnknownFields() Option {
+	return func(c *Codec) {
+		c.strict = true
+	}
+}
+
+// WithUseNumber makes the codec decode the numbers of the params into
+// json.Number instead of float64 for interface{} values, e.g. in args of
+// type map[string]interface{}, so large integers keep their precision. By
+// default numbers are decoded as float64.
+func WithUseNumber() Option {
+	return func(c *Codec) {
+		c.useNumber = true
+	}
+}
+
+// WithNotificationID sets a function generating a correlation id for each
+// notification, which has no id of its own, so it can be traced alongside
+// the other requests. The id is only reported by CorrelationID, as
+// RequestInfo.CorrelationID, and is never sent to the client.
+func WithNotificationID(f func() string) Option {
+	return func(c *Codec) {
+		c.notificationID = f
+	}
+}
+
+// WithResponseContentType sets the Content-Type of the responses, e.g. a
+// vendor media type like "application/vnd.myapi+json". The default is
+// "application/json; charset=utf-8".
+func WithResponseContentType(contentType string) Option {
+	return func(c *Codec) {
+		c.contentType = contentType
+	}
+}
+
+// DefaultStreamFlushInterval is the number of elements of a streamed reply
+// written between flushes, unless set with WithStreamFlushInterval.
+const DefaultStreamFlushInterval = 100
+
+// WithStreamFlushInterval sets the number of elements of a streamed reply,
+// see rpc.ReplyStream, written between flushes of the response.
+func WithStreamFlushInterval(n int) Option {
+	return func(c *Codec) {
+		c.flushInterval = n
+	}
+}
+
+// WithMethodHeader makes the codec take the method from the given request
+// header, e.g. "X-RPC-Method", so the call can be identified without
+// reading the body. When the header is present it takes precedence over the
+// envelope: the whole body is the params of the method, without the
+// "jsonrpc", "method" and "id" members, and the response has a null id.
+// When it is absent the body is decoded as a regular JSON-RPC request.
+func WithMethodHeader(name string) Option {
+	return func(c *Codec) {
+		c.methodHeader = name
+	}
+}
+
+// WithMethodInResponse makes the codec include the method dispatched for
+// the request, as resolved by the server, in a "method" member of the
+// response, e.g. for clients demultiplexing responses over a shared
+// connection. The member isn't part of the spec, so it's omitted by default.
+func WithMethodInResponse(include bool) Option {
+	return func(c *Codec) {
+		c.methodInResponse = include
+	}
+}
+
+// WithQueryParams makes the codec read requests without a body from the
+// query string of the URL: the method from the "method" parameter and the
+// params from the JSON-encoded "params" parameter, as in
+// ?method=Service.Method&params={"A":2,"B":3}, e.g. for GET requests, see
+// rpc.Server.EnableGet. The response has a null id. A request body takes
+// precedence when present.
+func WithQueryParams() Option {
+	return func(c *Codec) {
+		c.queryParams = true
+	}
+}
+
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
-	encSel      rpc.EncoderSelector
-	errorMapper func(error) error
+	encSel           rpc.EncoderSelector
+	errorMapper      func(error) error
+	idMode           IdMode
+	noEscapeHTML     bool
+	indentPrefix     string
+	indent           string
+	logRequest       func(method string, params json.RawMessage)
+	redact           func(json.RawMessage) json.RawMessage
+	methodHeader     string
+	strict           bool
+	useNumber        bool
+	notificationID   func() string
+	contentType      string
+	logger           rpc.Logger
+	flushInterval    int
+	methodInResponse bool // include the dispatched method in responses
+	queryParams      bool // read requests without a body from the query string
+}
+
+// SetLogger sets the logger of the errors writing responses, e.g. when the
+// client disconnects. The standard logger is used by default.
+func (c *Codec) SetLogger(l rpc.Logger) {
+	c.logger = l
+}
+
+// logf logs using the logger set with SetLogger or the standard one.
+func (c *Codec) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// SetRequestLogger sets a function called with the method and params of
+// every successfully decoded request. Requests that fail to parse are not
+// logged. The params are passed through the redactor, if set.
+func (c *Codec) SetRequestLogger(f func(method string, params json.RawMessage)) {
+	c.logRequest = f
+}
+
+// SetRedactor sets a function returning the view of the params passed to
+// the request logger, e.g. with secrets or personal data removed. It must
+// not modify its argument.
+func (c *Codec) SetRedactor(f func(json.RawMessage) json.RawMessage) {
+	c.redact = f
+}
+
+// log calls the request logger, if any, with the redacted params.
+func (c *Codec) log(req *serverRequest) {
+	if c.logRequest == nil {
+		return
+	}
+	params := req.Params
+	if c.redact != nil {
+		params = c.redact(params)
+	}
+	c.logRequest(req.Method, params)
+}
+
+// unmarshal decodes the params into v, rejecting unknown fields if strict
+// and decoding numbers as json.Number if useNumber.
+func (c *Codec) unmarshal(params json.RawMessage, v interface{}) error {
+	if !c.strict && !c.useNumber {
+		return json.Unmarshal(params, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	if c.strict {
+		dec.DisallowUnknownFields()
+	}
+	if c.useNumber {
+		dec.UseNumber()
+	}
+	return dec.Decode(v)
+}
+
+// isUnknownField returns whether err reports a field unknown to the args.
+func isUnknownField(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field ")
 }
 
 // NewRequest returns a CodecRequest.
 func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	return newCodecRequest(r, c.encSel.Select(r), c.errorMapper)
+	return newCodecRequest(r, c.encSel.Select(r), c)
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	if c.contentType != "" {
+		return c.contentType
+	}
+	return "application/json; charset=utf-8"
+}
+
+// ----------------------------------------------------------------------------
+// Id
+// ----------------------------------------------------------------------------
+
+// IdMode selects how request ids are echoed back in responses.
+type IdMode int
+
+const (
+	// IdRaw echoes the id as received, in compact form.
+	IdRaw IdMode = iota
+	// IdUint64 requires numeric ids that fit in an uint64 and echoes them
+	// in canonical decimal form.
+	IdUint64
+	// IdString echoes the id as a JSON string, converting numeric ids.
+	IdString
+)
+
+// normalize returns the canonical form of id for the mode. A nil id, as
+// sent by notifications, is returned as is.
+func (m IdMode) normalize(id *json.RawMessage) (*json.RawMessage, error) {
+	if id == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, *id); err != nil {
+		return id, err
+	}
+	b := buf.Bytes()
+	switch m {
+	case IdUint64:
+		n, err := strconv.ParseUint(string(b), 10, 64)
+		if err != nil {
+			return id, fmt.Errorf("id must be an unsigned 64-bit integer, got %s", b)
+		}
+		b = strconv.AppendUint(nil, n, 10)
+	case IdString:
+		if len(b) > 0 && b[0] != '"' {
+			b = strconv.AppendQuote(nil, string(b))
+		}
+	}
+	raw := json.RawMessage(b)
+	return &raw, nil
 }
 
 // ----------------------------------------------------------------------------
@@ -100,8 +414,9 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 // ----------------------------------------------------------------------------
 
 // newCodecRequest returns a new CodecRequest.
-func newCodecRequest(r *http.Request, encoder rpc.Encoder, errorMapper func(error) error) rpc.CodecRequest {
+func newCodecRequest(r *http.Request, encoder rpc.Encoder, codec *Codec) rpc.CodecRequest {
 	req := new(serverRequest)
+	errorMapper := codec.errorMapper
 
 	// Copy request body for decoding and access of underlying methods
 	b, err := io.ReadAll(r.Body)
@@ -112,31 +427,119 @@ func newCodecRequest(r *http.Request, encoder rpc.Encoder, errorMapper func(erro
 			Data:    req,
 		}
 
-		return &CodecRequest{request: req, err: err, encoder: encoder, errorMapper: errorMapper}
+		return &CodecRequest{request: req, err: err, encoder: encoder, errorMapper: errorMapper, codec: codec}
 	}
 	// Close original body
 	r.Body.Close()
 
+	// Add close method to buffer and pass as request body
+	defer func() { r.Body = io.NopCloser(bytes.NewBuffer(b)) }()
+
+	// Take the method from the header if present, the body being the params.
+	if codec.methodHeader != "" {
+		if method := r.Header.Get(codec.methodHeader); method != "" {
+			req.Version = Version
+			req.Method = method
+			if len(bytes.TrimSpace(b)) > 0 {
+				req.Params = b
+			}
+			req.Id = &null
+			codec.log(req)
+			return &CodecRequest{request: req, encoder: encoder, errorMapper: errorMapper, codec: codec, multipart: rpc.AcceptsMultipart(r)}
+		}
+	}
+
 	// Decode the request body and check if RPC method is valid.
-	err = json.Unmarshal(b, req)
-	if err != nil {
+	empty := len(bytes.TrimSpace(b)) == 0
+	if empty && codec.queryParams {
+		if query := r.URL.Query(); query.Get("method") != "" {
+			return newQueryRequest(r, query, encoder, codec)
+		}
+	}
+	if empty {
+		err = &Error{
+			Code:    E_INVALID_REQ,
+			Message: "empty request body",
+			Data:    req,
+		}
+	} else if err = json.Unmarshal(b, req); err != nil {
 		err = &Error{
 			Code:    E_PARSE,
 			Message: err.Error(),
-			Data:    req,
+			Data:    parseErrorData(req, err),
+			cause:   err,
 		}
+		// The id may be partially decoded.
+		req.Id = &null
 	} else if req.Version != Version {
 		err = &Error{
 			Code:    E_INVALID_REQ,
 			Message: "jsonrpc must be " + Version,
 			Data:    req,
 		}
+		// Answer even when the request looks like a notification, since
+		// it can't be told from a request with a missing id.
+		if req.Id == nil {
+			req.Id = &null
+		}
+	} else if id, errId := codec.idMode.normalize(req.Id); errId != nil {
+		err = &Error{
+			Code:    E_INVALID_REQ,
+			Message: errId.Error(),
+			Data:    req,
+		}
+	} else {
+		req.Id = id
+		codec.log(req)
 	}
 
-	// Add close method to buffer and pass as request body
-	r.Body = io.NopCloser(bytes.NewBuffer(b))
+	return &CodecRequest{request: req, err: err, encoder: encoder, errorMapper: errorMapper, codec: codec, empty: empty, multipart: rpc.AcceptsMultipart(r)}
+}
 
-	return &CodecRequest{request: req, err: err, encoder: encoder, errorMapper: errorMapper}
+// newQueryRequest returns the CodecRequest for the method and params read
+// from the query string of r.
+func newQueryRequest(r *http.Request, query url.Values, encoder rpc.Encoder, codec *Codec) *CodecRequest {
+	req := &serverRequest{
+		Version: Version,
+		Method:  query.Get("method"),
+		Id:      &null,
+	}
+	var err error
+	if params := query.Get("params"); params != "" {
+		if !json.Valid([]byte(params)) {
+			err = &Error{
+				Code:    E_PARSE,
+				Message: "invalid JSON in the params query parameter",
+				Data:    params,
+			}
+		}
+		req.Params = json.RawMessage(params)
+	}
+	if err == nil {
+		codec.log(req)
+	}
+	return &CodecRequest{request: req, err: err, encoder: encoder, errorMapper: codec.errorMapper, codec: codec, multipart: rpc.AcceptsMultipart(r)}
+}
+
+// parseError is the data of an E_PARSE error: the partially decoded request
+// and the offset in the body where decoding failed, when known.
+type parseError struct {
+	serverRequest
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// parseErrorData returns the data of the E_PARSE error for the decoding
+// error err of req.
+func parseErrorData(req *serverRequest, err error) *parseError {
+	data := &parseError{serverRequest: *req}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		data.Offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		data.Offset = typeErr.Offset
+	}
+	return data
 }
 
 // CodecRequest decodes and encodes a single request.
@@ -145,6 +548,56 @@ type CodecRequest struct {
 	err         error
 	encoder     rpc.Encoder
 	errorMapper func(error) error
+	codec       *Codec
+	empty       bool // the request body was empty
+	// generated correlation id of a notification
+	correlationID string
+	// method dispatched by the server, if different from the request's
+	resolvedMethod string
+	// the client accepts a multipart response
+	multipart bool
+}
+
+// SetResolvedMethod implements rpc.ResolvedMethodSetter.
+func (c *CodecRequest) SetResolvedMethod(method string) {
+	c.resolvedMethod = method
+}
+
+// responseMethod returns the method to include in the response, or an
+// empty string unless enabled with WithMethodInResponse.
+func (c *CodecRequest) responseMethod() string {
+	if !c.codec.methodInResponse {
+		return ""
+	}
+	if c.resolvedMethod != "" {
+		return c.resolvedMethod
+	}
+	return c.request.Method
+}
+
+// CorrelationID returns the request id, in compact JSON form, or for
+// notifications the id generated by the function set with
+// WithNotificationID, if any. It implements rpc.CorrelationIDer.
+func (c *CodecRequest) CorrelationID() string {
+	if id := c.request.Id; id != nil && string(*id) != "null" {
+		return string(*id)
+	}
+	if c.correlationID == "" && c.codec.notificationID != nil {
+		c.correlationID = c.codec.notificationID()
+	}
+	return c.correlationID
+}
+
+// Notification implements rpc.Notifier: requests without an id are
+// notifications, unless they couldn't be parsed or had an empty body.
+func (c *CodecRequest) Notification() bool {
+	return c.request.Id == nil && !c.empty
+}
+
+// SetEncoder implements rpc.EncoderSetter, replacing the encoder selected
+// for the response.
+func (c *CodecRequest) SetEncoder(enc rpc.Encoder) {
+	c.encoder = enc
 }
 
 // Method returns the RPC method for the current request.
@@ -157,6 +610,34 @@ func (c *CodecRequest) Method() (string, error) {
 	return "", c.err
 }
 
+// ParamsPresence returns whether the params member of the request was
+// absent, null or holding a value.
+func (c *CodecRequest) ParamsPresence() ParamsPresence {
+	return c.request.paramsPresence()
+}
+
+// TransformParams replaces the params of the request by the ones returned
+// by f, which receives nil if they are absent. A failure is reported as an
+// E_BAD_PARAMS error. It implements rpc.ParamsTransformer.
+func (c *CodecRequest) TransformParams(f func(json.RawMessage) (json.RawMessage, error)) error {
+	if c.err != nil {
+		return c.err
+	}
+	params, err := f(c.request.Params)
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = &Error{
+				Code:    E_BAD_PARAMS,
+				Message: err.Error(),
+				cause:   err,
+			}
+		}
+		return err
+	}
+	c.request.Params = params
+	return nil
+}
+
 // ReadRequest fills the request object for the RPC method.
 //
 // ReadRequest parses request parameters in two supported forms in
@@ -170,53 +651,281 @@ func (c *CodecRequest) Method() (string, error) {
 // absence of expected names MAY result in an error being
 // generated. The names MUST match exactly, including
 // case, to the method's expected parameters.
+//
+// If args implements ParamsPresenceSetter, it is told whether the params
+// were absent, null or present before they are decoded.
+//
+// If args is a *json.RawMessage, it is filled with the untouched params.
+// This allows proxy services to forward the params without a full
+// decode/encode cycle:
+//
+//	func (p *Proxy) Forward(r *http.Request, args *json.RawMessage, reply *json.RawMessage) error
 func (c *CodecRequest) ReadRequest(args interface{}) error {
-	if c.err == nil && c.request.Params != nil {
-		// Note: if c.request.Params is nil it's not an error, it's an optional member.
+	presence := c.request.paramsPresence()
+	if setter, ok := args.(ParamsPresenceSetter); ok && c.err == nil {
+		setter.SetParamsPresence(presence)
+	}
+	if raw, ok := args.(*json.RawMessage); ok && c.err == nil {
+		if presence == ParamsPresent {
+			*raw = append((*raw)[:0], c.request.Params...)
+		}
+		return nil
+	}
+	if c.err == nil && presence == ParamsPresent {
+		// Note: if c.request.Params is absent or null it's not an error, it's an optional member.
 		// JSON params structured object. Unmarshal to the args object.
-		if err := json.Unmarshal(*c.request.Params, args); err != nil {
+		err := c.codec.unmarshal(c.request.Params, args)
+		if err != nil && !isUnknownField(err) {
 			// Clearly JSON params is not a structured object,
 			// fallback and attempt an unmarshal with JSON params as
 			// array value and RPC params is struct. Unmarshal into
 			// array containing the request struct.
 			params := [1]interface{}{args}
-			if err = json.Unmarshal(*c.request.Params, &params); err != nil {
-				c.err = &Error{
-					Code:    E_INVALID_REQ,
-					Message: err.Error(),
-					Data:    c.request.Params,
-				}
+			err = c.codec.unmarshal(c.request.Params, &params)
+		}
+		if err != nil {
+			c.err = &Error{
+				Code:    E_BAD_PARAMS,
+				Message: err.Error(),
+				Data:    c.request.Params,
 			}
 		}
 	}
 	return c.err
 }
 
+// StreamParams implements rpc.StreamRequestReader, returning the params for
+// the methods reading them themselves, empty if absent.
+func (c *CodecRequest) StreamParams() io.Reader {
+	return bytes.NewReader(c.request.Params)
+}
+
 // WriteResponse encodes the response and writes it to the ResponseWriter.
+//
+// The attachments of a reply implementing rpc.Attacher are written as the
+// parts following the JSON response in a "multipart/mixed" body if the
+// client accepts it, or else base64 encoded in the "attachments" member.
 func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	var attachments []rpc.Attachment
+	if a, ok := reply.(rpc.Attacher); ok {
+		attachments = a.Attachments()
+	}
+	// The result member is required on success, so a nil reply is written
+	// as an explicit null instead of being omitted.
+	if reply == nil {
+		reply = &null
+	}
 	res := &serverResponse{
 		Version: Version,
 		Result:  reply,
 		Id:      c.request.Id,
 	}
-	c.writeServerResponse(w, res)
+	if len(attachments) > 0 {
+		if c.multipart {
+			c.writeMultipartResponse(w, res, attachments)
+			return
+		}
+		res.Attachments = attachments
+	}
+	c.writeServerResponse(w, http.StatusOK, res)
+}
+
+// writeMultipartResponse writes res as the first part of a
+// "multipart/mixed" body, followed by a part for each attachment.
+// Notifications don't have a response.
+func (c *CodecRequest) writeMultipartResponse(w http.ResponseWriter, res *serverResponse, attachments []rpc.Attachment) {
+	if c.request.Id == nil {
+		return
+	}
+	res.Method = c.responseMethod()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {c.codec.ResponseContentType()}})
+	encoder := json.NewEncoder(part)
+	encoder.SetEscapeHTML(!c.codec.noEscapeHTML)
+	encoder.SetIndent(c.codec.indentPrefix, c.codec.indent)
+	if err := encoder.Encode(res); err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := textproto.MIMEHeader{"Content-Type": {contentType}}
+		if a.Name != "" {
+			header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		}
+		part, _ := mw.CreatePart(header)
+		part.Write(a.Data)
+	}
+	mw.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	if _, err := c.encoder.Encode(w).Write(buf.Bytes()); err != nil {
+		c.codec.logf("rpc: error writing the response: %v", err)
+	}
+}
+
+// WriteRawResponse writes the body of res as is, through the selected
+// encoder, with its content type. Notifications don't have a response.
+func (c *CodecRequest) WriteRawResponse(w http.ResponseWriter, res *rpc.RawResponse) {
+	if c.request.Id == nil {
+		return
+	}
+	contentType := res.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	c.encoder.Encode(w).Write(res.Body)
+}
+
+// WriteStreamResponse writes the elements of stream as the result array,
+// one at a time, flushing the response periodically. The response isn't
+// compressed or indented. If the stream fails, the response is left
+// incomplete, so clients don't take it for the whole result, and the error
+// is reported with rpc.WriteErrorTrailer. Notifications don't have a
+// response.
+func (c *CodecRequest) WriteStreamResponse(w http.ResponseWriter, stream rpc.ReplyStream) {
+	if c.request.Id == nil {
+		return
+	}
+	flushInterval := c.codec.flushInterval
+	if flushInterval <= 0 {
+		flushInterval = DefaultStreamFlushInterval
+	}
+	flusher, _ := w.(http.Flusher)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(!c.codec.noEscapeHTML)
+
+	w.Header().Set("Content-Type", c.codec.ResponseContentType())
+	buf.WriteString(`{"jsonrpc":"` + Version + `",`)
+	if method := c.responseMethod(); method != "" {
+		b, _ := json.Marshal(method)
+		buf.WriteString(`"method":` + string(b) + `,`)
+	}
+	buf.WriteString(`"result":[`)
+	for n := 0; ; n++ {
+		v, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			if err = encoder.Encode(v); err == nil {
+				// Drop the newline written by Encode.
+				buf.Truncate(buf.Len() - 1)
+			}
+		}
+		if err != nil {
+			w.Write(buf.Bytes())
+			c.codec.logf("rpc: error streaming the response: %v", err)
+			rpc.WriteErrorTrailer(w, err)
+			return
+		}
+		if n%flushInterval == flushInterval-1 {
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				c.codec.logf("rpc: error writing the response: %v", err)
+				return
+			}
+			buf.Reset()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	buf.WriteString(`],"id":`)
+	buf.Write(*c.request.Id)
+	buf.WriteString("}\n")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		c.codec.logf("rpc: error writing the response: %v", err)
+	}
 }
 
+// WriteError encodes the error and writes it to the ResponseWriter with
+// the given status.
+//
+// An *Error is written as is. Other errors get the code they carry if they
+// implement rpc.ErrorCoder, e.g. E_NO_METHOD when the method isn't found or
+// E_BAD_PARAMS when a validator rejects the args, E_INTERNAL if the status
+// is a 5xx, like the errors returned by service methods, see ErrorStatus,
+// and E_SERVER otherwise. Errors reading the request are *Error values with
+// the E_PARSE, E_INVALID_REQ or E_BAD_PARAMS codes. If such an error, or one
+// it wraps, implements json.Marshaler, e.g. a structured error of the
+// method, its JSON form is sent as the data.
 func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
 	err = c.tryToMapIfNotAnErrorAlready(err)
 	jsonErr, ok := err.(*Error)
 	if !ok {
+		code := E_SERVER
+		var coder rpc.ErrorCoder
+		if errors.As(err, &coder) {
+			code = ErrorCode(coder.ErrorCode())
+		} else if status >= 500 {
+			code = E_INTERNAL
+		}
 		jsonErr = &Error{
-			Code:    E_SERVER,
+			Code:    code,
 			Message: err.Error(),
 		}
+		var multi *rpc.MultiError
+		if errors.As(err, &multi) {
+			// Report each error, e.g. each invalid field.
+			msgs := make([]string, len(multi.Errors))
+			for i, e := range multi.Errors {
+				msgs[i] = e.Error()
+			}
+			jsonErr.Data = msgs
+		} else if data, ok := marshalError(err); ok {
+			jsonErr.Data = data
+		}
 	}
 	res := &serverResponse{
 		Version: Version,
 		Error:   jsonErr,
 		Id:      c.request.Id,
 	}
-	c.writeServerResponse(w, res)
+	c.writeServerResponse(w, status, res)
+}
+
+// ErrorStatus implements rpc.ErrorStatuser, choosing the status of the
+// errors returned by service methods from their code, once mapped by the
+// error mapper of the codec: 400 Bad Request for the E_PARSE,
+// E_INVALID_REQ, E_NO_METHOD and E_BAD_PARAMS codes and 500 Internal Server
+// Error otherwise, including for the errors without a code.
+func (c *CodecRequest) ErrorStatus(err error) int {
+	err = c.tryToMapIfNotAnErrorAlready(err)
+	var code ErrorCode
+	var coder rpc.ErrorCoder
+	if jsonErr, ok := err.(*Error); ok {
+		code = jsonErr.Code
+	} else if errors.As(err, &coder) {
+		code = ErrorCode(coder.ErrorCode())
+	}
+	switch code {
+	case E_PARSE, E_INVALID_REQ, E_NO_METHOD, E_BAD_PARAMS:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// marshalError returns the JSON form of err, or of the error it wraps,
+// implementing json.Marshaler, e.g. a structured error of the method. It
+// returns false if there is none or it can't be marshaled.
+func marshalError(err error) (json.RawMessage, bool) {
+	var marshaler json.Marshaler
+	if !errors.As(err, &marshaler) {
+		return nil, false
+	}
+	data, errMarshal := marshaler.MarshalJSON()
+	if errMarshal != nil || !json.Valid(data) {
+		return nil, false
+	}
+	return json.RawMessage(data), true
 }
 
 func (c CodecRequest) tryToMapIfNotAnErrorAlready(err error) error {
@@ -226,19 +935,87 @@ func (c CodecRequest) tryToMapIfNotAnErrorAlready(err error) error {
 	return c.errorMapper(err)
 }
 
-func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, res *serverResponse) {
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res *serverResponse) {
 	// Id is null for notifications and they don't have a response, unless we couldn't even parse the JSON, in that
-	// case we can't know whether it was intended to be a notification
-	if c.request.Id != nil || isParseErrorResponse(res) {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		encoder := json.NewEncoder(c.encoder.Encode(w))
-		err := encoder.Encode(res)
-
-		// Not sure in which case will this happen. But seems harmless.
-		if err != nil {
-			rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+	// case we can't know whether it was intended to be a notification. An empty body is never a notification either.
+	// Requests with a parse error or the wrong version get an id, null if missing or not trusted, from newCodecRequest.
+	if c.request.Id != nil || isParseErrorResponse(res) || c.empty {
+		res.Method = c.responseMethod()
+		// Encode to a buffer first, so an encoding error can still be
+		// written as a response. Uncompressed responses, the common case,
+		// use a pooled buffer and encoder.
+		if c.encoder == rpc.DefaultEncoder {
+			b := responseBufferPool.Get().(*responseBuffer)
+			defer b.release()
+			c.writeEncoded(w, status, res, &b.buf, b.encoder)
+			return
 		}
+		var buf bytes.Buffer
+		c.writeEncoded(w, status, res, &buf, json.NewEncoder(&buf))
+	}
+}
+
+// writeEncoded encodes res to buf with encoder, which writes to it, and
+// writes buf with status.
+func (c *CodecRequest) writeEncoded(w http.ResponseWriter, status int, res *serverResponse, buf *bytes.Buffer, encoder *json.Encoder) {
+	encoder.SetEscapeHTML(!c.codec.noEscapeHTML)
+	encoder.SetIndent(c.codec.indentPrefix, c.codec.indent)
+	if err := encoder.Encode(res); err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", c.codec.ResponseContentType())
+	if status != http.StatusOK {
+		w = &statusWriter{ResponseWriter: w, status: status}
+	}
+	// The response may be partially sent when the write fails, e.g.
+	// because the client is gone, so there's nothing left to do but log.
+	if _, err := c.encoder.Encode(w).Write(buf.Bytes()); err != nil {
+		c.codec.logf("rpc: error writing the response: %v", err)
+	}
+}
+
+// statusWriter writes the header with status before the body, once the
+// encoder has set its own headers.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.WriteHeader(w.status)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// maxPooledBuffer is the capacity above which a response buffer isn't put
+// back in the pool, so that a few large responses don't pin memory.
+const maxPooledBuffer = 64 << 10
+
+// responseBuffer is a pooled buffer with an encoder writing to it.
+type responseBuffer struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+}
+
+var responseBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := new(responseBuffer)
+		b.encoder = json.NewEncoder(&b.buf)
+		return b
+	},
+}
+
+// release puts b back in the pool, unless it grew too large.
+func (b *responseBuffer) release() {
+	if b.buf.Cap() > maxPooledBuffer {
+		return
 	}
+	b.buf.Reset()
+	responseBufferPool.Put(b)
 }
 
 func isParseErrorResponse(res *serverResponse) bool {
@@ -0,0 +1,146 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc1
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// clientRequest represents a JSON-RPC 1.0 request sent by a client.
+type clientRequest struct {
+	// A String containing the name of the method to be invoked.
+	Method string `json:"method"`
+	// Positional params to pass to the method.
+	Params []interface{} `json:"params"`
+	// The request id. This can be of any type. It is used to match the
+	// response with the request that it is replying to.
+	Id uint64 `json:"id"`
+}
+
+// clientResponse represents a JSON-RPC 1.0 response returned to a client.
+type clientResponse struct {
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+	Id     uint64           `json:"id"`
+}
+
+// positionalParams returns the positional params for args: the exported
+// fields of a struct, in order, or args itself.
+func positionalParams(args interface{}) []interface{} {
+	v := reflect.Indirect(reflect.ValueOf(args))
+	if v.Kind() != reflect.Struct {
+		if args == nil {
+			return []interface{}{}
+		}
+		return []interface{}{args}
+	}
+	fields := positionalFields(v.Type())
+	params := make([]interface{}, len(fields))
+	for i, f := range fields {
+		params[i] = v.Field(f).Interface()
+	}
+	return params
+}
+
+// EncodeClientRequest encodes parameters for a JSON-RPC 1.0 client request.
+// The exported fields of an args struct are sent as positional params.
+func EncodeClientRequest(method string, args interface{}) ([]byte, error) {
+	val, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt64)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return encodeClientRequest(method, args, val.Uint64())
+}
+
+// encodeClientRequest encodes parameters for a request with the given id.
+func encodeClientRequest(method string, args interface{}, id uint64) ([]byte, error) {
+	c := &clientRequest{
+		Method: method,
+		Params: positionalParams(args),
+		Id:     id,
+	}
+	return json.Marshal(c)
+}
+
+// DecodeClientResponse decodes the response body of a client request into
+// the interface reply.
+func DecodeClientResponse(r io.Reader, reply interface{}) error {
+	var c clientResponse
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return err
+	}
+	if c.Error != nil {
+		return &Error{Data: c.Error}
+	}
+	if c.Result == nil {
+		return fmt.Errorf("unexpected null result")
+	}
+	return json.Unmarshal(*c.Result, reply)
+}
+
+// ----------------------------------------------------------------------------
+// Client
+// ----------------------------------------------------------------------------
+
+// NewClient returns a new Client calling the server at url. If httpClient
+// is nil, http.DefaultClient is used.
+func NewClient(url string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{url: url, httpClient: httpClient}
+}
+
+// Client calls the methods of a JSON-RPC 1.0 server over HTTP, reusing the
+// underlying http.Client. It is safe for concurrent use.
+type Client struct {
+	url        string
+	httpClient *http.Client
+	id         uint64
+}
+
+// Call calls the method with args, sent as positional params, and decodes
+// the result into reply.
+//
+// The method uses a dotted notation as in "Service.Method". Errors returned
+// by the server are decoded as *Error values.
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	b, err := encodeClientRequest(method, args, atomic.AddUint64(&c.id, 1))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("rpc: unexpected response %s: %s", res.Status, msg)
+	}
+	return DecodeClientResponse(res.Body, reply)
+}
@@ -0,0 +1,57 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/jsonrpc1 provides a codec for legacy JSON-RPC 1.0 clients
+over HTTP services.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/jsonrpc1"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(jsonrpc1.NewCodec(), "application/json")
+		// [...]
+		http.Handle("/rpc", s)
+	}
+
+A codec is tied to a content type. In the example above, the server will use
+the JSON-RPC 1.0 codec for requests with "application/json" as the value for
+the "Content-Type" header.
+
+This package follows the JSON-RPC 1.0 specification:
+
+	http://json-rpc.org/wiki/specification
+
+Unlike the json package, which expects an array with a single object, the
+params are positional: the elements of the array are decoded in order into
+the exported fields of the args struct. Fields without a matching element
+keep their zero value. Args that are not structs, like *string, take a single
+element. For example, given:
+
+	type Args struct {
+		A, B int
+	}
+
+	func (t *Arith) Multiply(r *http.Request, args *Args, reply *int) error
+
+the request {"method": "Arith.Multiply", "params": [4, 2], "id": 1} calls
+Multiply with Args{A: 4, B: 2}.
+
+Responses always have both the result and error members, one of them being
+null. Requests with a null id are notifications and get no response.
+
+The Client and EncodeClientRequest encode the exported fields of args as
+positional params in the same way.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package jsonrpc1
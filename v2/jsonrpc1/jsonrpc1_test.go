@@ -0,0 +1,167 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+var ErrResponseError = errors.New("response error")
+
+type ArithArgs struct {
+	A, B   int
+	hidden int
+	Skip   int `json:"-"`
+}
+
+type Arith struct {
+}
+
+func (t *Arith) Multiply(r *http.Request, req *ArithArgs, res *int) error {
+	*res = req.A * req.B
+	return nil
+}
+
+func (t *Arith) Echo(r *http.Request, req *string, res *string) error {
+	*res = *req
+	return nil
+}
+
+func (t *Arith) Fail(r *http.Request, req *ArithArgs, res *int) error {
+	return ErrResponseError
+}
+
+func newServer(t *testing.T) *rpc.Server {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Arith), ""); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func execute(s *rpc.Server, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestPositionalParams(t *testing.T) {
+	s := newServer(t)
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"method":"Arith.Multiply","params":[4,2],"id":1}`, `{"result":8,"error":null,"id":1}`},
+		{`{"method":"Arith.Multiply","params":[4],"id":2}`, `{"result":0,"error":null,"id":2}`},
+		{`{"method":"Arith.Multiply","params":[],"id":3}`, `{"result":0,"error":null,"id":3}`},
+		{`{"method":"Arith.Echo","params":["hi"],"id":"a"}`, `{"result":"hi","error":null,"id":"a"}`},
+		{`{"method":"Arith.Multiply","params":[1,2,3],"id":4}`,
+			`{"result":null,"error":"rpc: too many params: got 3, want at most 2","id":4}`},
+		{`{"method":"Arith.Echo","params":["a","b"],"id":5}`,
+			`{"result":null,"error":"rpc: too many params: got 2, want 1","id":5}`},
+		{`{"method":"Arith.Multiply","params":{"A":1},"id":6}`,
+			`{"result":null,"error":"rpc: params must be an array","id":6}`},
+		{`{"method":"Arith.Multiply","params":["x"],"id":7}`,
+			`{"result":null,"error":"rpc: param 0: json: cannot unmarshal string into Go value of type int","id":7}`},
+		{`{"method":"Arith.Fail","params":[],"id":8}`, `{"result":null,"error":"response error","id":8}`},
+		{`{"method":"Arith.Multiply","params":[4,2],"id":null}`, ``},
+	}
+	for _, tt := range tests {
+		w := execute(s, tt.body)
+		if got := w.Body.String(); got != tt.expected {
+			t.Errorf("%s: Response was %s, should be %s", tt.body, got, tt.expected)
+		}
+	}
+}
+
+func TestBeforeFunc(t *testing.T) {
+	s := newServer(t)
+	var method string
+	s.RegisterBeforeFunc(func(i *rpc.RequestInfo) {
+		method = i.Method
+	})
+
+	w := execute(s, `{"method":"Arith.Multiply","params":[4,2],"id":1}`)
+	if expected := `{"result":8,"error":null,"id":1}`; w.Body.String() != expected {
+		t.Errorf("Response was %s, should be %s", w.Body, expected)
+	}
+	if method != "Arith.Multiply" {
+		t.Errorf("Method was %q, should be Arith.Multiply", method)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	s := newServer(t)
+	var notification, called bool
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		notification, called = i.IsNotification, true
+	})
+
+	tests := []struct {
+		body         string
+		notification bool
+	}{
+		{`{"method":"Arith.Multiply","params":[4,2],"id":1}`, false},
+		{`{"method":"Arith.Multiply","params":[4,2],"id":null}`, true},
+		{`{"method":"Arith.Multiply","params":[4,2]}`, true},
+	}
+	for _, tt := range tests {
+		notification, called = false, false
+		execute(s, tt.body)
+		if !called || notification != tt.notification {
+			t.Errorf("%s: IsNotification was %v, should be %v", tt.body, notification, tt.notification)
+		}
+	}
+	codecReq := NewCodec().NewRequest(httptest.NewRequest("POST", "/", strings.NewReader(`{"method":`)))
+	if codecReq.(rpc.Notifier).Notification() {
+		t.Error("Requests that couldn't be parsed should not be notifications")
+	}
+}
+
+func TestClient(t *testing.T) {
+	ts := httptest.NewServer(newServer(t))
+	defer ts.Close()
+
+	c := NewClient(ts.URL, nil)
+	var product int
+	if err := c.Call(context.Background(), "Arith.Multiply", &ArithArgs{A: 6, B: 7, Skip: 1}, &product); err != nil {
+		t.Fatal(err)
+	}
+	if product != 42 {
+		t.Errorf("Result was %d, should be 42", product)
+	}
+	var echo string
+	if err := c.Call(context.Background(), "Arith.Echo", "hello", &echo); err != nil {
+		t.Fatal(err)
+	}
+	if echo != "hello" {
+		t.Errorf("Result was %q, should be hello", echo)
+	}
+	err := c.Call(context.Background(), "Arith.Fail", &ArithArgs{}, &product)
+	if jsonErr, ok := err.(*Error); !ok || jsonErr.Data != "response error" {
+		t.Errorf("Error was %v, should be response error", err)
+	}
+}
+
+func TestEncodeClientRequest(t *testing.T) {
+	b, err := encodeClientRequest("Arith.Multiply", &ArithArgs{A: 1, B: 2, hidden: 3, Skip: 4}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"method":"Arith.Multiply","params":[1,2],"id":7}`; string(b) != expected {
+		t.Errorf("Request was %s, should be %s", b, expected)
+	}
+}
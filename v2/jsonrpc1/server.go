@@ -0,0 +1,228 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+var null = json.RawMessage([]byte("null"))
+
+// An Error is a wrapper for a JSON interface value. It can be used by either
+// a service's handler func to write more complex JSON data to an error field
+// of a server's response, or by a client to read it.
+type Error struct {
+	Data interface{}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v", e.Data)
+}
+
+// ----------------------------------------------------------------------------
+// Request and Response
+// ----------------------------------------------------------------------------
+
+// serverRequest represents a JSON-RPC 1.0 request received by the server.
+type serverRequest struct {
+	// A String containing the name of the method to be invoked.
+	Method string `json:"method"`
+	// An Array of objects to pass as positional arguments to the method.
+	Params *json.RawMessage `json:"params"`
+	// The request id. This can be of any type. It is used to match the
+	// response with the request that it is replying to.
+	Id *json.RawMessage `json:"id"`
+}
+
+// serverResponse represents a JSON-RPC 1.0 response returned by the server.
+type serverResponse struct {
+	// The Object that was returned by the invoked method. This must be null
+	// in case there was an error invoking the method.
+	Result interface{} `json:"result"`
+	// An Error object if there was an error invoking the method. It must be
+	// null if there was no error.
+	Error interface{} `json:"error"`
+	// This must be the same id as the request it is responding to.
+	Id *json.RawMessage `json:"id"`
+}
+
+// positionalFields returns the indexes of the fields of the struct type t
+// that take the positional params, in order: the exported fields not
+// tagged with `json:"-"`.
+func positionalFields(t reflect.Type) []int {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		fields = append(fields, i)
+	}
+	return fields
+}
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new JSON-RPC 1.0 Codec.
+func NewCodec() *Codec {
+	return NewCustomCodec(rpc.DefaultEncoderSelector)
+}
+
+// NewCustomCodec returns a new JSON-RPC 1.0 Codec based on the passed
+// encoder selector.
+func NewCustomCodec(encSel rpc.EncoderSelector) *Codec {
+	return &Codec{encSel: encSel}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+	encSel rpc.EncoderSelector
+}
+
+// NewRequest returns a CodecRequest.
+//
+// The body is read and put back on the request, so that it can be decoded
+// again once the before funcs have run.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	req := new(serverRequest)
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err == nil {
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		err = json.NewDecoder(bytes.NewReader(b)).Decode(req)
+	}
+	return &CodecRequest{request: req, err: err, encoder: c.encSel.Select(r)}
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	request *serverRequest
+	err     error
+	encoder rpc.Encoder
+}
+
+// SetEncoder implements rpc.EncoderSetter, replacing the encoder selected
+// for the response.
+func (c *CodecRequest) SetEncoder(enc rpc.Encoder) {
+	c.encoder = enc
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err == nil {
+		return c.request.Method, nil
+	}
+	return "", c.err
+}
+
+// Notification implements rpc.Notifier: requests with a null or missing id
+// are notifications, unless they couldn't be parsed.
+func (c *CodecRequest) Notification() bool {
+	return c.request.Id == nil && c.err == nil
+}
+
+// ReadRequest fills the request object for the RPC method from the
+// positional params.
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.request.Params == nil {
+		return nil
+	}
+	var params []json.RawMessage
+	if err := json.Unmarshal(*c.request.Params, &params); err != nil {
+		c.err = errors.New("rpc: params must be an array")
+		return c.err
+	}
+	v := reflect.ValueOf(args).Elem()
+	if v.Kind() != reflect.Struct {
+		if len(params) > 1 {
+			c.err = fmt.Errorf("rpc: too many params: got %d, want 1", len(params))
+		} else if len(params) == 1 {
+			c.err = json.Unmarshal(params[0], args)
+		}
+		return c.err
+	}
+	fields := positionalFields(v.Type())
+	if len(params) > len(fields) {
+		c.err = fmt.Errorf("rpc: too many params: got %d, want at most %d", len(params), len(fields))
+		return c.err
+	}
+	for i, p := range params {
+		if err := json.Unmarshal(p, v.Field(fields[i]).Addr().Interface()); err != nil {
+			c.err = fmt.Errorf("rpc: param %d: %v", i, err)
+			return c.err
+		}
+	}
+	return nil
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	if reply == nil {
+		reply = &null
+	}
+	res := &serverResponse{
+		Result: reply,
+		Error:  &null,
+		Id:     c.request.Id,
+	}
+	c.writeServerResponse(w, http.StatusOK, res)
+}
+
+// WriteError encodes the error and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	res := &serverResponse{
+		Result: &null,
+		Id:     c.request.Id,
+	}
+	if jsonErr, ok := err.(*Error); ok {
+		res.Error = jsonErr.Data
+	} else {
+		res.Error = err.Error()
+	}
+	c.writeServerResponse(w, status, res)
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res *serverResponse) {
+	// Id is null for notifications and they don't have a response, unless
+	// the request couldn't be decoded.
+	if c.request.Id == nil && c.err == nil {
+		return
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// The encoder may set headers, so it is selected before writing them.
+	writer := c.encoder.Encode(w)
+	w.WriteHeader(status)
+	writer.Write(b)
+}
@@ -0,0 +1,45 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errResponseTooLarge is reported when a response exceeds the limit set
+// with Server.SetMaxResponseBytes.
+var errResponseTooLarge = errors.New("rpc: response too large")
+
+// limitResponseWriter cuts the response body once limit bytes have been
+// written, failing the writes past it.
+type limitResponseWriter struct {
+	http.ResponseWriter
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func (w *limitResponseWriter) Write(p []byte) (int, error) {
+	if w.exceeded {
+		return 0, errResponseTooLarge
+	}
+	if w.n+int64(len(p)) > w.limit {
+		w.exceeded = true
+		n, _ := w.ResponseWriter.Write(p[:w.limit-w.n])
+		w.n += int64(n)
+		return n, errResponseTooLarge
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (w *limitResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type BigReply struct {
+	Data string
+}
+
+type BigService struct {
+}
+
+func (t *BigService) Get(r *http.Request, req *Service1Request, res *BigReply) error {
+	res.Data = strings.Repeat("x", req.A)
+	return nil
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(BigService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var logs bytes.Buffer
+	s.SetLogger(log.New(&logs, "", 0))
+	s.SetErrorTrailer("X-RPC-Error")
+	s.SetMaxResponseBytes(100)
+	var afterErr error
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		afterErr = i.Error
+	})
+
+	call := func(size string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"BigService.Get","params":{"A":`+size+`}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call("10"); w.Code != 200 || afterErr != nil {
+		t.Errorf("Response was %d %s (%v), should be 200 under the limit.", w.Code, w.Body, afterErr)
+	}
+
+	// Responses too large are replaced by an error.
+	w := call("1000")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "response too large") {
+		t.Errorf("Response was %d %s, should be a 500 error.", w.Code, w.Body)
+	}
+	if !strings.Contains(logs.String(), "exceeds 100 bytes") {
+		t.Errorf("Log was %q, should report the limit.", logs.String())
+	}
+	if afterErr != errResponseTooLarge {
+		t.Errorf("After function error was %v, should be %v.", afterErr, errResponseTooLarge)
+	}
+
+	// So are the ones of cacheable methods.
+	if err := s.RegisterCacheable("BigService.Get"); err != nil {
+		t.Fatal(err)
+	}
+	w = call("1000")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "response too large") {
+		t.Errorf("Response was %d %s, should be a 500 error.", w.Code, w.Body)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag was %q, should be empty.", etag)
+	}
+}
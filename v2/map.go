@@ -6,11 +6,16 @@
 package rpc
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -33,9 +38,110 @@ type service struct {
 }
 
 type serviceMethod struct {
+	rcvr      reflect.Value  // receiver the method is bound to
 	method    reflect.Method // receiver method
 	argsType  reflect.Type   // type of the request argument
-	replyType reflect.Type   // type of the response argument
+	replyType reflect.Type   // type of the response argument, nil if none
+	pooled    atomic.Bool    // reuse args and reply values across calls
+	argsPool  sync.Pool      // pool of *args values when pooled
+	replyPool sync.Pool      // pool of *reply values when pooled
+	cacheable atomic.Bool    // responses get an ETag and may be 304
+	async     atomic.Bool    // successful responses are 202 Accepted
+	notify    atomic.Bool    // successful responses are 204 No Content
+	progress  bool           // takes a Progress argument
+	stream    bool           // reads the request body itself, see isStream
+	weight    atomic.Int32   // scheduling weight, zero means the default
+	timeout   atomic.Int64   // invocation timeout in nanoseconds, zero for none
+	fast      MethodFunc     // called instead of method when registered directly
+	// middleware wrapping the method invocation, outermost first, nil if
+	// none; replaced, not modified, when a middleware is added
+	middleware atomic.Pointer[[]func(next MethodFunc) MethodFunc]
+	// circuit breaker of the method, nil if none
+	breaker atomic.Pointer[circuitBreaker]
+	// default args of the method, nil if none
+	defaults atomic.Pointer[reflect.Value]
+	// adapters of the method by media type, nil if none; replaced, not
+	// modified, when an adapter is registered
+	adapters atomic.Pointer[map[string]*CodecAdapter]
+}
+
+// methodFunc returns the method bound to its receiver and wrapped in the
+// registered middleware.
+func (m *serviceMethod) methodFunc() MethodFunc {
+	var middleware []func(next MethodFunc) MethodFunc
+	if mw := m.middleware.Load(); mw != nil {
+		middleware = *mw
+	}
+	f := m.fast
+	if f != nil {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			f = middleware[i](f)
+		}
+		return f
+	}
+	f = func(r *http.Request, args, reply interface{}) error {
+		in := []reflect.Value{m.rcvr, reflect.ValueOf(r), reflect.ValueOf(args)}
+		if m.replyType != nil {
+			in = append(in, reflect.ValueOf(reply))
+		}
+		if m.progress {
+			in = append(in, reflect.ValueOf(progressFrom(r)))
+		}
+		if err := m.method.Func.Call(in)[0].Interface(); err != nil {
+			return err.(error)
+		}
+		return nil
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		f = middleware[i](f)
+	}
+	return f
+}
+
+// hasMiddleware reports whether middleware was added to the method.
+func (m *serviceMethod) hasMiddleware() bool {
+	return m.middleware.Load() != nil
+}
+
+// newArgs returns a pointer to a zero args value, taken from the pool when
+// pooling is enabled for the method.
+func (m *serviceMethod) newArgs() reflect.Value {
+	return m.newValue(&m.argsPool, m.argsType)
+}
+
+// newReply returns a pointer to a zero reply value, taken from the pool when
+// pooling is enabled for the method. It returns the zero Value if the method
+// has no reply argument.
+func (m *serviceMethod) newReply() reflect.Value {
+	if m.replyType == nil {
+		return reflect.Value{}
+	}
+	return m.newValue(&m.replyPool, m.replyType)
+}
+
+func (m *serviceMethod) newValue(pool *sync.Pool, t reflect.Type) reflect.Value {
+	if m.pooled.Load() {
+		if x := pool.Get(); x != nil {
+			v := reflect.ValueOf(x)
+			v.Elem().Set(reflect.Zero(t))
+			return v
+		}
+	}
+	return reflect.New(t)
+}
+
+// release hands args and reply back to the pools once the response has
+// been written. It is a no-op when pooling is disabled.
+func (m *serviceMethod) release(args, reply reflect.Value) {
+	if !m.pooled.Load() {
+		return
+	}
+	if args.IsValid() && !m.stream {
+		m.argsPool.Put(args.Interface())
+	}
+	if reply.IsValid() {
+		m.replyPool.Put(reply.Interface())
+	}
 }
 
 // ----------------------------------------------------------------------------
@@ -44,12 +150,35 @@ type serviceMethod struct {
 
 // serviceMap is a registry for services.
 type serviceMap struct {
-	mutex    sync.Mutex
+	mutex    sync.RWMutex
 	services map[string]*service
+	// namer returns the name a method is registered under; if nil, the
+	// method name is used as is.
+	namer func(service, method string) string
+	// aliases maps alias names to the methods they stand for.
+	aliases map[string]methodAlias
+}
+
+// methodAlias is a method registered under another name.
+type methodAlias struct {
+	target    string
+	transform func(json.RawMessage) (json.RawMessage, error)
 }
 
 // register adds a new service using reflection to extract its methods.
-func (m *serviceMap) register(rcvr interface{}, name string) error {
+//
+// If merge is true and a service with the same name is already registered,
+// the methods are added to it instead.
+func (m *serviceMap) register(rcvr interface{}, name string, merge bool) error {
+	_, err := m.registerService(rcvr, name, merge, ConflictError)
+	return err
+}
+
+// registerService adds a new service, merging it into the existing service
+// with the same name if merge is true or the conflict policy isn't
+// ConflictError. It returns the methods already defined that were skipped
+// or overwritten according to the policy, sorted.
+func (m *serviceMap) registerService(rcvr interface{}, name string, merge bool, conflict ConflictPolicy) ([]string, error) {
 	// Setup service.
 	s := &service{
 		name:     name,
@@ -60,93 +189,582 @@ func (m *serviceMap) register(rcvr interface{}, name string) error {
 	if name == "" {
 		s.name = reflect.Indirect(s.rcvr).Type().Name()
 		if !isExported(s.name) {
-			return fmt.Errorf("rpc: type %q is not exported", s.name)
+			return nil, fmt.Errorf("rpc: type %q is not exported", s.name)
 		}
 	}
 	if s.name == "" {
-		return fmt.Errorf("rpc: no service name for type %q",
+		return nil, fmt.Errorf("rpc: no service name for type %q",
 			s.rcvrType.String())
 	}
 	// Setup methods.
+	m.mutex.RLock()
+	namer := m.namer
+	m.mutex.RUnlock()
 	for i := 0; i < s.rcvrType.NumMethod(); i++ {
 		method := s.rcvrType.Method(i)
-		mtype := method.Type
-		// Method must be exported.
-		if method.PkgPath != "" {
-			continue
-		}
-		// Method needs four ins: receiver, *http.Request, *args, *reply.
-		if mtype.NumIn() != 4 {
-			continue
-		}
-		// First argument must be a pointer and must be http.Request.
-		reqType := mtype.In(1)
-		if reqType.Kind() != reflect.Ptr || reqType.Elem() != typeOfRequest {
-			continue
-		}
-		// Second argument must be a pointer and must be exported.
-		args := mtype.In(2)
-		if args.Kind() != reflect.Ptr || !isExportedOrBuiltin(args) {
-			continue
-		}
-		// Third argument must be a pointer and must be exported.
-		reply := mtype.In(3)
-		if reply.Kind() != reflect.Ptr || !isExportedOrBuiltin(reply) {
+		argsType, replyType, reason := checkMethod(method)
+		if reason != "" {
 			continue
 		}
-		// Method needs one out: error.
-		if mtype.NumOut() != 1 {
-			continue
-		}
-		if returnType := mtype.Out(0); returnType != typeOfError {
-			continue
+		name := method.Name
+		if namer != nil {
+			name = namer(s.name, method.Name)
 		}
-		s.methods[method.Name] = &serviceMethod{
+		s.methods[name] = &serviceMethod{
+			rcvr:      s.rcvr,
 			method:    method,
-			argsType:  args.Elem(),
-			replyType: reply.Elem(),
+			argsType:  argsType,
+			replyType: replyType,
+			progress:  method.Type.NumIn() == 5,
+			stream:    isStream(argsType),
 		}
 	}
 	if len(s.methods) == 0 {
-		return fmt.Errorf("rpc: %q has no exported methods of suitable type",
+		if s.rcvrType.Kind() != reflect.Ptr && hasSuitableMethods(reflect.PtrTo(s.rcvrType)) {
+			return nil, fmt.Errorf("rpc: %q has methods with pointer receivers, register a pointer to %s",
+				s.name, s.rcvrType.String())
+		}
+		return nil, fmt.Errorf("rpc: %q has no exported methods of suitable type",
 			s.name)
 	}
 	// Add to the map.
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	// Aliases are resolved first, they would shadow the method.
+	for name := range s.methods {
+		if _, ok := m.aliases[s.name+"."+name]; ok {
+			return nil, fmt.Errorf("rpc: alias already defined: %q", s.name+"."+name)
+		}
+	}
 	if m.services == nil {
 		m.services = make(map[string]*service)
-	} else if _, ok := m.services[s.name]; ok {
-		return fmt.Errorf("rpc: service already defined: %q", s.name)
+	} else if existing, ok := m.services[s.name]; ok {
+		if !merge && conflict == ConflictError {
+			return nil, fmt.Errorf("rpc: service already defined: %q", s.name)
+		}
+		var conflicts []string
+		for name := range s.methods {
+			if _, ok := existing.methods[name]; ok {
+				if conflict == ConflictError {
+					return nil, fmt.Errorf("rpc: service method already defined: %q", s.name+"."+name)
+				}
+				conflicts = append(conflicts, s.name+"."+name)
+			}
+		}
+		for name, method := range s.methods {
+			if _, ok := existing.methods[name]; ok && conflict == ConflictSkip {
+				continue
+			}
+			existing.methods[name] = method
+		}
+		sort.Strings(conflicts)
+		return conflicts, nil
 	}
 	m.services[s.name] = s
+	return nil, nil
+}
+
+// registerFast adds a method called through fn, without reflection, to the
+// service named by the dotted method name, registering the service if
+// needed.
+func (m *serviceMap) registerFast(method string, argsType, replyType reflect.Type, fn MethodFunc) error {
+	parts := strings.Split(method, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("rpc: service/method ill-formed: %q", method)
+	}
+	if fn == nil {
+		return fmt.Errorf("rpc: nil function for %q", method)
+	}
+	if argsType == nil || argsType.Kind() != reflect.Ptr {
+		return fmt.Errorf("rpc: args type %s is not a pointer", argsType)
+	}
+	sm := &serviceMethod{argsType: argsType.Elem(), stream: isStream(argsType.Elem()), fast: fn}
+	if replyType != nil {
+		if replyType.Kind() != reflect.Ptr {
+			return fmt.Errorf("rpc: reply type %s is not a pointer", replyType)
+		}
+		sm.replyType = replyType.Elem()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.aliases[method]; ok {
+		return fmt.Errorf("rpc: alias already defined: %q", method)
+	}
+	if m.services == nil {
+		m.services = make(map[string]*service)
+	}
+	s := m.services[parts[0]]
+	if s == nil {
+		s = &service{name: parts[0], methods: make(map[string]*serviceMethod)}
+		m.services[parts[0]] = s
+	} else if _, ok := s.methods[parts[1]]; ok {
+		return fmt.Errorf("rpc: service method already defined: %q", method)
+	}
+	s.methods[parts[1]] = sm
+	return nil
+}
+
+// registerFuncs adds the functions of m as methods named prefix + key,
+// called through reflection. Unsuitable functions are reported with their
+// key and the others are registered.
+func (m *serviceMap) registerFuncs(prefix string, funcs map[string]interface{}) error {
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := m.registerFunc(prefix+name, funcs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registerFunc adds fn, a function with the signature of a service method,
+// as the given method.
+func (m *serviceMap) registerFunc(method string, fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.IsNil() {
+		return fmt.Errorf("rpc: %T is not a function", fn)
+	}
+	ftype := fv.Type()
+	_, replyType, reason := checkFunc(ftype, 0)
+	if reason != "" {
+		return fmt.Errorf("rpc: unsuitable function: %s", reason)
+	}
+	// registerFast takes the pointer types.
+	argsType := ftype.In(1)
+	if replyType != nil {
+		replyType = ftype.In(2)
+	}
+	progress := ftype.NumIn() == 4
+	return m.registerFast(method, argsType, replyType, func(r *http.Request, args, reply interface{}) error {
+		in := []reflect.Value{reflect.ValueOf(r), reflect.ValueOf(args)}
+		if replyType != nil {
+			in = append(in, reflect.ValueOf(reply))
+		}
+		if progress {
+			in = append(in, reflect.ValueOf(progressFrom(r)))
+		}
+		err, _ := fv.Call(in)[0].Interface().(error)
+		return err
+	})
+}
+
+// checkMethod checks whether method has a signature suitable for a service
+// method. It returns the args and reply types, the latter being nil if the
+// method has no reply, or the reason why the method is not suitable.
+func checkMethod(method reflect.Method) (argsType, replyType reflect.Type, reason string) {
+	mtype := method.Type
+	// Method must be exported.
+	if method.PkgPath != "" {
+		return nil, nil, "method is not exported"
+	}
+	return checkFunc(mtype, 1)
+}
+
+// checkFunc checks whether the function type mtype, whose first skip
+// arguments are ignored, e.g. the receiver of a method, has a signature
+// suitable for a service method. It returns the same values as checkMethod.
+func checkFunc(mtype reflect.Type, skip int) (argsType, replyType reflect.Type, reason string) {
+	// Function needs three ins: *http.Request, *args, *reply; or two ins
+	// when it has no reply: *http.Request, *args; or four ins when it
+	// reports its progress: *http.Request, *args, *reply, Progress.
+	if n := mtype.NumIn() - skip; n < 2 || n > 4 {
+		return nil, nil, fmt.Sprintf("method has %d arguments, needs 2, 3 or 4", n)
+	}
+	if mtype.NumIn()-skip == 4 {
+		if progress := mtype.In(skip + 3); progress != typeOfProgress {
+			return nil, nil, fmt.Sprintf("fourth argument type %s is not rpc.Progress", progress)
+		}
+	}
+	// First argument must be a pointer and must be http.Request.
+	reqType := mtype.In(skip)
+	if reqType.Kind() != reflect.Ptr || reqType.Elem() != typeOfRequest {
+		return nil, nil, fmt.Sprintf("first argument type %s is not *http.Request", reqType)
+	}
+	// Second argument must be a pointer and must be exported.
+	args := mtype.In(skip + 1)
+	if args.Kind() != reflect.Ptr {
+		return nil, nil, fmt.Sprintf("args type %s is not a pointer", args)
+	}
+	if !isExportedOrBuiltin(args) {
+		return nil, nil, fmt.Sprintf("args type %s is not exported", args)
+	}
+	// Third argument, if any, must be a pointer and must be exported.
+	if mtype.NumIn()-skip >= 3 {
+		reply := mtype.In(skip + 2)
+		if reply.Kind() != reflect.Ptr {
+			return nil, nil, fmt.Sprintf("reply type %s is not a pointer", reply)
+		}
+		if !isExportedOrBuiltin(reply) {
+			return nil, nil, fmt.Sprintf("reply type %s is not exported", reply)
+		}
+		replyType = reply.Elem()
+	}
+	// Method needs one out: error.
+	if mtype.NumOut() != 1 {
+		return nil, nil, fmt.Sprintf("method has %d return values, needs 1", mtype.NumOut())
+	}
+	if returnType := mtype.Out(0); returnType != typeOfError {
+		return nil, nil, fmt.Sprintf("return type %s is not error", returnType)
+	}
+	return args.Elem(), replyType, ""
+}
+
+// hasSuitableMethods returns true if t has at least one method with a
+// signature suitable for a service method.
+func hasSuitableMethods(t reflect.Type) bool {
+	for i := 0; i < t.NumMethod(); i++ {
+		if _, _, reason := checkMethod(t.Method(i)); reason == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHandlers returns an error naming the methods of rcvr that look like
+// handlers, taking an http.Request argument, but are not suitable, including
+// methods with pointer receivers when rcvr is not a pointer.
+func checkHandlers(rcvr interface{}) error {
+	t := reflect.TypeOf(rcvr)
+	if t == nil {
+		return fmt.Errorf("rpc: no receiver")
+	}
+	var problems []string
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if _, _, reason := checkMethod(method); reason != "" && looksLikeHandler(method) {
+			problems = append(problems, method.Name+": "+reason)
+		}
+	}
+	if t.Kind() != reflect.Ptr {
+		pt := reflect.PtrTo(t)
+		for i := 0; i < pt.NumMethod(); i++ {
+			method := pt.Method(i)
+			if _, ok := t.MethodByName(method.Name); !ok && looksLikeHandler(method) {
+				problems = append(problems, method.Name+": method has a pointer receiver, register a pointer")
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("rpc: %s has unsuitable methods: %s", t, strings.Join(problems, "; "))
+	}
 	return nil
 }
 
+// looksLikeHandler returns true if method takes an http.Request argument,
+// as a pointer or not.
+func looksLikeHandler(method reflect.Method) bool {
+	for i := 1; i < method.Type.NumIn(); i++ {
+		in := method.Type.In(i)
+		if in == typeOfRequest || in.Kind() == reflect.Ptr && in.Elem() == typeOfRequest {
+			return true
+		}
+	}
+	return false
+}
+
 // get returns a registered service given a method name.
 //
 // The method name uses a dotted notation as in "Service.Method".
 func (m *serviceMap) get(method string) (*service, *serviceMethod, error) {
-	parts := strings.Split(method, ".")
-	if len(parts) != 2 {
-		err := fmt.Errorf("rpc: service/method request ill-formed: %q", method)
+	// The service name holds the namespaces of mounted services, if any.
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		err := &methodNotFoundError{fmt.Sprintf("rpc: service/method request ill-formed: %q", method)}
 		return nil, nil, err
 	}
-	m.mutex.Lock()
+	parts := [2]string{method[:dot], method[dot+1:]}
+	// Hold the lock for the whole lookup so the service and its methods
+	// are read consistently with concurrent registrations.
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	service := m.services[parts[0]]
-	m.mutex.Unlock()
 	if service == nil {
-		err := fmt.Errorf("rpc: can't find service %q", method)
+		err := &methodNotFoundError{fmt.Sprintf("rpc: can't find service %q", method)}
 		return nil, nil, err
 	}
 	serviceMethod := service.methods[parts[1]]
 	if serviceMethod == nil {
-		err := fmt.Errorf("rpc: can't find method %q", method)
+		err := &methodNotFoundError{fmt.Sprintf("rpc: can't find method %q", method)}
 		return nil, nil, err
 	}
 	return service, serviceMethod, nil
 }
 
+// getFold returns the registered method matching method regardless of
+// case, preferring an exact match. It fails if several methods match.
+func (m *serviceMap) getFold(method string) (string, bool) {
+	if _, _, err := m.get(method); err == nil {
+		return method, true
+	}
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		return "", false
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	found := ""
+	for serviceName, service := range m.services {
+		if !strings.EqualFold(serviceName, method[:dot]) {
+			continue
+		}
+		for methodName := range service.methods {
+			if strings.EqualFold(methodName, method[dot+1:]) {
+				if found != "" {
+					return "", false
+				}
+				found = serviceName + "." + methodName
+			}
+		}
+	}
+	return found, found != ""
+}
+
+// mount adds the services and aliases of sub under namespace, as in
+// "namespace.Service". The methods are shared, with their settings, but the
+// services registered on sub afterwards aren't added.
+func (m *serviceMap) mount(namespace string, sub *serviceMap) error {
+	sub.mutex.RLock()
+	services := make(map[string]*service, len(sub.services))
+	for name, s := range sub.services {
+		methods := make(map[string]*serviceMethod, len(s.methods))
+		for name, method := range s.methods {
+			methods[name] = method
+		}
+		name = namespace + "." + name
+		services[name] = &service{name: name, rcvr: s.rcvr, rcvrType: s.rcvrType, methods: methods}
+	}
+	aliases := make(map[string]methodAlias, len(sub.aliases))
+	for name, a := range sub.aliases {
+		aliases[namespace+"."+name] = methodAlias{target: namespace + "." + a.target, transform: a.transform}
+	}
+	sub.mutex.RUnlock()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var conflicts []string
+	for name := range services {
+		if _, ok := m.services[name]; ok {
+			conflicts = append(conflicts, name)
+		}
+	}
+	for name := range aliases {
+		if _, ok := m.aliases[name]; ok {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("rpc: service already defined: %q", strings.Join(conflicts, `", "`))
+	}
+	if m.services == nil {
+		m.services = make(map[string]*service)
+	}
+	for name, s := range services {
+		m.services[name] = s
+	}
+	if len(aliases) > 0 && m.aliases == nil {
+		m.aliases = make(map[string]methodAlias)
+	}
+	for name, a := range aliases {
+		m.aliases[name] = a
+	}
+	return nil
+}
+
+// registerAlias makes alias stand for the target method, with the params
+// adapted by transform, if not nil.
+func (m *serviceMap) registerAlias(alias, target string, transform func(json.RawMessage) (json.RawMessage, error)) error {
+	parts := strings.Split(alias, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("rpc: service/method ill-formed: %q", alias)
+	}
+	if _, _, err := m.get(target); err != nil {
+		return err
+	}
+	if _, _, err := m.get(alias); err == nil {
+		return fmt.Errorf("rpc: service method already defined: %q", alias)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.aliases[alias]; ok {
+		return fmt.Errorf("rpc: alias already defined: %q", alias)
+	}
+	if m.aliases == nil {
+		m.aliases = make(map[string]methodAlias)
+	}
+	m.aliases[alias] = methodAlias{target: target, transform: transform}
+	return nil
+}
+
+// alias returns the method the given alias stands for.
+func (m *serviceMap) alias(name string) (methodAlias, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	a, ok := m.aliases[name]
+	return a, ok
+}
+
+// validate reports the aliases whose target isn't registered and the ones
+// shadowing a method, sorted by alias.
+func (m *serviceMap) validate() error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	names := make([]string, 0, len(m.aliases))
+	for name := range m.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if target := m.aliases[name].target; !m.registered(target) {
+			errs = append(errs, fmt.Errorf("rpc: alias %q stands for a missing method %q", name, target))
+		}
+		if m.registered(name) {
+			errs = append(errs, fmt.Errorf("rpc: alias %q shadows a method", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registered reports whether method is registered. The caller must hold
+// the mutex.
+func (m *serviceMap) registered(method string) bool {
+	dot := strings.LastIndex(method, ".")
+	if dot < 0 {
+		return false
+	}
+	s := m.services[method[:dot]]
+	return s != nil && s.methods[method[dot+1:]] != nil
+}
+
+// setPooled enables or disables pooling of args and reply values for the
+// given method.
+func (m *serviceMap) setPooled(method string, pooled bool) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.pooled.Store(pooled)
+	return nil
+}
+
+// setCacheable enables ETag handling for the given method.
+func (m *serviceMap) setCacheable(method string) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.cacheable.Store(true)
+	return nil
+}
+
+// setAsync makes successful responses of the given method 202 Accepted.
+func (m *serviceMap) setAsync(method string) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.async.Store(true)
+	return nil
+}
+
+// setNotification makes successful responses of the given method 204 No
+// Content, without a body.
+func (m *serviceMap) setNotification(method string) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.notify.Store(true)
+	return nil
+}
+
+// setWeight sets the scheduling weight of the given method.
+func (m *serviceMap) setWeight(method string, weight int) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.weight.Store(int32(weight))
+	return nil
+}
+
+// setTimeout sets the invocation timeout of the given method.
+func (m *serviceMap) setTimeout(method string, d time.Duration) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.timeout.Store(int64(d))
+	return nil
+}
+
+// setBreaker sets the circuit breaker of the given method.
+func (m *serviceMap) setBreaker(method string, b *circuitBreaker) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.breaker.Store(b)
+	return nil
+}
+
+// setDefaults sets the default args of the given method.
+func (m *serviceMap) setDefaults(method string, defaults *reflect.Value) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	serviceMethod.defaults.Store(defaults)
+	return nil
+}
+
+// setAdapter sets the adapter of the given method for the media type,
+// removing it if adapter is nil.
+func (m *serviceMap) setAdapter(method, mediaType string, adapter *CodecAdapter) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	adapters := make(map[string]*CodecAdapter)
+	if old := serviceMethod.adapters.Load(); old != nil {
+		for k, v := range *old {
+			adapters[k] = v
+		}
+	}
+	if adapter == nil {
+		delete(adapters, mediaType)
+	} else {
+		adapters[mediaType] = adapter
+	}
+	serviceMethod.adapters.Store(&adapters)
+	return nil
+}
+
+// use appends mw to the middleware of the given method.
+func (m *serviceMap) use(method string, mw func(next MethodFunc) MethodFunc) error {
+	_, serviceMethod, err := m.get(method)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var middleware []func(next MethodFunc) MethodFunc
+	if old := serviceMethod.middleware.Load(); old != nil {
+		middleware = append(middleware, *old...)
+	}
+	middleware = append(middleware, mw)
+	serviceMethod.middleware.Store(&middleware)
+	return nil
+}
+
 // isExported returns true of a string is an exported (upper case) name.
 func isExported(name string) bool {
 	rune, _ := utf8.DecodeRuneInString(name)
@@ -0,0 +1,131 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Metrics is the interface used by the server to report measurements of
+// the requests it serves, e.g. to export them to a monitoring system.
+// The method is empty for the calls of a method that isn't registered,
+// as its name is set by the client.
+type Metrics interface {
+	// ObserveRequestSize reports the size in bytes of the request body of a
+	// call to the method.
+	ObserveRequestSize(method string, size int64)
+	// ObserveResponseSize reports the size in bytes of the response body
+	// written for a call to the method, after compression if any.
+	ObserveResponseSize(method string, size int64)
+}
+
+// CallMetrics is an optional interface implemented by the Metrics that also
+// measure the calls. ObserveCall reports the status code chosen by the
+// server for a call of the method, the error written, if any, and the time
+// taken from the method lookup to the end of the response. The status is
+// the one given to the codec, which may send a different HTTP status, e.g.
+// 200 for JSON-RPC errors.
+type CallMetrics interface {
+	ObserveCall(method string, status int, err error, duration time.Duration)
+}
+
+// CodecSelection tells how the codec of a request was chosen.
+type CodecSelection string
+
+const (
+	// CodecByContentType is a codec selected by the "Content-Type" header,
+	// or by the header set with SetCodecHeader.
+	CodecByContentType CodecSelection = "content_type"
+	// CodecDefaulted is the only registered codec, used for a request
+	// without "Content-Type".
+	CodecDefaulted CodecSelection = "default"
+	// CodecFallback is a codec of the chain set with
+	// SetCodecFallbackChain, used for an unrecognized "Content-Type".
+	CodecFallback CodecSelection = "fallback"
+	// CodecNegotiationFailed is a request rejected because no codec
+	// matched, usually with 415 Unsupported Media Type.
+	CodecNegotiationFailed CodecSelection = "failed"
+)
+
+// CodecMetrics is an optional interface implemented by the Metrics that also
+// count how the codecs are chosen, e.g. to spot clients sending a wrong or
+// missing "Content-Type". ObserveCodec reports the media type requested by
+// the client, empty if none, and how the codec was chosen. The media type
+// is set by the client, so implementations should not use it as is for
+// CodecNegotiationFailed, whose values are unbounded.
+type CodecMetrics interface {
+	ObserveCodec(contentType string, selection CodecSelection)
+}
+
+// observeCodec reports the codec selection to the metrics, if they
+// implement CodecMetrics.
+func (s *Server) observeCodec(contentType string, selection CodecSelection) {
+	if m, ok := s.metrics.(CodecMetrics); ok {
+		m.ObserveCodec(contentType, selection)
+	}
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// count returns the bytes read, zero if r is nil as for requests without
+// a body.
+func (r *countingReader) count() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.n
+}
+
+// countingResponseWriter counts the bytes written to the response body and
+// records the status.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n      int64
+	status int
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// statusCode returns the status written, http.StatusOK if none was.
+func (w *countingResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
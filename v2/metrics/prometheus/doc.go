@@ -0,0 +1,36 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/metrics/prometheus provides an implementation of the
+metrics of a RPC server with Prometheus collectors.
+
+To export the metrics of a server:
+
+	import (
+		"net/http"
+
+		"github.com/gorilla/rpc/v2"
+		rpcprometheus "github.com/gorilla/rpc/v2/metrics/prometheus"
+		"github.com/prometheus/client_golang/prometheus"
+		"github.com/prometheus/client_golang/prometheus/promhttp"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		m, err := rpcprometheus.New(prometheus.DefaultRegisterer, rpcprometheus.Options{})
+		if err != nil {
+			panic(err)
+		}
+		s.SetMetrics(m)
+		// [...]
+		http.Handle("/rpc", s)
+		http.Handle("/metrics", promhttp.Handler())
+	}
+
+The calls are counted and timed by method and status code, the status
+chosen by the server for the call. The package is a separate module, so
+the Prometheus dependency is only required by the servers using it.
+*/
+package prometheus
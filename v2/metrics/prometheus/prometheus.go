@@ -0,0 +1,145 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gorilla/rpc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures the collectors created by New.
+type Options struct {
+	// Namespace and Subsystem prefix the metric names, as in
+	// "namespace_subsystem_rpc_requests_total".
+	Namespace string
+	Subsystem string
+	// Buckets are the upper bounds, in seconds, of the buckets of the
+	// latency histogram. If nil, prometheus.DefBuckets is used.
+	Buckets []float64
+}
+
+// Metrics reports the measurements of a server to Prometheus collectors.
+// It implements rpc.Metrics, rpc.CallMetrics and rpc.CodecMetrics.
+type Metrics struct {
+	requests      *prometheus.CounterVec
+	codecs        *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+}
+
+var (
+	_ rpc.Metrics      = (*Metrics)(nil)
+	_ rpc.CallMetrics  = (*Metrics)(nil)
+	_ rpc.CodecMetrics = (*Metrics)(nil)
+)
+
+// New returns Metrics with its collectors registered with reg:
+//
+//   - rpc_requests_total, the calls by method and code.
+//   - rpc_errors_total, the failed calls by method and code.
+//   - rpc_request_duration_seconds, the latency histogram of the calls by
+//     method and code.
+//   - rpc_request_bytes_total and rpc_response_bytes_total, the size of the
+//     request and response bodies by method.
+//   - rpc_codec_selections_total, the requests by how their codec was
+//     chosen, e.g. "default" for those without Content-Type.
+//
+// The calls to methods that aren't registered are labeled "unknown".
+func New(reg prometheus.Registerer, opts Options) (*Metrics, error) {
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	labels := []string{"method", "code"}
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_requests_total",
+			Help:      "Number of RPC calls by method and status code.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_errors_total",
+			Help:      "Number of failed RPC calls by method and status code.",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_request_duration_seconds",
+			Help:      "Latency of the RPC calls by method and status code.",
+			Buckets:   buckets,
+		}, labels),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_request_bytes_total",
+			Help:      "Size of the RPC request bodies by method.",
+		}, []string{"method"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_response_bytes_total",
+			Help:      "Size of the RPC response bodies by method.",
+		}, []string{"method"}),
+		codecs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "rpc_codec_selections_total",
+			Help:      "Number of RPC requests by how their codec was chosen.",
+		}, []string{"selection"}),
+	}
+	for _, c := range []prometheus.Collector{m.requests, m.errors, m.latency, m.requestBytes, m.responseBytes, m.codecs} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// unknownMethod is the label of the calls to methods that aren't
+// registered, which the server reports with an empty method.
+const unknownMethod = "unknown"
+
+// methodLabel returns the label value of method.
+func methodLabel(method string) string {
+	if method == "" {
+		return unknownMethod
+	}
+	return method
+}
+
+// ObserveRequestSize implements rpc.Metrics.
+func (m *Metrics) ObserveRequestSize(method string, size int64) {
+	m.requestBytes.WithLabelValues(methodLabel(method)).Add(float64(size))
+}
+
+// ObserveResponseSize implements rpc.Metrics.
+func (m *Metrics) ObserveResponseSize(method string, size int64) {
+	m.responseBytes.WithLabelValues(methodLabel(method)).Add(float64(size))
+}
+
+// ObserveCall implements rpc.CallMetrics.
+func (m *Metrics) ObserveCall(method string, status int, err error, duration time.Duration) {
+	method = methodLabel(method)
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(method, code).Inc()
+	if err != nil {
+		m.errors.WithLabelValues(method, code).Inc()
+	}
+	m.latency.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+// ObserveCodec implements rpc.CodecMetrics. The content type isn't used as a
+// label, since it's set by the clients.
+func (m *Metrics) ObserveCodec(contentType string, selection rpc.CodecSelection) {
+	m.codecs.WithLabelValues(string(selection)).Inc()
+}
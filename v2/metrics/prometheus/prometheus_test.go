@@ -0,0 +1,60 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/rpc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg, Options{Namespace: "test", Buckets: []float64{0.1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(reg, Options{Namespace: "test"}); err == nil {
+		t.Error("Expected an error registering the collectors twice")
+	}
+
+	m.ObserveCall("Service1.Multiply", 200, nil, 50*time.Millisecond)
+	m.ObserveCall("Service1.Multiply", 200, nil, 2*time.Second)
+	m.ObserveCall("Service1.Multiply", 400, errors.New("bad args"), time.Millisecond)
+	m.ObserveRequestSize("Service1.Multiply", 100)
+	m.ObserveResponseSize("Service1.Multiply", 20)
+	m.ObserveCall("", 400, errors.New("no method"), time.Millisecond)
+	m.ObserveRequestSize("", 30)
+	m.ObserveCodec("", rpc.CodecDefaulted)
+	m.ObserveCodec("text/plain", rpc.CodecNegotiationFailed)
+	m.ObserveCodec("text/xml", rpc.CodecNegotiationFailed)
+
+	tests := []struct {
+		c        prometheus.Collector
+		expected float64
+	}{
+		{m.requests.WithLabelValues("Service1.Multiply", "200"), 2},
+		{m.requests.WithLabelValues("Service1.Multiply", "400"), 1},
+		{m.errors.WithLabelValues("Service1.Multiply", "400"), 1},
+		{m.requestBytes.WithLabelValues("Service1.Multiply"), 100},
+		{m.responseBytes.WithLabelValues("Service1.Multiply"), 20},
+		{m.errors.WithLabelValues("unknown", "400"), 1},
+		{m.requestBytes.WithLabelValues("unknown"), 30},
+		{m.codecs.WithLabelValues("default"), 1},
+		{m.codecs.WithLabelValues("failed"), 2},
+	}
+	for i, tt := range tests {
+		if v := testutil.ToFloat64(tt.c); v != tt.expected {
+			t.Errorf("%d: Value was %v, should be %v", i, v, tt.expected)
+		}
+	}
+	if n := testutil.CollectAndCount(m.latency, "test_rpc_request_duration_seconds"); n != 3 {
+		t.Errorf("Latency series were %d, should be 3", n)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/ndjson provides newline-delimited JSON streaming on top
+of a RPC server, for bulk calls.
+
+Each line of the request body is a separate RPC call in the format of the
+codec registered in the server for the inner content type. The calls are
+processed sequentially, and the response of each one is streamed as a line
+of the response body as soon as it completes:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/json2"
+		"github.com/gorilla/rpc/v2/ndjson"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(json2.NewCodec(), "application/json")
+		// [...]
+		http.Handle("/rpc", s)
+		http.Handle("/rpc/bulk", ndjson.NewHandler(s, "application/json"))
+	}
+
+Every call goes through the regular dispatch of the server, so an error in
+one line is reported in its own response line and doesn't abort the rest.
+Calls that don't produce a response, like JSON-RPC notifications, don't
+produce a line either. Responses that are not JSON, like the plain text
+errors written by the server, are wrapped as {"error": "message"}.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package ndjson
@@ -0,0 +1,112 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+// ContentType is the Content-Type of the responses written by the handler.
+const ContentType = "application/x-ndjson"
+
+// NewHandler returns a handler dispatching each line of the request body
+// through the server, as a call with the given content type.
+func NewHandler(s *rpc.Server, contentType string) http.Handler {
+	return &handler{server: s, contentType: contentType}
+}
+
+type handler struct {
+	server      *rpc.Server
+	contentType string
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		rpc.WriteError(w, http.StatusMethodNotAllowed, "rpc: POST method required, received "+r.Method)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if out := h.call(r, line); out != nil {
+				if _, errWrite := w.Write(append(out, '\n')); errWrite != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				out, _ := json.Marshal(map[string]string{"error": err.Error()})
+				w.Write(append(out, '\n'))
+			}
+			return
+		}
+	}
+}
+
+// call dispatches a single line through the server and returns its
+// response as a single line, or nil if there is no response.
+func (h *handler) call(r *http.Request, line []byte) []byte {
+	req := r.Clone(r.Context())
+	req.Body = io.NopCloser(bytes.NewReader(line))
+	req.ContentLength = int64(len(line))
+	req.Header.Set("Content-Type", h.contentType)
+	req.Header.Del("Content-Encoding")
+	// The lines are written uncompressed, whatever the codec selects.
+	req.Header.Del("Accept-Encoding")
+
+	rw := newLineWriter()
+	h.server.ServeHTTP(rw, req)
+
+	body := bytes.TrimSpace(rw.body.Bytes())
+	if len(body) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if json.Valid(body) {
+		// Make sure the response fits in a single line.
+		json.Compact(&buf, body)
+		return buf.Bytes()
+	}
+	out, _ := json.Marshal(map[string]string{"error": string(body)})
+	return out
+}
+
+// lineWriter captures the response of a single call.
+type lineWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newLineWriter() *lineWriter {
+	return &lineWriter{header: make(http.Header)}
+}
+
+func (w *lineWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+func (w *lineWriter) WriteHeader(int) {
+}
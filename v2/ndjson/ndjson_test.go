@@ -0,0 +1,99 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ndjson
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+	"github.com/gorilla/rpc/v2/json2"
+)
+
+type Service1Request struct {
+	A int
+	B int
+}
+
+type Service1Response struct {
+	Result int
+}
+
+type Service1 struct {
+}
+
+func (t *Service1) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	if req.B == 0 {
+		return errors.New("B must not be zero")
+	}
+	res.Result = req.A * req.B
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(json2.NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, "application/json")
+
+	body := strings.Join([]string{
+		`{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 2, "B": 3}, "id": 1}`,
+		``,
+		`{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 2, "B": 0}, "id": 2}`,
+		`{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`,
+		`{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 4, "B": 5}, "id": 3}`,
+	}, "\n")
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(body))
+	r.Header.Set("Content-Type", ContentType)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type was %q, should be %q", ct, ContentType)
+	}
+	if !w.Flushed {
+		t.Error("Expected the response to be flushed")
+	}
+	expected := []string{
+		`{"jsonrpc":"2.0","result":{"Result":6},"id":1}`,
+		`{"jsonrpc":"2.0","error":{"code":-32603,"message":"B must not be zero","data":null},"id":2}`,
+		`{"jsonrpc":"2.0","result":{"Result":20},"id":3}`,
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Response lines were %q, should be %q", lines, expected)
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("Response line %d was %s, should be %s", i, line, expected[i])
+		}
+	}
+}
+
+func TestHandlerAcceptEncoding(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(json2.NewCustomCodec(&rpc.CompressionSelector{}), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(s, "application/json")
+
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", strings.NewReader(`{"jsonrpc": "2.0", "method": "Service1.Multiply", "params": {"A": 2, "B": 3}, "id": 1}`))
+	r.Header.Set("Content-Type", ContentType)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	const expected = `{"jsonrpc":"2.0","result":{"Result":6},"id":1}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("Response was %q, should be %q", w.Body, expected)
+	}
+}
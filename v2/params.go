@@ -0,0 +1,108 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ParamInfo describes a field of the args of a method, see
+// Server.MethodParams.
+type ParamInfo struct {
+	// Name is the JSON name of the field, from its "json" tag or its name.
+	Name string
+	// Type is the Go type of the field, e.g. "int" or "[]string".
+	Type string
+	// OmitEmpty reports whether the field has the "omitempty" option.
+	OmitEmpty bool
+}
+
+// MethodParams returns the fields of the args of the given method, as
+// encoded by encoding/json, e.g. to build a form calling the method. Fields
+// without a JSON name are skipped, like the unexported ones and the ones
+// tagged "-". The fields of embedded structs, and pointers to structs, are
+// promoted unless the embedded field is named by its tag; the fields of the
+// outer struct take precedence over promoted ones with the same name.
+//
+// It's an error if the args aren't a struct, e.g. a string.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) MethodParams(method string) ([]ParamInfo, error) {
+	_, methodSpec, err := s.services.get(method)
+	if err != nil {
+		return nil, err
+	}
+	t := methodSpec.argsType
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rpc: args of %q are a %s, not a struct", method, t)
+	}
+	params := []ParamInfo{}
+	seen := make(map[string]bool)
+	structParams(t, &params, seen, map[reflect.Type]bool{t: true})
+	return params, nil
+}
+
+// structParams appends the fields of the struct type t to params, followed
+// by the fields promoted from its embedded structs, skipping the names in
+// seen. visiting holds the embedded types being expanded, to stop cycles.
+func structParams(t reflect.Type, params *[]ParamInfo, seen map[string]bool, visiting map[reflect.Type]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		*params = append(*params, ParamInfo{
+			Name:      name,
+			Type:      field.Type.String(),
+			OmitEmpty: hasOption(opts, "omitempty"),
+		})
+	}
+	for _, et := range embedded {
+		if visiting[et] {
+			continue
+		}
+		visiting[et] = true
+		structParams(et, params, seen, visiting)
+		delete(visiting, et)
+	}
+}
+
+// hasOption reports whether the comma-separated tag options include opt.
+func hasOption(opts, opt string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type Audit struct {
+	Reason string `json:"reason,omitempty"`
+	Note   string `json:"note"`
+}
+
+type Paging struct {
+	Limit int `json:"limit"`
+}
+
+type SearchArgs struct {
+	Query   string            `json:"query"`
+	Tags    []string          `json:"tags,omitempty"`
+	Filters map[string]string `json:",omitempty"`
+	Note    *string           `json:"note,omitempty"`
+	Secret  string            `json:"-"`
+	private int
+	Audit
+	*Paging
+	Owner Audit `json:"owner"`
+}
+
+type SearchService struct {
+}
+
+func (t *SearchService) Search(r *http.Request, args *SearchArgs, reply *Service1Response) error {
+	return nil
+}
+
+func (t *SearchService) Echo(r *http.Request, args *string, reply *string) error {
+	return nil
+}
+
+func TestMethodParams(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(SearchService), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := s.MethodParams("SearchService.Search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ParamInfo{
+		{Name: "query", Type: "string"},
+		{Name: "tags", Type: "[]string", OmitEmpty: true},
+		{Name: "Filters", Type: "map[string]string", OmitEmpty: true},
+		{Name: "note", Type: "*string", OmitEmpty: true},
+		{Name: "owner", Type: "rpc.Audit"},
+		{Name: "reason", Type: "string", OmitEmpty: true},
+		{Name: "limit", Type: "int"},
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Params were %+v, should be %+v.", params, expected)
+	}
+
+	if _, err := s.MethodParams("SearchService.Echo"); err == nil {
+		t.Error("Expected an error for args that aren't a struct")
+	}
+	if _, err := s.MethodParams("SearchService.Missing"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Progress reports the progress of a long running method, e.g. the number
+// of records imported so far. Methods receive it as a fourth argument:
+//
+//	func (t *T) Import(r *http.Request, args *Args, reply *Reply, progress rpc.Progress) error
+//
+// If the request has a "X-RPC-Stream: ndjson" header, the response is
+// streamed as newline-delimited JSON: a {"progress": update} line for each
+// update, flushed as it's reported, and a final {"result": reply} or
+// {"error": "message"} line. Otherwise the updates are discarded and the
+// reply is written by the codec as usual.
+//
+// Updates are encoded with encoding/json. Progress returns an error if the
+// update can't be written, e.g. because the client is gone, or once the
+// method has returned.
+type Progress func(update interface{}) error
+
+// StreamHeader is the request header asking to stream the progress of the
+// method, with the value "ndjson".
+const StreamHeader = "X-RPC-Stream"
+
+var typeOfProgress = reflect.TypeOf((*Progress)(nil)).Elem()
+
+// errProgressDone is returned by Progress once the method has returned.
+var errProgressDone = errors.New("rpc: progress reported after the method returned")
+
+type progressKey struct{}
+
+// noProgress discards the updates of requests not streaming them.
+func noProgress(interface{}) error {
+	return nil
+}
+
+// progressFrom returns the Progress of the request, as set by the server.
+func progressFrom(r *http.Request) Progress {
+	if p, ok := r.Context().Value(progressKey{}).(Progress); ok {
+		return p
+	}
+	return noProgress
+}
+
+// wantsProgress reports whether r asks to stream the progress.
+func wantsProgress(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(StreamHeader), "ndjson")
+}
+
+// progressWriter streams the progress updates and the outcome of a call as
+// newline-delimited JSON.
+type progressWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	started bool
+	done    bool
+}
+
+// writeLine writes v as a line, starting the response with status if
+// needed, and flushes it.
+func (p *progressWriter) writeLine(status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !p.started {
+		p.started = true
+		p.w.Header().Set("Content-Type", "application/x-ndjson")
+		p.w.WriteHeader(status)
+	}
+	if _, err := p.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if f, ok := p.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// progress is the Progress passed to the method.
+func (p *progressWriter) progress(update interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done {
+		return errProgressDone
+	}
+	return p.writeLine(http.StatusOK, struct {
+		Progress interface{} `json:"progress"`
+	}{update})
+}
+
+// close makes the next updates fail, once the method has returned.
+func (p *progressWriter) close() {
+	p.mu.Lock()
+	p.done = true
+	p.mu.Unlock()
+}
+
+// finish writes the outcome of the call as the last line.
+func (p *progressWriter) finish(status int, reply interface{}, err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	if err != nil {
+		return p.writeLine(status, struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	}
+	return p.writeLine(status, struct {
+		Result interface{} `json:"result"`
+	}{reply})
+}
@@ -0,0 +1,103 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ImportArgs struct {
+	N    int
+	Fail bool
+}
+
+type ImportReply struct {
+	Imported int
+}
+
+type ImportService struct {
+}
+
+func (t *ImportService) Import(r *http.Request, args *ImportArgs, reply *ImportReply, progress Progress) error {
+	for i := 1; i <= args.N; i++ {
+		if err := progress(map[string]int{"done": i}); err != nil {
+			return err
+		}
+		reply.Imported = i
+	}
+	if args.Fail {
+		return errors.New("import failed")
+	}
+	return nil
+}
+
+func (t *ImportService) Invalid(r *http.Request, args *ImportArgs, reply *ImportReply, progress func(interface{}) error) error {
+	return nil
+}
+
+func TestProgress(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(ImportService), ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.HasMethod("ImportService.Invalid") {
+		t.Error("Expected a fourth argument not of type Progress to be rejected")
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	call := func(params string, stream bool) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"ImportService.Import","params":`+params+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		if stream {
+			r.Header.Set(StreamHeader, "ndjson")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		params      string
+		stream      bool
+		status      int
+		contentType string
+		body        string
+	}{
+		{`{"N":3}`, false, 200, "application/json; charset=utf-8", `{"Imported":3}`},
+		{`{"N":3}`, true, 200, "application/x-ndjson", `{"progress":{"done":1}}` + "\n" + `{"progress":{"done":2}}` + "\n" + `{"progress":{"done":3}}` + "\n" + `{"result":{"Imported":3}}` + "\n"},
+		{`{"N":1,"Fail":true}`, true, 200, "application/x-ndjson", `{"progress":{"done":1}}` + "\n" + `{"error":"import failed"}` + "\n"},
+		{`{"N":0,"Fail":true}`, true, 400, "application/x-ndjson", `{"error":"import failed"}` + "\n"},
+	}
+	for _, tt := range tests {
+		w := call(tt.params, tt.stream)
+		if w.Code != tt.status || w.Header().Get("Content-Type") != tt.contentType || w.Body.String() != tt.body {
+			t.Errorf("%s, stream %v: response was %d %q %q, should be %d %q %q.", tt.params, tt.stream, w.Code, w.Header().Get("Content-Type"), w.Body, tt.status, tt.contentType, tt.body)
+		}
+		if tt.stream && !w.Flushed {
+			t.Errorf("%s: expected the progress to be flushed", tt.params)
+		}
+	}
+
+	// Middleware see the calls as usual.
+	var calls int
+	if err := s.Use("ImportService.Import", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			calls++
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if w := call(`{"N":2}`, true); calls != 1 || !strings.HasSuffix(w.Body.String(), `{"result":{"Imported":2}}`+"\n") {
+		t.Errorf("Response body was %q after %d middleware calls, should stream the progress.", w.Body, calls)
+	}
+}
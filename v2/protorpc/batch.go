@@ -0,0 +1,226 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protorpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+// batchCall is a single call of a batch request.
+type batchCall struct {
+	// The method to invoke, as in "Service.Method".
+	Method string `json:"method"`
+	// The request object passed to the method.
+	Params json.RawMessage `json:"params"`
+}
+
+// NewBatchHandler returns a handler for batch requests, whose body is an
+// array of {"method": "Service.Method", "params": {...}} objects. Each call
+// is dispatched through the server as a ProtoRPC request with the given
+// content type, which must be registered with this codec, and the response
+// is the array of the response bodies in the same order.
+//
+// Calls are isolated: an error in one of them is reported as its
+// {"error_message": "..."} object and doesn't affect the rest.
+//
+// The calls are decoded and dispatched one at a time as the body is read,
+// and their responses written as they complete, so large batches are never
+// fully buffered. Bodies with a "Content-Encoding" of gzip are decompressed
+// only if enabled with rpc.Server.SetRequestDecompression, within its limit,
+// past which the batch is rejected with 413 Request Entity Too Large.
+// A malformed call after the first one ends the batch with an error object.
+//
+// If the server limits the size of the batches, see rpc.Server.SetMaxBatchSize,
+// the calls are read, up to the limit, before the first one is dispatched,
+// and a larger batch is rejected with 413 Request Entity Too Large.
+func NewBatchHandler(s *rpc.Server, contentType string) http.Handler {
+	return &batchHandler{server: s, contentType: contentType}
+}
+
+type batchHandler struct {
+	server      *rpc.Server
+	contentType string
+}
+
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeBatchError(w, http.StatusMethodNotAllowed, "rpc: POST method required, received "+r.Method)
+		return
+	}
+	if err := h.server.DecompressRequest(r); err != nil {
+		writeBatchError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dec := json.NewDecoder(r.Body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		if errors.Is(err, rpc.ErrRequestTooLarge) {
+			writeBatchError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		writeBatchError(w, http.StatusBadRequest, "rpc: invalid batch request: not an array")
+		return
+	}
+	next := streamCalls(dec)
+	if max := h.server.MaxBatchSize(); max > 0 {
+		var ok bool
+		if next, ok = bufferCalls(next, max); !ok {
+			writeBatchError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("rpc: batch request exceeds %d calls", max))
+			return
+		}
+	}
+	n := 0
+	for ; ; n++ {
+		call, ok, err := next()
+		if !ok {
+			break
+		}
+		if err != nil && n == 0 {
+			status := http.StatusBadRequest
+			if errors.Is(err, rpc.ErrRequestTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeBatchError(w, status, "rpc: invalid batch request: "+err.Error())
+			return
+		}
+		if n == 0 {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("["))
+		} else {
+			w.Write([]byte(","))
+		}
+		if err != nil {
+			w.Write(errorMessage("rpc: invalid batch request: " + err.Error()))
+			break
+		}
+		w.Write(h.call(r, call))
+	}
+	if n == 0 {
+		writeBatchError(w, http.StatusBadRequest, "rpc: empty batch request")
+		return
+	}
+	w.Write([]byte("]"))
+}
+
+// streamCalls returns a function decoding the next call of the batch from
+// dec, which returns false once there are none left. A malformed call is
+// returned with its error and ends the batch, since the decoder can't
+// recover from it.
+func streamCalls(dec *json.Decoder) func() (batchCall, bool, error) {
+	done := false
+	return func() (batchCall, bool, error) {
+		var call batchCall
+		if done || !dec.More() {
+			return call, false, nil
+		}
+		err := dec.Decode(&call)
+		done = err != nil
+		return call, true, err
+	}
+}
+
+// bufferCalls reads the calls returned by next up to max, so that a larger
+// batch is rejected before any call is dispatched, and returns a function
+// returning them again. It returns false if there are more than max calls.
+func bufferCalls(next func() (batchCall, bool, error), max int) (func() (batchCall, bool, error), bool) {
+	var calls []batchCall
+	var errCall error
+	for {
+		call, ok, err := next()
+		if !ok {
+			break
+		}
+		if len(calls) == max {
+			return nil, false
+		}
+		if err != nil {
+			errCall = err
+			break
+		}
+		calls = append(calls, call)
+	}
+	i := 0
+	return func() (batchCall, bool, error) {
+		if i < len(calls) {
+			i++
+			return calls[i-1], true, nil
+		}
+		if errCall != nil {
+			err := errCall
+			errCall = nil
+			return batchCall{}, true, err
+		}
+		return batchCall{}, false, nil
+	}, true
+}
+
+// call dispatches a single call of the batch through the server and returns
+// its response body.
+func (h *batchHandler) call(r *http.Request, call batchCall) json.RawMessage {
+	params := []byte(call.Params)
+	if len(params) == 0 {
+		params = null
+	}
+	req := r.Clone(r.Context())
+	req.URL.Path = "/" + call.Method
+	req.Body = io.NopCloser(bytes.NewReader(params))
+	req.ContentLength = int64(len(params))
+	req.Header.Set("Content-Type", h.contentType)
+	req.Header.Del("Content-Encoding")
+
+	rw := newBatchWriter()
+	h.server.ServeHTTP(rw, req)
+
+	body := bytes.TrimSpace(rw.body.Bytes())
+	if json.Valid(body) {
+		return body
+	}
+	// Errors written by the server before reaching the codec are plain text.
+	return errorMessage(string(body))
+}
+
+// errorMessage returns the {"error_message": "..."} object for msg.
+func errorMessage(msg string) json.RawMessage {
+	b, _ := json.Marshal(&struct {
+		ErrorMessage string `json:"error_message"`
+	}{msg})
+	return b
+}
+
+// writeBatchError writes an error for the whole batch.
+func writeBatchError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(errorMessage(msg))
+}
+
+// batchWriter captures the response of a single call.
+type batchWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newBatchWriter() *batchWriter {
+	return &batchWriter{header: make(http.Header)}
+}
+
+func (w *batchWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+func (w *batchWriter) WriteHeader(int) {
+}
@@ -41,6 +41,29 @@ Response:
   "responseField2": "value2",
 }
 
+Errors are written as {"error_message": "...", "error_code": status},
+with the HTTP status of the response as the code. A method chooses the
+status, e.g. 404 Not Found, by returning an error implementing
+rpc.HTTPStatuser.
+
+By default the method must be the last element of the path and match the
+registered one exactly. NewCodecWithOptions relaxes this, e.g. to accept
+"POST /service.method/":
+
+	s := rpc.NewServer()
+	codec := protorpc.NewCodecWithOptions(
+		protorpc.WithTrailingSlash(),
+		protorpc.WithCaseInsensitiveMethods(s),
+	)
+	s.RegisterCodec(codec, "application/json")
+
+Several calls can be sent in a single request to a handler returned by
+NewBatchHandler, mounted on its own path. The body is an array of
+{"method": "Service.Method", "params": {...}} objects, and the response is
+the array of their responses, errors included, in the same order:
+
+	http.Handle("/rpc/batch", protorpc.NewBatchHandler(s, "application/json"))
+
 Check the gorilla/rpc documentation for more details:
 
 	http://gorilla-web.appspot.com/pkg/rpc
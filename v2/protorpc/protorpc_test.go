@@ -7,12 +7,16 @@ package protorpc
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/rpc/v2"
 )
@@ -46,6 +50,22 @@ func (t *Service1) ResponseError(r *http.Request, req *Service1Request, res *Ser
 	return ErrResponseError
 }
 
+type notFoundError struct {
+	name string
+}
+
+func (e *notFoundError) Error() string {
+	return e.name + " not found"
+}
+
+func (e *notFoundError) HTTPStatus() int {
+	return http.StatusNotFound
+}
+
+func (t *Service1) Lookup(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return fmt.Errorf("lookup: %w", &notFoundError{"item"})
+}
+
 func execute(t *testing.T, s *rpc.Server, method string, req, res interface{}) (*httptest.ResponseRecorder, error) {
 	if !s.HasMethod(method) {
 		t.Fatal("Expected to be registered:", method)
@@ -97,3 +117,397 @@ func TestService(t *testing.T) {
 	}
 
 }
+
+func TestHTTPStatuser(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method  string
+		code    int
+		message string
+	}{
+		{"Service1.Lookup", http.StatusNotFound, "lookup: item not found"},
+		{"Service1.ResponseError", http.StatusBadRequest, ErrResponseError.Error()},
+	}
+	for _, tt := range tests {
+		var res struct {
+			ErrorMessage string `json:"error_message"`
+			ErrorCode    int    `json:"error_code"`
+		}
+		rec, err := execute(t, s, tt.method, &Service1Request{4, 2}, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.code || res.ErrorCode != tt.code {
+			t.Errorf("%s: expected status and error_code %d, but got %d and %d", tt.method, tt.code, rec.Code, res.ErrorCode)
+		}
+		if res.ErrorMessage != tt.message {
+			t.Errorf("%s: expected error_message %q, but got %q", tt.method, tt.message, res.ErrorMessage)
+		}
+	}
+}
+
+func (t *Service1) Throttle(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return &rpc.RetryableError{RetryAfter: time.Minute}
+}
+
+func TestRetryableError(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var res struct {
+		ErrorMessage string `json:"error_message"`
+		ErrorCode    int    `json:"error_code"`
+	}
+	rec, err := execute(t, s, "Service1.Throttle", &Service1Request{4, 2}, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || res.ErrorCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status and error_code 429, but got %d and %d", rec.Code, res.ErrorCode)
+	}
+	if after := rec.Header().Get("Retry-After"); after != "60" {
+		t.Errorf("Expected Retry-After 60, but got %q", after)
+	}
+	if res.ErrorMessage != "rpc: too many requests" {
+		t.Errorf("Expected the default message, but got %q", res.ErrorMessage)
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	h := NewBatchHandler(s, "application/json")
+
+	body := `[
+		{"method": "Service1.Multiply", "params": {"A": 4, "B": 2}},
+		{"method": "Service1.ResponseError", "params": {"A": 4, "B": 2}},
+		{"method": "Service1.Missing", "params": {}},
+		{"method": "Service1.Multiply", "params": {"A": 3, "B": 5}}
+	]`
+	r, _ := http.NewRequest("POST", "http://localhost:8080/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected http response code 200, but got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != jsonContentType {
+		t.Errorf("Expected Content-Type header %q, but got %q", jsonContentType, ct)
+	}
+
+	var res []Service1Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 4 {
+		t.Fatalf("Expected 4 responses, but got %d", len(res))
+	}
+	if res[0].Result != 8 || res[0].ErrorMessage != "" {
+		t.Errorf("Expected result 8, but got %+v", res[0])
+	}
+	if res[1].ErrorMessage != ErrResponseError.Error() {
+		t.Errorf("Expected error_message %q, but got %q", ErrResponseError, res[1].ErrorMessage)
+	}
+	if !strings.Contains(res[2].ErrorMessage, "Missing") {
+		t.Errorf("Expected error_message about the missing method, but got %q", res[2].ErrorMessage)
+	}
+	if res[3].Result != 15 || res[3].ErrorMessage != "" {
+		t.Errorf("Expected result 15, but got %+v", res[3])
+	}
+
+	for _, body := range []string{`{"method": "Service1.Multiply"}`, `[]`} {
+		r, _ = http.NewRequest("POST", "http://localhost:8080/batch", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != 400 {
+			t.Errorf("Expected http response code 400 for %s, but got %d", body, w.Code)
+		}
+	}
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetMaxBatchSize(2)
+	calls := 0
+	s.RegisterAfterFunc(func(i *rpc.RequestInfo) {
+		calls++
+	})
+	h := NewBatchHandler(s, "application/json")
+
+	const call = `{"method": "Service1.Multiply", "params": {"A": 4, "B": 2}}`
+	tests := []struct {
+		body      string
+		code      int
+		calls     int
+		responses int
+	}{
+		{"[" + call + "," + call + "]", http.StatusOK, 2, 2},
+		{"[" + call + "," + call + "," + call + "]", http.StatusRequestEntityTooLarge, 0, 0},
+		{"[" + call + "," + call + `,{"method": 1}]`, http.StatusRequestEntityTooLarge, 0, 0},
+		{"[" + call + `,{"method": 1}]`, http.StatusOK, 1, 2},
+		{`[]`, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		calls = 0
+		r, _ := http.NewRequest("POST", "http://localhost:8080/batch", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code || calls != tt.calls {
+			t.Errorf("Expected code %d and %d calls for %s, but got %d and %d", tt.code, tt.calls, tt.body, w.Code, calls)
+		}
+		var res []Service1Response
+		if tt.responses > 0 {
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != tt.responses {
+				t.Errorf("Expected %d responses for %s, but got %s (%v)", tt.responses, tt.body, w.Body, err)
+			}
+		}
+	}
+}
+
+func TestBatchHandlerGzip(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetRequestDecompression(1 << 20)
+	h := NewBatchHandler(s, "application/json")
+
+	const n = 10000
+	var body bytes.Buffer
+	gw := gzip.NewWriter(&body)
+	gw.Write([]byte("["))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			gw.Write([]byte(","))
+		}
+		fmt.Fprintf(gw, `{"method": "Service1.Multiply", "params": {"A": %d, "B": 2}}`, i)
+	}
+	gw.Write([]byte("]"))
+	gw.Close()
+
+	r, _ := http.NewRequest("POST", "http://localhost:8080/batch", &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expected http response code 200, but got %d", w.Code)
+	}
+	var res []Service1Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != n {
+		t.Fatalf("Expected %d responses, but got %d", n, len(res))
+	}
+	for i, item := range res {
+		if item.Result != i*2 {
+			t.Fatalf("Expected result %d for call %d, but got %+v", i*2, i, item)
+		}
+	}
+
+	// A malformed call ends the batch.
+	r, _ = http.NewRequest("POST", "http://localhost:8080/batch", strings.NewReader(`[{"method": "Service1.Multiply", "params": {"A": 1, "B": 2}}, {"method": 1}, {}]`))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Result != 2 || res[1].ErrorMessage == "" {
+		t.Errorf("Expected a result and an error, but got %+v", res)
+	}
+}
+
+func TestBatchHandlerGzipLimit(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	h := NewBatchHandler(s, "application/json")
+
+	var body bytes.Buffer
+	gw := gzip.NewWriter(&body)
+	gw.Write([]byte(`[` + strings.Repeat(" ", 1<<16) + `{"method": "Service1.Multiply", "params": {"A": 1, "B": 2}}]`))
+	gw.Close()
+	serve := func() int {
+		r, _ := http.NewRequest("POST", "http://localhost:8080/batch", bytes.NewReader(body.Bytes()))
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Without decompression the body is read as is.
+	if code := serve(); code != http.StatusBadRequest {
+		t.Errorf("Expected http response code 400, but got %d", code)
+	}
+	s.SetRequestDecompression(1 << 10)
+	if code := serve(); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected http response code 413, but got %d", code)
+	}
+	s.SetRequestDecompression(1 << 20)
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected http response code 200, but got %d", code)
+	}
+}
+
+func TestPathVariations(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   func(s *rpc.Server) []Option
+		path   string
+		status int
+	}{
+		{"strict", nil, "/Service1.Multiply", http.StatusOK},
+		{"strict trailing slash", nil, "/Service1.Multiply/", http.StatusBadRequest},
+		{"strict case", nil, "/service1.multiply", http.StatusBadRequest},
+		{"trailing slash", func(*rpc.Server) []Option {
+			return []Option{WithTrailingSlash()}
+		}, "/rpc/Service1.Multiply/", http.StatusOK},
+		{"trailing slash without method", func(*rpc.Server) []Option {
+			return []Option{WithTrailingSlash()}
+		}, "/rpc/", http.StatusBadRequest},
+		{"case", func(s *rpc.Server) []Option {
+			return []Option{WithCaseInsensitiveMethods(s)}
+		}, "/service1.MULTIPLY", http.StatusOK},
+		{"case trailing slash", func(s *rpc.Server) []Option {
+			return []Option{WithCaseInsensitiveMethods(s)}
+		}, "/service1.multiply/", http.StatusBadRequest},
+		{"case unknown method", func(s *rpc.Server) []Option {
+			return []Option{WithCaseInsensitiveMethods(s)}
+		}, "/service1.divide", http.StatusBadRequest},
+		{"both", func(s *rpc.Server) []Option {
+			return []Option{WithTrailingSlash(), WithCaseInsensitiveMethods(s)}
+		}, "/SERVICE1.multiply/", http.StatusOK},
+	}
+	for _, tt := range tests {
+		s := rpc.NewServer()
+		var opts []Option
+		if tt.opts != nil {
+			opts = tt.opts(s)
+		}
+		s.RegisterCodec(NewCodecWithOptions(opts...), "application/json")
+		if err := s.RegisterService(new(Service1), ""); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"A":4,"B":2}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d for %s, but got %d: %s", tt.name, tt.status, tt.path, w.Code, w.Body)
+			continue
+		}
+		var res Service1Response
+		if tt.status == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil || res.Result != 8 {
+				t.Errorf("%s: expected result 8, but got %d (%v)", tt.name, res.Result, err)
+			}
+		}
+	}
+}
+
+type LoadService struct {
+	// first is closed once the first record is decoded.
+	first chan struct{}
+}
+
+func (t *LoadService) Count(r *http.Request, dec *json.Decoder, res *int) error {
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		*res++
+		if *res == 1 {
+			close(t.first)
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func TestStreamArgs(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	load := &LoadService{first: make(chan struct{})}
+	if err := s.RegisterService(load, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	r := httptest.NewRequest("POST", "/LoadService.Count", pr)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	// The method gets the first record before the body is complete.
+	io.WriteString(pw, `[{"name": "a"}`)
+	select {
+	case <-load.first:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The body was read before calling the method")
+	}
+	io.WriteString(pw, `, {"name": "b"}]`)
+	pw.Close()
+	<-done
+
+	var res int
+	if err := json.NewDecoder(w.Body).Decode(&res); w.Code != 200 || err != nil || res != 2 {
+		t.Errorf("Expected 2 records, but got %d %d (%v)", w.Code, res, err)
+	}
+}
+
+func TestRegisterNotification(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/json")
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"Service1.Multiply", "Service1.ResponseError"} {
+		if err := s.RegisterNotification(method); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RegisterNotification("Service1.Missing"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+
+	var res Service1Response
+	rec, _ := execute(t, s, "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("Expected an empty 204 response, got %d %q", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "" {
+		t.Errorf("Expected no Content-Type, got %q", ct)
+	}
+
+	if rec, err := execute(t, s, "Service1.ResponseError", &Service1Request{4, 2}, &res); err != nil || rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected code 400, but got %d (%v)", rec.Code, err)
+	}
+	if res.ErrorMessage != ErrResponseError.Error() {
+		t.Errorf("Expected error_message %q, but got %q", ErrResponseError, res.ErrorMessage)
+	}
+}
@@ -56,13 +56,53 @@ func NewCodec() *Codec {
 	return &Codec{}
 }
 
+// NewCodecWithOptions returns a new ProtoRPC Codec configured with the given
+// options.
+func NewCodecWithOptions(opts ...Option) *Codec {
+	c := NewCodec()
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Option configures a Codec created with NewCodecWithOptions.
+type Option func(*Codec)
+
+// WithTrailingSlash makes the codec accept a path ending with a slash, as in
+// "/Service.Method/". By default the method must be the last element of the
+// path.
+func WithTrailingSlash() Option {
+	return func(c *Codec) {
+		c.trailingSlash = true
+	}
+}
+
+// WithCaseInsensitiveMethods makes the codec accept the method in the path
+// in any case, as in "/service.method", resolving it to the method
+// registered in s; see rpc.Server.FindMethodFold. By default the method must
+// match exactly.
+func WithCaseInsensitiveMethods(s *rpc.Server) Option {
+	return func(c *Codec) {
+		c.server = s
+	}
+}
+
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
+	trailingSlash bool
+	server        *rpc.Server
 }
 
 // NewRequest returns a CodecRequest.
 func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	return newCodecRequest(r)
+	return c.newCodecRequest(r)
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return "application/json; charset=utf-8"
 }
 
 // ----------------------------------------------------------------------------
@@ -70,42 +110,56 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 // ----------------------------------------------------------------------------
 
 // newCodecRequest returns a new CodecRequest.
-func newCodecRequest(r *http.Request) rpc.CodecRequest {
+func (c *Codec) newCodecRequest(r *http.Request) rpc.CodecRequest {
 	// Decode the request body and check if RPC method is valid.
 	req := new(serverRequest)
 	path := r.URL.Path
+	if c.trailingSlash {
+		path = strings.TrimSuffix(path, "/")
+	}
 	index := strings.LastIndex(path, "/")
 	if index < 0 {
 		return &CodecRequest{request: req, err: fmt.Errorf("rpc: no method: %s", path)}
 	}
 	req.Method = path[index+1:]
-
-	// Copy request body for decoding and access of underlying methods
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		return &CodecRequest{request: req, err: err}
-	}
-	// Close original body
-	r.Body.Close()
-
-	err = json.Unmarshal(b, &req.Params)
-	var codecErr error
-	if err != io.EOF {
-		codecErr = err
+	if c.server != nil {
+		if method, ok := c.server.FindMethodFold(req.Method); ok {
+			req.Method = method
+		}
 	}
 
-	// Add close method to buffer and pass as request body
-	r.Body = io.NopCloser(bytes.NewBuffer(b))
-
-	return &CodecRequest{request: req, err: codecErr}
+	// The body is only read when the args are decoded, so that methods
+	// reading it themselves get it untouched.
+	return &CodecRequest{request: req, httpReq: r}
 }
 
 // CodecRequest decodes and encodes a single request.
 type CodecRequest struct {
 	request *serverRequest
+	httpReq *http.Request
+	read    bool
 	err     error
 }
 
+// readParams reads the params from the request body the first time the args
+// are decoded, putting a copy of the body back on the request.
+func (c *CodecRequest) readParams() {
+	if c.read {
+		return
+	}
+	c.read = true
+	b, err := io.ReadAll(c.httpReq.Body)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.httpReq.Body.Close()
+	c.httpReq.Body = io.NopCloser(bytes.NewBuffer(b))
+	if err := json.Unmarshal(b, &c.request.Params); err != io.EOF {
+		c.err = err
+	}
+}
+
 // Method returns the RPC method for the current request.
 //
 // The method uses a dotted notation as in "Service.Method".
@@ -118,6 +172,9 @@ func (c *CodecRequest) Method() (string, error) {
 
 // ReadRequest fills the request object for the RPC method.
 func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil {
+		c.readParams()
+	}
 	if c.err == nil {
 		if c.request.Params != nil {
 			c.err = json.Unmarshal(*c.request.Params, args)
@@ -138,11 +195,21 @@ func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
 	c.writeServerResponse(w, 200, res)
 }
 
+// WriteError writes the error as {"error_message": ..., "error_code": ...},
+// where the code is the HTTP status. Errors implementing rpc.HTTPStatuser
+// choose the status, otherwise the one chosen by the server is used.
 func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	var statuser rpc.HTTPStatuser
+	if errors.As(err, &statuser) {
+		if code := statuser.HTTPStatus(); code >= 400 && code <= 599 {
+			status = code
+		}
+	}
 	res := &serverResponse{
 		Result: &struct {
 			ErrorMessage interface{} `json:"error_message"`
-		}{err.Error()},
+			ErrorCode    int         `json:"error_code"`
+		}{err.Error(), status},
 		Id: c.request.Id,
 	}
 	c.writeServerResponse(w, status, res)
@@ -0,0 +1,88 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDContextKey is the request context key under which the server
+// stores the id of the request when enabled with SetRequestIDHeader.
+var RequestIDContextKey = &contextKey{"request-id"}
+
+// RequestIDFromContext returns the id of the request stored in ctx by the
+// server, if any, e.g. to propagate it to the services called downstream.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(RequestIDContextKey).(string)
+	return id, ok
+}
+
+// maxRequestIDLength is the length above which a forwarded request id is
+// replaced by a generated one.
+const maxRequestIDLength = 128
+
+// SetRequestIDHeader gives an id to each request: the one forwarded by the
+// client or a proxy in the named header, e.g. "X-Request-Id", or else one
+// returned by generate, or 16 random bytes in hex if generate is nil.
+// Forwarded ids longer than 128 bytes or with characters other than
+// printable ASCII are replaced, so they can be logged safely.
+//
+// The id is set early, before the middleware registered with UseMiddleware,
+// and sent back in the same response header. It's stored in the request
+// context, see RequestIDFromContext, so it flows through the functions
+// registered with the server, the method and the requests it makes, and in
+// RequestInfo.RequestID. The access log includes it as "request_id".
+//
+// An empty name disables request ids, the default.
+func (s *Server) SetRequestIDHeader(name string, generate func() string) {
+	if generate == nil {
+		generate = newRequestID
+	}
+	s.idHeader = http.CanonicalHeaderKey(name)
+	s.idFunc = generate
+}
+
+// withRequestID returns r with its id in the context, and sets the id in
+// the response header. r is returned as is if request ids are disabled.
+func (s *Server) withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if s.idHeader == "" {
+		return r
+	}
+	id := r.Header.Get(s.idHeader)
+	if !validRequestID(id) {
+		id = s.idFunc()
+	}
+	w.Header().Set(s.idHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), RequestIDContextKey, id))
+}
+
+// requestID returns the id stored in the context of r, empty if none.
+func requestID(r *http.Request) string {
+	id, _ := RequestIDFromContext(r.Context())
+	return id
+}
+
+// validRequestID reports whether a forwarded id can be used as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random bytes in hex.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,92 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type RequestIDService struct {
+}
+
+func (t *RequestIDService) ID(r *http.Request, args *struct{}, reply *string) error {
+	*reply, _ = RequestIDFromContext(r.Context())
+	return nil
+}
+
+func TestRequestID(t *testing.T) {
+	s := NewServer()
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterService(new(RequestIDService), ""); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	s.SetRequestIDHeader("x-request-id", func() string {
+		n++
+		return strings.Repeat("g", n)
+	})
+	var before, after string
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		before, _ = RequestIDFromContext(i.Request.Context())
+	})
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		after = i.RequestID
+	})
+	var buf bytes.Buffer
+	s.EnableAccessLog(&buf, AccessLogJSON)
+
+	tests := []struct {
+		forwarded, expected string
+	}{
+		{"", "g"},
+		{"abc-123", "abc-123"},
+		{"bad\nid", "gg"},
+		{strings.Repeat("a", maxRequestIDLength+1), "ggg"},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"RequestIDService.ID","params":{}}`))
+		r.Header.Set("Content-Type", "application/json")
+		if test.forwarded != "" {
+			r.Header.Set("X-Request-Id", test.forwarded)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Body.String() != `"`+test.expected+`"` {
+			t.Errorf("For %q, the method got %s, should get %q", test.forwarded, w.Body, test.expected)
+		}
+		if id := w.Header().Get("X-Request-Id"); id != test.expected {
+			t.Errorf("For %q, the response header was %q, should be %q", test.forwarded, id, test.expected)
+		}
+		if before != test.expected || after != test.expected {
+			t.Errorf("For %q, the functions got %q and %q, should get %q", test.forwarded, before, after, test.expected)
+		}
+		var e accessLogEntry
+		if err := json.Unmarshal(buf.Bytes(), &e); err != nil || e.RequestID != test.expected {
+			t.Errorf("For %q, the access log was %s, should have the id %q", test.forwarded, buf.Bytes(), test.expected)
+		}
+	}
+
+	s.SetRequestIDHeader("", nil)
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"RequestIDService.ID","params":{}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Body.String() != `""` || after != "" {
+		t.Errorf("Expected no request id when disabled, got %s and %q", w.Body, after)
+	}
+	if _, ok := RequestIDFromContext(context.Background()); ok {
+		t.Error("Expected no request id in an empty context")
+	}
+	if id := newRequestID(); len(id) != 32 || !validRequestID(id) {
+		t.Errorf("Generated id %q is not 32 hex digits", id)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import "net/http"
+
+// MethodRouter resolves the method requested by a client to the registered
+// method serving it, e.g. to route calls to versioned or tenant-specific
+// services, see Server.SetRouter.
+//
+// Resolve receives the request and the method read by the codec, after
+// aliases are resolved, and returns the method to dispatch, in the dotted
+// notation "Service.Method". The returned method must be registered. An
+// error is written to the client like an unknown method; it may wrap
+// ErrMethodNotFound for codecs reporting it with a dedicated code.
+type MethodRouter interface {
+	Resolve(r *http.Request, method string) (string, error)
+}
+
+// MethodRouterFunc is an adapter to use an ordinary function as a
+// MethodRouter.
+type MethodRouterFunc func(r *http.Request, method string) (string, error)
+
+// Resolve calls f(r, method).
+func (f MethodRouterFunc) Resolve(r *http.Request, method string) (string, error) {
+	return f(r, method)
+}
+
+// SetRouter sets the router resolving the method of every request before
+// it's dispatched. By default, or with a nil router, the method read by the
+// codec is dispatched as is.
+func (s *Server) SetRouter(router MethodRouter) {
+	s.router = router
+}
+
+// resolveMethod returns the registered method serving method for r.
+func (s *Server) resolveMethod(r *http.Request, method string) (*serviceMethod, string, error) {
+	if s.router != nil {
+		var err error
+		if method, err = s.router.Resolve(r, method); err != nil {
+			return nil, "", err
+		}
+	}
+	_, methodSpec, err := s.services.get(method)
+	return methodSpec, method, err
+}
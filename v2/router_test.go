@@ -0,0 +1,83 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Service1V2 struct {
+}
+
+func (t *Service1V2) Multiply(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B * 10
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(Service1V2), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var dispatched string
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		dispatched = i.Method
+	})
+	// Route to the version requested in a header.
+	s.SetRouter(MethodRouterFunc(func(r *http.Request, method string) (string, error) {
+		switch v := r.Header.Get("X-API-Version"); v {
+		case "", "1":
+			return method, nil
+		case "2":
+			service, name, _ := strings.Cut(method, ".")
+			return service + "V2." + name, nil
+		default:
+			return "", fmt.Errorf("unknown API version %q", v)
+		}
+	}))
+
+	call := func(version string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-API-Version", version)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		version    string
+		status     int
+		body       string
+		dispatched string
+	}{
+		{"1", 200, `{"Result":20}`, "Service1.Multiply"},
+		{"2", 200, `{"Result":200}`, "Service1V2.Multiply"},
+		{"3", 400, `{"error":"unknown API version \"3\""}`, "Service1.Multiply"},
+	}
+	for _, tt := range tests {
+		dispatched = ""
+		w := call(tt.version)
+		if w.Code != tt.status || strings.TrimSpace(w.Body.String()) != tt.body || dispatched != tt.dispatched {
+			t.Errorf("Version %s: response was %d %s for %s, should be %d %s for %s.", tt.version, w.Code, w.Body, dispatched, tt.status, tt.body, tt.dispatched)
+		}
+	}
+
+	s.SetRouter(nil)
+	if w := call("2"); strings.TrimSpace(w.Body.String()) != `{"Result":20}` {
+		t.Errorf("Response was %s, should be served by Service1 without a router.", w.Body)
+	}
+}
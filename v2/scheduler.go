@@ -0,0 +1,126 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errOverloaded is returned when a request can't be admitted by the
+// scheduler.
+var errOverloaded = errors.New("rpc: server overloaded")
+
+// scheduler admits method calls under a global concurrency limit. When the
+// limit is reached calls wait in a bounded queue, and each freed slot goes
+// to the waiting method that would run the fewest calls relative to its
+// weight.
+type scheduler struct {
+	limit   int
+	depth   int
+	wait    time.Duration
+	mu      sync.Mutex
+	total   int            // calls running
+	running map[string]int // calls running per method
+	queue   []*waiter      // calls waiting, oldest first
+}
+
+// waiter is a call waiting in the scheduler queue.
+type waiter struct {
+	method string
+	weight int
+	ready  chan struct{}
+}
+
+func newScheduler(limit, depth int, wait time.Duration) *scheduler {
+	return &scheduler{
+		limit:   limit,
+		depth:   depth,
+		wait:    wait,
+		running: make(map[string]int),
+	}
+}
+
+// acquire waits for a slot to run the method, returning errOverloaded if
+// the queue is full or the slot isn't granted in time, and the error of ctx
+// if it's done first. A successful call must be followed by release.
+func (s *scheduler) acquire(ctx context.Context, method string, weight int) error {
+	if weight <= 0 {
+		weight = 1
+	}
+	s.mu.Lock()
+	if s.total < s.limit && len(s.queue) == 0 {
+		s.start(method)
+		s.mu.Unlock()
+		return nil
+	}
+	if len(s.queue) >= s.depth {
+		s.mu.Unlock()
+		return errOverloaded
+	}
+	w := &waiter{method: method, weight: weight, ready: make(chan struct{})}
+	s.queue = append(s.queue, w)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+	err := errOverloaded
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, q := range s.queue {
+		if q == w {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return err
+		}
+	}
+	// The slot was granted while timing out.
+	return nil
+}
+
+// release frees the slot of a call to the method and hands it over to a
+// waiting call, if any.
+func (s *scheduler) release(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total--
+	if s.running[method]--; s.running[method] == 0 {
+		delete(s.running, method)
+	}
+	for s.total < s.limit && len(s.queue) > 0 {
+		i := s.next()
+		w := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.start(w.method)
+		close(w.ready)
+	}
+}
+
+// start records a call to the method as running.
+func (s *scheduler) start(method string) {
+	s.total++
+	s.running[method]++
+}
+
+// next returns the index of the queued call to admit: the oldest call of
+// the method with the lowest running calls to weight ratio once admitted.
+func (s *scheduler) next() int {
+	best := 0
+	for i, w := range s.queue[1:] {
+		b := s.queue[best]
+		if (s.running[w.method]+1)*b.weight < (s.running[b.method]+1)*w.weight {
+			best = i + 1
+		}
+	}
+	return best
+}
@@ -0,0 +1,171 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// queued waits until n calls are queued in s.
+func queued(t *testing.T, s *scheduler, n int) {
+	for i := 0; i < 1000; i++ {
+		s.mu.Lock()
+		l := len(s.queue)
+		s.mu.Unlock()
+		if l == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d calls were never queued", n)
+}
+
+func TestSchedulerOverload(t *testing.T) {
+	s := newScheduler(1, 1, 10*time.Millisecond)
+	if err := s.acquire(context.Background(), "A", 1); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.acquire(context.Background(), "A", 1) }()
+	queued(t, s, 1)
+	if err := s.acquire(context.Background(), "B", 1); err != errOverloaded {
+		t.Errorf("Full queue error was %v, should be %v.", err, errOverloaded)
+	}
+	if err := <-done; err != errOverloaded {
+		t.Errorf("Timed out error was %v, should be %v.", err, errOverloaded)
+	}
+	s.release("A")
+	if err := s.acquire(context.Background(), "B", 1); err != nil {
+		t.Errorf("Error was %v after release, should be nil.", err)
+	}
+}
+
+func TestSchedulerContext(t *testing.T) {
+	s := newScheduler(1, 1, time.Minute)
+	if err := s.acquire(context.Background(), "A", 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.acquire(ctx, "A", 1) }()
+	queued(t, s, 1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Canceled error was %v, should be %v.", err, context.Canceled)
+	}
+	queued(t, s, 0)
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := newScheduler(2, 10, time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := s.acquire(context.Background(), "Expensive", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	order := make(chan string, 2)
+	for i, method := range []string{"Expensive", "Cheap"} {
+		go func(method string) {
+			if err := s.acquire(context.Background(), method, 1); err != nil {
+				t.Error(err)
+			}
+			order <- method
+		}(method)
+		queued(t, s, i+1)
+	}
+	// The cheap method isn't running, so it goes first despite being
+	// queued last.
+	s.release("Expensive")
+	if method := <-order; method != "Cheap" {
+		t.Errorf("Admitted %s first, should be Cheap.", method)
+	}
+	s.release("Expensive")
+	if method := <-order; method != "Expensive" {
+		t.Errorf("Admitted %s second, should be Expensive.", method)
+	}
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	s := newScheduler(3, 10, time.Minute)
+	for _, method := range []string{"Heavy", "Heavy", "Light"} {
+		if err := s.acquire(context.Background(), method, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	order := make(chan string, 2)
+	for i, method := range []string{"Light", "Heavy"} {
+		go func(method string) {
+			weight := 1
+			if method == "Heavy" {
+				weight = 4
+			}
+			if err := s.acquire(context.Background(), method, weight); err != nil {
+				t.Error(err)
+			}
+			order <- method
+		}(method)
+		queued(t, s, i+1)
+	}
+	// Heavy runs 2 calls for a weight of 4, Light 1 call for a weight of 1.
+	s.release("Light")
+	if method := <-order; method != "Heavy" {
+		t.Errorf("Admitted %s first, should be Heavy.", method)
+	}
+	s.release("Heavy")
+	<-order
+}
+
+func TestServerScheduler(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMethodWeight("Service1.Multiply", 0); err == nil {
+		t.Error("Expected an error for a zero weight.")
+	}
+	if err := s.SetMethodWeight("Service1.Multiply", 2); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.SetScheduler(1, 0, 0)
+
+	// Take the only slot so the request is rejected.
+	if err := s.scheduler.acquire(context.Background(), "Service1.Multiply", 2); err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock; dummy")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusServiceUnavailable {
+		t.Errorf("Status was %d, should be 503.", w.Status)
+	}
+
+	// A queued request past its deadline leaves the queue.
+	s.SetScheduler(1, 1, time.Minute)
+	if err := s.scheduler.acquire(context.Background(), "Service1.Multiply", 2); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r.WithContext(ctx))
+	if w.Status != http.StatusRequestTimeout {
+		t.Errorf("Status was %d, should be 408.", w.Status)
+	}
+
+	s.scheduler.release("Service1.Multiply")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 || w.Body != "6" {
+		t.Errorf("Response was %d %s, should be 200 6.", w.Status, w.Body)
+	}
+}
@@ -6,10 +6,20 @@
 package rpc
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 var nilErrorValue = reflect.Zero(reflect.TypeOf((*error)(nil)).Elem())
@@ -23,8 +33,32 @@ type Codec interface {
 	NewRequest(*http.Request) CodecRequest
 }
 
+// ContentTyper is an optional interface implemented by codecs that report
+// the Content-Type of the responses they write.
+type ContentTyper interface {
+	ResponseContentType() string
+}
+
+// HTTPMethodSupporter is an optional interface implemented by codecs that
+// accept other HTTP methods than POST, e.g. a codec reading GET requests
+// from the URL. The server rejects requests with other methods with 405
+// Method Not Allowed and an "Allow" header listing them. The codec is then
+// responsible for the methods it declares, including HEAD: the server
+// doesn't restrict them to cacheable methods, but still discards the body
+// of HEAD responses.
+//
+// Requests to the codecs not implementing it must be POST, or HEAD for
+// cacheable methods, or GET if enabled with Server.EnableGet.
+type HTTPMethodSupporter interface {
+	SupportedMethods() []string
+}
+
 // CodecRequest decodes a request and encodes a response using a specific
 // serialization scheme.
+//
+// Codec requests implementing io.Closer are closed once the request is
+// served, whatever the outcome, e.g. to remove the temporary files of an
+// upload.
 type CodecRequest interface {
 	// Reads the request and returns the RPC method name.
 	Method() (string, error)
@@ -36,10 +70,154 @@ type CodecRequest interface {
 	WriteError(w http.ResponseWriter, status int, err error)
 }
 
+// Headerer is implemented by method replies that set response headers,
+// e.g. "Cache-Control". The headers are merged onto the response before the
+// codec writes it, so headers set by the codec itself, like "Content-Type",
+// take precedence.
+type Headerer interface {
+	Headers() http.Header
+}
+
+// Locator is implemented by method replies for a created resource. If the
+// location returned isn't empty, it's set as the "Location" header of the
+// response, whose status is 201 Created instead of 200 OK, or 202 Accepted
+// for methods registered with RegisterAsyncMethod.
+type Locator interface {
+	Location() string
+}
+
+// ResolvedMethodSetter is an optional interface implemented by codec
+// requests that need to know the method actually dispatched by the server,
+// e.g. to include it in the response envelope. SetResolvedMethod is called
+// before the response is written.
+type ResolvedMethodSetter interface {
+	SetResolvedMethod(method string)
+}
+
+// EncoderSetter is an optional interface implemented by codec requests
+// writing their response through an Encoder, so that the one returned by
+// the function set with SetEncoderOverride replaces the one selected by the
+// codec. SetEncoder is called before the response is written.
+type EncoderSetter interface {
+	SetEncoder(enc Encoder)
+}
+
+// Notifier is an optional interface implemented by codec requests whose
+// protocol has notifications, calls the client expects no response for,
+// like JSON-RPC requests without an id. Notification reports whether the
+// request is one; it's exposed as RequestInfo.IsNotification.
+type Notifier interface {
+	Notification() bool
+}
+
+// ParamsTransformer is an optional interface implemented by codec requests
+// with JSON params, so the aliases registered with
+// RegisterAliasWithTransform can adapt the params before they are read.
+type ParamsTransformer interface {
+	TransformParams(f func(json.RawMessage) (json.RawMessage, error)) error
+}
+
+// CorrelationIDer is an optional interface implemented by codec requests
+// that identify each request for observability, e.g. with the request id of
+// the protocol. The id is exposed as RequestInfo.CorrelationID.
+type CorrelationIDer interface {
+	CorrelationID() string
+}
+
+// statusClientClosedRequest is the non-standard status used when the client
+// cancels the request before the method is called.
+const statusClientClosedRequest = 499
+
+// RawResponse is a reply written verbatim as the response body, with the
+// given Content-Type, instead of being encoded by the codec, e.g. for
+// already serialized or binary content. Methods use it as their reply type.
+//
+// Only the codecs implementing RawResponseWriter support it; with other
+// codecs the call fails with a 500 Internal Server Error.
+type RawResponse struct {
+	ContentType string
+	Body        []byte
+}
+
+// RawResponseWriter is implemented by the codec requests that can write a
+// RawResponse. They write the body as is, with the response content type,
+// or "application/octet-stream" if empty.
+type RawResponseWriter interface {
+	WriteRawResponse(w http.ResponseWriter, res *RawResponse)
+}
+
+// ReplyStream is implemented by replies written as an array streamed one
+// element at a time, instead of being encoded at once, so large results
+// don't need to be held in memory. Next returns the next element, or
+// io.EOF after the last one. Methods use a reply type implementing it,
+// e.g. a struct holding an iterator or a channel set by the method.
+//
+// Only the codecs implementing StreamResponseWriter support it; with other
+// codecs the call fails with a 500 Internal Server Error.
+type ReplyStream interface {
+	Next() (interface{}, error)
+}
+
+// StreamResponseWriter is implemented by the codec requests that can write
+// a ReplyStream. An error returned by Next once the response has started is
+// reported with WriteErrorTrailer.
+type StreamResponseWriter interface {
+	WriteStreamResponse(w http.ResponseWriter, stream ReplyStream)
+}
+
+// StreamRequestReader is implemented by the codec requests holding the args
+// in an envelope, such as json2. StreamParams returns the params of the
+// request, read by the methods taking a *json.Decoder or an *io.Reader
+// instead of the whole body. With other codecs such methods read the body
+// as left by the codec.
+type StreamRequestReader interface {
+	StreamParams() io.Reader
+}
+
+// Attachment is a binary part of a reply, see Attacher.
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// Attacher is implemented by replies carrying binary attachments alongside
+// their encoded value, e.g. a generated PDF, typically held in fields
+// excluded from the encoding. Codecs supporting attachments write them as
+// parts of a "multipart/mixed" response if the request accepts it, see
+// AcceptsMultipart, or embed them encoded otherwise. Other codecs ignore
+// them.
+type Attacher interface {
+	Attachments() []Attachment
+}
+
+// AcceptsMultipart reports whether the "Accept" header of r includes
+// "multipart/mixed", so a reply with attachments can be written as a
+// multipart response.
+func AcceptsMultipart(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, accept := range strings.Split(v, ",") {
+			mediaType, params, err := mime.ParseMediaType(accept)
+			if err == nil && mediaType == "multipart/mixed" && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ----------------------------------------------------------------------------
 // Server
 // ----------------------------------------------------------------------------
 
+// FastFunc is a method registered with RegisterFastMethod, called directly
+// instead of through reflection. It's the same as MethodFunc.
+type FastFunc = MethodFunc
+
+// MethodFunc invokes a service method with the already-unmarshalled *args
+// and the *reply to fill. The reply is nil for methods without a reply.
+type MethodFunc func(r *http.Request, args, reply interface{}) error
+
 // NewServer returns a new RPC server.
 func NewServer() *Server {
 	return &Server{
@@ -48,22 +226,118 @@ func NewServer() *Server {
 	}
 }
 
+// Logger is the interface used by the server to log events, satisfied by
+// *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
 // RequestInfo contains all the information we pass to before/after functions
+//
+// The same RequestInfo is passed to all the functions called for a request,
+// from the intercept function to the after function, so they can share
+// per-request state, like a tracing span or a start time, with Set and Get.
 type RequestInfo struct {
 	Method     string
 	Error      error
 	Request    *http.Request
 	StatusCode int
+	// CorrelationID identifies the request in logs and metrics, if the
+	// codec request implements CorrelationIDer.
+	CorrelationID string
+	// RequestID is the id of the request, if enabled with
+	// SetRequestIDHeader.
+	RequestID string
+	// IsNotification is true for the notifications, which get no response,
+	// if the codec request implements Notifier.
+	IsNotification bool
+	// The time spent in each phase of the call, set for the after function:
+	// decoding the args, running the validator, executing the method,
+	// including its middleware, and encoding the response. Phases not
+	// reached are zero.
+	DecodeDuration   time.Duration
+	ValidateDuration time.Duration
+	MethodDuration   time.Duration
+	EncodeDuration   time.Duration
+	values           map[string]interface{}
+}
+
+// Set stores a value under key for the functions called later for the same
+// request.
+func (i *RequestInfo) Set(key string, value interface{}) {
+	if i.values == nil {
+		i.values = make(map[string]interface{})
+	}
+	i.values[key] = value
+}
+
+// Get returns the value stored under key by Set, or nil.
+func (i *RequestInfo) Get(key string) interface{} {
+	return i.values[key]
+}
+
+// SetContextValue adds a value to the context of the request, replacing
+// i.Request. Called from the functions running before the method, i.e. the
+// InterceptFunc, BeforeFunc, BeforeHook, InterceptWithArgsFunc and
+// ValidateRequestFunc, the value is seen by the method and the functions
+// called after it through r.Context().Value(key).
+//
+// Those functions may also assign i.Request themselves, as long as the new
+// request is derived from the current one.
+func (i *RequestInfo) SetContextValue(key, val interface{}) {
+	i.Request = i.Request.WithContext(context.WithValue(i.Request.Context(), key, val))
 }
 
 // Server serves registered RPC services using registered codecs.
 type Server struct {
+	codecsMu      sync.RWMutex // guards codecs and paramCodecs
 	codecs        map[string]Codec
 	services      *serviceMap
+	preIntercept  func(r *http.Request) *http.Request
+	bodyFunc      func(r *http.Request, body []byte) ([]byte, error)
 	interceptFunc func(i *RequestInfo) *http.Request
+	interceptArgs func(i *RequestInfo, args interface{}) *http.Request
+	interceptResp func(w http.ResponseWriter, i *RequestInfo) http.ResponseWriter
 	beforeFunc    func(i *RequestInfo)
+	beforeHook    func(i *RequestInfo) error
 	afterFunc     func(i *RequestInfo)
+	errorHandler  func(i *RequestInfo, status int, err error) (int, error)
+	replyFunc     func(i *RequestInfo, reply interface{}) interface{}
 	validateFunc  reflect.Value
+	healthPath    string
+	logger        Logger
+	slowThreshold time.Duration
+	metrics       Metrics
+	notFound      int
+	errorTrailer  string
+	maxResponse   int64
+	maxInflated   int64 // decompressed body limit, zero to not decompress
+	maxBatch      int
+	encOverride   func(i *RequestInfo) Encoder
+	skipNotified  bool // the after function isn't called for notifications
+	allowSniff    bool
+	paramCodecs   []paramCodec
+	scheduler     *scheduler
+	clientCert    bool
+	accessLog     *accessLog
+	options       bool
+	allowGet      bool
+	headers       http.Header
+	codecHeader   string
+	middleware    []func(http.Handler) http.Handler
+	handler       http.Handler // dispatch wrapped in the middleware
+	fallback      []string
+	capture       *payloadCapture
+	router        MethodRouter
+	idHeader      string        // request id header, see SetRequestIDHeader
+	idFunc        func() string // generates the missing request ids
+}
+
+// paramCodec is a codec registered for a media type with parameters.
+type paramCodec struct {
+	mediaType string
+	params    map[string]string
+	codec     Codec
 }
 
 // RegisterCodec adds a new codec to the server.
@@ -71,22 +345,212 @@ type Server struct {
 // Codecs are defined to process a given serialization scheme, e.g., JSON or
 // XML. A codec is chosen based on the "Content-Type" header from the request,
 // excluding the charset definition.
+//
+// The content type may include parameters, e.g.
+// "application/json; format=compact", to register protocol variants under
+// the same media type. Such a codec is chosen for requests whose
+// "Content-Type" has all its parameters with the same values, the one with
+// the most parameters winning. Other requests are served by the codec
+// registered for the bare media type.
+//
+// See RegisterCodecChecked to test the codec first.
 func (s *Server) RegisterCodec(codec Codec, contentType string) {
-	s.codecs[strings.ToLower(contentType)] = codec
+	mediaType, params := parseContentType(contentType)
+	s.codecsMu.Lock()
+	defer s.codecsMu.Unlock()
+	if len(params) == 0 {
+		s.codecs[mediaType] = codec
+		return
+	}
+	for i, pc := range s.paramCodecs {
+		if pc.mediaType == mediaType && equalParams(pc.params, params) {
+			s.paramCodecs[i].codec = codec
+			return
+		}
+	}
+	s.paramCodecs = append(s.paramCodecs, paramCodec{
+		mediaType: mediaType,
+		params:    params,
+		codec:     codec,
+	})
+}
+
+// RegisteredCodecs returns the content types of the registered codecs,
+// sorted, e.g. to advertise them. Codecs registered with parameters are
+// listed with them, as in "application/json; format=compact".
+func (s *Server) RegisteredCodecs() []string {
+	s.codecsMu.RLock()
+	defer s.codecsMu.RUnlock()
+	contentTypes := make([]string, 0, len(s.codecs)+len(s.paramCodecs))
+	for mediaType := range s.codecs {
+		contentTypes = append(contentTypes, mediaType)
+	}
+	for _, pc := range s.paramCodecs {
+		contentTypes = append(contentTypes, mime.FormatMediaType(pc.mediaType, pc.params))
+	}
+	sort.Strings(contentTypes)
+	return contentTypes
+}
+
+// SetCodecHeader sets the name of a request header, e.g. "X-RPC-Codec",
+// naming the codec of the request by the content type it's registered
+// with, as in "X-RPC-Codec: application/json". When present, the header is
+// used instead of the "Content-Type", e.g. when proxies normalize it, to
+// decode the request and encode the response. A codec name that isn't
+// registered is rejected with 415 Unsupported Media Type. An empty name,
+// the default, disables the header.
+func (s *Server) SetCodecHeader(name string) {
+	s.codecHeader = name
+}
+
+// requestCodec returns the codec of the request, named by the codec header
+// if present or chosen by the "Content-Type", or nil if there is none. The
+// codec header value is returned too.
+func (s *Server) requestCodec(r *http.Request) (Codec, string) {
+	if s.codecHeader != "" {
+		if name := r.Header.Get(s.codecHeader); name != "" {
+			return s.codecFor(name), name
+		}
+	}
+	return s.codecFor(r.Header.Get("Content-Type")), ""
+}
+
+// SetCodecFallbackChain sets the content types of the codecs tried, in
+// order, for requests with an unrecognized "Content-Type" instead of
+// rejecting them with 415 Unsupported Media Type. The body is buffered and
+// each codec attempts to decode it; the first one reading a method serves
+// the request. If none does, the request is rejected as before. Content
+// types without a registered codec are ignored. No arguments disable the
+// fallback.
+func (s *Server) SetCodecFallbackChain(contentTypes ...string) {
+	s.fallback = contentTypes
+}
+
+// fallbackCodec returns the first codec of the fallback chain able to read
+// the method of the request, or nil. The body is restored for the next
+// attempt and for the codec chosen.
+func (s *Server) fallbackCodec(r *http.Request) (Codec, error) {
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	for _, contentType := range s.fallback {
+		codec := s.codecFor(contentType)
+		if codec == nil {
+			continue
+		}
+		if _, err := bufferBody(r); err != nil {
+			return nil, err
+		}
+		codecReq := codec.NewRequest(r)
+		_, err := codecReq.Method()
+		closeCodecRequest(codecReq)
+		if err == nil {
+			bufferBody(r)
+			return codec, nil
+		}
+	}
+	_, err := bufferBody(r)
+	return nil, err
+}
+
+// RegisterPreDecodeInterceptFunc registers the specified function as the
+// function that will be called before the codec reads the request, e.g. to
+// replace it with one whose body is decrypted. The request it returns, if
+// not nil, is the one decoded and passed to the method.
+//
+// The method isn't known yet at this point. The hooks run in this order:
+//
+//   - PreDecodeInterceptFunc
+//   - BodyTransformFunc
+//   - the codec reads the method
+//   - InterceptFunc, ResponseInterceptFunc, BeforeFunc and BeforeHook
+//   - the codec decodes the args
+//   - InterceptWithArgsFunc and ValidateRequestFunc
+//   - the method is called
+//   - ReplyTransformFunc and AfterFunc
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterPreDecodeInterceptFunc(f func(r *http.Request) *http.Request) {
+	s.preIntercept = f
+}
+
+// RegisterBodyTransformFunc registers the specified function as the
+// function that will be called with the raw request body before any codec
+// decodes it, e.g. to decrypt it or to unwrap an envelope. The body it
+// returns is decoded in its place. If it returns an error, the request is
+// rejected with 400 Bad Request.
+//
+// It is called after the PreDecodeInterceptFunc (if registered). The body
+// is decompressed first if enabled with SetRequestDecompression, but the codec is still chosen from the
+// original request: a codec fallback chain tries the untransformed body.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterBodyTransformFunc(f func(r *http.Request, body []byte) ([]byte, error)) {
+	s.bodyFunc = f
+}
+
+// transformBody replaces the body of r with its transformation by the
+// BodyTransformFunc.
+func (s *Server) transformBody(r *http.Request) error {
+	b, err := bufferBody(r)
+	if err != nil {
+		return fmt.Errorf("rpc: reading request body: %v", err)
+	}
+	if b, err = s.bodyFunc(r, b); err != nil {
+		return fmt.Errorf("rpc: invalid request body: %v", err)
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	setBody(r, b)
+	r.ContentLength = int64(len(b))
+	return nil
 }
 
 // RegisterInterceptFunc registers the specified function as the function
 // that will be called before every request. The function is allowed to intercept
 // the request e.g. add values to the context.
 //
+// It is called once the codec has read the method, so the request it
+// returns can't change how the method is read; see
+// RegisterPreDecodeInterceptFunc for that.
+//
 // Note: Only one function can be registered, subsequent calls to this
 // method will overwrite all the previous functions.
 func (s *Server) RegisterInterceptFunc(f func(i *RequestInfo) *http.Request) {
 	s.interceptFunc = f
 }
 
+// RegisterInterceptWithArgsFunc registers the specified function as the
+// function that will be called after the method args have been decoded and
+// before the ValidateRequestFunc (if registered). The second argument is the
+// already-unmarshalled *args parameter of the method, so the function is
+// allowed to intercept the request based on the payload, e.g. add values to
+// the context.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterInterceptWithArgsFunc(f func(i *RequestInfo, args interface{}) *http.Request) {
+	s.interceptArgs = f
+}
+
+// RegisterResponseInterceptFunc registers the specified function as the
+// function that will be called right after the InterceptFunc (if
+// registered). The function is allowed to replace the ResponseWriter used for
+// the rest of the request, e.g. to capture or transform the response or to
+// add headers. The original ResponseWriter is used if it returns nil.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterResponseInterceptFunc(f func(w http.ResponseWriter, i *RequestInfo) http.ResponseWriter) {
+	s.interceptResp = f
+}
+
 // RegisterBeforeFunc registers the specified function as the function
-// that will be called before every request.
+// that will be called before every request. The function may add values to
+// the context seen by the method with RequestInfo.SetContextValue.
 //
 // Note: Only one function can be registered, subsequent calls to this
 // method will overwrite all the previous functions.
@@ -94,16 +558,46 @@ func (s *Server) RegisterBeforeFunc(f func(i *RequestInfo)) {
 	s.beforeFunc = f
 }
 
+// RegisterBeforeHook registers the specified function as the hook that will
+// be called after the BeforeFunc (if registered) and before the method args
+// are decoded. If the hook returns a non-nil error, the method won't be
+// invoked and the error is written using the codec, e.g. to reject requests
+// that fail authentication. The response status defaults to 400 Bad Request
+// and can be changed by setting StatusCode on the RequestInfo.
+//
+// Note: Only one hook can be registered, subsequent calls to this
+// method will overwrite all the previous hooks.
+func (s *Server) RegisterBeforeHook(f func(i *RequestInfo) error) {
+	s.beforeHook = f
+}
+
 // RegisterValidateRequestFunc registers the specified function as the function
 // that will be called after the BeforeFunc (if registered) and before invoking
 // the actual Service method. If this function returns a non-nil error, the method
 // won't be invoked and this error will be considered as the method result.
 // The first argument is information about the request, useful for accessing to http.Request.Context()
 // The second argument of this function is the already-unmarshalled *args parameter of the method.
+// It points to the same value that is passed to the method, so the function may
+// also normalize the args (trim strings, apply defaults, zero fields) and the
+// method will see those changes.
+// A panic of the function is logged and answered with a 500 error written by
+// the codec, without invoking the method.
 func (s *Server) RegisterValidateRequestFunc(f func(r *RequestInfo, i interface{}) error) {
 	s.validateFunc = reflect.ValueOf(f)
 }
 
+// RegisterReplyTransformFunc registers the specified function as the
+// function that will be called after the method succeeds and before the
+// reply is encoded. The value it returns is encoded instead of the reply,
+// and can be of a different type, e.g. to strip internal fields or add
+// computed ones to every reply in one place. It isn't called for errors.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterReplyTransformFunc(f func(i *RequestInfo, reply interface{}) interface{}) {
+	s.replyFunc = f
+}
+
 // RegisterAfterFunc registers the specified function as the function
 // that will be called after every request
 //
@@ -113,6 +607,301 @@ func (s *Server) RegisterAfterFunc(f func(i *RequestInfo)) {
 	s.afterFunc = f
 }
 
+// SetAfterFuncForNotifications sets whether the function registered with
+// RegisterAfterFunc is called for notifications, see
+// RequestInfo.IsNotification, e.g. to keep them out of metrics assuming a
+// response was written. The default is true.
+func (s *Server) SetAfterFuncForNotifications(enabled bool) {
+	s.skipNotified = !enabled
+}
+
+// RegisterErrorHandler registers the specified function as the function
+// that will be called right before any error is written, from an invalid
+// Content-Type or an unknown method to an error returned by the method,
+// with the status the error would be written with. The error is written
+// with the status and error returned instead, e.g. to hide internal details
+// in production. Returning the same values, or a zero status and a nil
+// error, changes nothing.
+//
+// The RequestInfo holds the request and, once known, the method. The after
+// function gets the original error and the status actually written.
+//
+// Note: Only one function can be registered, subsequent calls to this
+// method will overwrite all the previous functions.
+func (s *Server) RegisterErrorHandler(f func(i *RequestInfo, status int, err error) (int, error)) {
+	s.errorHandler = f
+}
+
+// SetHealthCheckPath enables a health check endpoint at the given path.
+//
+// GET requests to this path are answered with 200 OK and a small JSON body
+// without going through codec lookup or method dispatch, so the server can
+// be used as a load balancer health endpoint. An empty path, the default,
+// disables the health check.
+func (s *Server) SetHealthCheckPath(path string) {
+	s.healthPath = path
+}
+
+// SetDefaultResponseHeaders sets headers added to every response of the
+// server, e.g. "X-API-Version" or "Cache-Control: no-store". They are set
+// before the request is handled, so the headers set by the codec, such as
+// "Content-Type", or by the reply, see Headerer, take precedence. A nil
+// header removes the defaults.
+func (s *Server) SetDefaultResponseHeaders(h http.Header) {
+	s.headers = h.Clone()
+}
+
+// SetLogger sets the logger used by the server. If nil, the default logger
+// of the standard log package is used.
+func (s *Server) SetLogger(l Logger) {
+	s.logger = l
+}
+
+// EnableAccessLog writes a line to w after each request is served, with the
+// time, method, response status, duration and request and response body
+// sizes, in the given format, and the request id if enabled with
+// SetRequestIDHeader. The method is empty for requests rejected before it is
+// known. A nil w disables the access log.
+func (s *Server) EnableAccessLog(w io.Writer, format AccessLogFormat) {
+	if w == nil {
+		s.accessLog = nil
+		return
+	}
+	s.accessLog = &accessLog{w: w, format: format}
+}
+
+// SetMetrics sets the Metrics the server reports measurements to. If nil,
+// the default, no measurements are taken.
+func (s *Server) SetMetrics(m Metrics) {
+	s.metrics = m
+}
+
+// SetMethodNotFoundStatus sets the HTTP status used when the requested
+// method is not registered, e.g. http.StatusNotFound. The status is sent
+// even by codecs writing errors with 200 OK. The default is
+// http.StatusBadRequest, as given to the codec.
+func (s *Server) SetMethodNotFoundStatus(code int) {
+	s.notFound = code
+}
+
+// SetMaxResponseBytes limits the size of the response body written for a
+// reply to n bytes, to guard against methods returning huge replies. The
+// replies are buffered up to the limit, and one too large is replaced by a
+// 500 error. A streamed response, from a ReplyStream, is cut at the limit
+// instead, reporting the error in the error trailer, if any, see
+// SetErrorTrailer. Both cases are logged. Zero, the default, means
+// unlimited.
+func (s *Server) SetMaxResponseBytes(n int64) {
+	s.maxResponse = n
+}
+
+// SetRequestDecompression enables the decompression of the request bodies
+// sent with a "Content-Encoding" of gzip, so that codecs read them as is.
+// The decompressed body is limited to maxBytes: reading past it fails and
+// the request is rejected with 413 Request Entity Too Large, to guard
+// against small payloads decompressing to huge bodies. Zero, the default,
+// disables the decompression, leaving the body to the codec.
+func (s *Server) SetRequestDecompression(maxBytes int64) {
+	s.maxInflated = maxBytes
+}
+
+// DecompressRequest decompresses the body of r as ServeHTTP does, for
+// handlers reading it themselves, such as protorpc.NewBatchHandler. Nothing
+// is done unless enabled with SetRequestDecompression, and reading the body
+// past the limit fails with ErrRequestTooLarge. It returns an error if the
+// body isn't valid gzip.
+func (s *Server) DecompressRequest(r *http.Request) error {
+	_, err := decompressRequest(r, s.maxInflated)
+	return err
+}
+
+// SetMaxBatchSize limits the number of calls of a batch request, such as
+// the ones served by protorpc.NewBatchHandler, to n. Larger batches are
+// rejected as a whole with 413 Request Entity Too Large before any call is
+// dispatched. Zero, the default, means unlimited.
+func (s *Server) SetMaxBatchSize(n int) {
+	s.maxBatch = n
+}
+
+// MaxBatchSize returns the limit set with SetMaxBatchSize, for the batch
+// handlers to enforce.
+func (s *Server) MaxBatchSize() int {
+	return s.maxBatch
+}
+
+// SetEncoderOverride sets a function choosing the Encoder of the response
+// once the method is known, e.g. to disable the compression of the methods
+// whose replies are already compressed whatever the "Accept-Encoding" of
+// the request. If it returns nil, the encoder selected by the codec is
+// kept. Only codecs whose requests implement EncoderSetter, like json2,
+// jsonrpc1 and gob, are affected.
+func (s *Server) SetEncoderOverride(f func(i *RequestInfo) Encoder) {
+	s.encOverride = f
+}
+
+// SetSniffProtection enables or disables the "X-Content-Type-Options:
+// nosniff" header set on responses, e.g. to leave the security headers to a
+// proxy. It is enabled by default.
+func (s *Server) SetSniffProtection(enabled bool) {
+	s.allowSniff = !enabled
+}
+
+// SetErrorTrailer declares an HTTP trailer with the given name, e.g.
+// "X-RPC-Error", on every response. Codecs that fail after the response
+// body has started, like when streaming a large reply, report the error in
+// the trailer using WriteErrorTrailer, for clients that read trailers. An
+// empty name, the default, disables the trailer.
+func (s *Server) SetErrorTrailer(name string) {
+	s.errorTrailer = name
+}
+
+// EnablePayloadCapture makes the server buffer the request body and copy
+// the response body of a sampled fraction of the requests, given by rate
+// between 0 and 1, and pass them to sink once the response is written, e.g.
+// to debug production issues.
+//
+// The bodies are captured uncompressed, as read and written by the codec;
+// streamed responses are still flushed as they are written. Requests
+// rejected before the method is known are captured with an empty method.
+// A rate of 0 or a nil sink disables the capture entirely.
+func (s *Server) EnablePayloadCapture(rate float64, sink func(method string, req, resp []byte)) {
+	if rate <= 0 || sink == nil {
+		s.capture = nil
+		return
+	}
+	s.capture = &payloadCapture{rate: rate, sink: sink}
+}
+
+// SetSlowRequestThreshold enables logging of the methods whose execution
+// takes longer than d, with the method name and duration. It doesn't affect
+// the response. Zero, the default, disables it.
+func (s *Server) SetSlowRequestThreshold(d time.Duration) {
+	s.slowThreshold = d
+}
+
+// EnableClientCertContext makes the server store the verified client
+// certificate of mutual TLS requests in the request context, under
+// ClientCertContextKey, before dispatching them. Methods read it with
+// ClientCertificate(r.Context()), e.g. to check its subject. Requests
+// without TLS or without a verified client certificate don't have the value.
+func (s *Server) EnableClientCertContext() {
+	s.clientCert = true
+}
+
+// EnableOptions makes the server answer OPTIONS requests with a 204 status,
+// an "Allow: POST, OPTIONS" header and an "Accept-Post" header listing the
+// content types of the registered codecs, as returned by RegisteredCodecs,
+// so clients can discover the supported serializations. It doesn't handle
+// CORS preflight requests, which need their own headers.
+func (s *Server) EnableOptions() {
+	s.options = true
+}
+
+// EnableGet makes the server accept GET requests for the methods registered
+// with RegisterCacheable, like HEAD requests but with a response body, so
+// read-only calls can be made from a URL, e.g. with the query string mode
+// of the json2 codec. GET requests for other methods are rejected with 405.
+// As GET requests usually don't have a "Content-Type", the codec is chosen
+// as for requests without one.
+func (s *Server) EnableGet() {
+	s.allowGet = true
+}
+
+// SetScheduler limits the number of method calls running at once to limit.
+//
+// Calls over the limit wait in a queue of up to depth calls for at most
+// wait; calls that don't fit in the queue or wait longer are answered with
+// 503 Service Unavailable. Calls whose request is canceled or times out
+// while queued leave the queue, answered like the ones skipped before the
+// method is called. Each freed slot goes to the queued method running
+// the fewest calls relative to its weight, see SetMethodWeight, so expensive
+// methods don't starve cheap ones. A limit of zero, the default, disables
+// scheduling.
+//
+// It must be called before serving requests.
+func (s *Server) SetScheduler(limit, depth int, wait time.Duration) {
+	if limit <= 0 {
+		s.scheduler = nil
+		return
+	}
+	s.scheduler = newScheduler(limit, depth, wait)
+}
+
+// SetMethodWeight sets the weight of the given method for the scheduler
+// enabled with SetScheduler. Queued methods get a share of the slots
+// proportional to their weight. Methods without a weight have weight 1.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodWeight(method string, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("rpc: invalid weight %d for %q", weight, method)
+	}
+	return s.services.setWeight(method, weight)
+}
+
+// SetMethodTimeout sets a timeout for the invocation of the given method:
+// its request context gets a deadline d after the method is called. If the
+// deadline passes before the method returns, a 504 Gateway Timeout error is
+// written instead of its reply and reported to the after function. The
+// deadline can only shorten the one of the request context, if any. Zero
+// removes the timeout.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodTimeout(method string, d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("rpc: invalid timeout %s for %q", d, method)
+	}
+	return s.services.setTimeout(method, d)
+}
+
+// SetMethodCircuitBreaker sets a circuit breaker for the given method, to
+// protect e.g. a failing dependency of the method. After opts.Threshold
+// consecutive failures, errors returned or panics, the method isn't called
+// for opts.Cooldown and calls get a 503 Service Unavailable error. Then a
+// single call is let through: if it succeeds the calls resume, otherwise
+// the breaker stays open for another cooldown.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodCircuitBreaker(method string, opts BreakerOptions) error {
+	return s.services.setBreaker(method, newCircuitBreaker(opts))
+}
+
+// logf logs using the registered logger or the standard one.
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// SetMethodNamer sets the function returning the name under which the
+// methods of the services registered afterwards are exposed, given the
+// service name and the Go method name, e.g. to expose "Service.Multiply"
+// as "Service.multiply_numbers". By default the Go method name is used.
+func (s *Server) SetMethodNamer(f func(service, method string) string) {
+	s.services.mutex.Lock()
+	defer s.services.mutex.Unlock()
+	s.services.namer = f
+}
+
+// Reset returns the server to the state of a new one, removing the
+// registered codecs, services, aliases and hooks and the settings, e.g. to
+// reuse it across tests. It must not be called while requests are being
+// served.
+func (s *Server) Reset() {
+	services := s.services
+	services.mutex.Lock()
+	services.services = nil
+	services.namer = nil
+	services.aliases = nil
+	services.mutex.Unlock()
+	*s = Server{
+		codecs:   make(map[string]Codec),
+		services: services,
+	}
+}
+
 // RegisterService adds a new service to the server.
 //
 // The name parameter is optional: if empty it will be inferred from
@@ -124,13 +913,350 @@ func (s *Server) RegisterAfterFunc(f func(i *RequestInfo)) {
 //      (defined in the package registering the service).
 //    - The method name is exported.
 //    - The method has three arguments: *http.Request, *args, *reply.
+//      The *reply argument can be omitted for methods without a result.
+//      A fourth rpc.Progress argument can follow it, see Progress.
 //    - All three arguments are pointers.
 //    - The second and third arguments are exported or local.
 //    - The method has return type error.
 //
 // All other methods are ignored.
+//
+// The receiver is shared by all the calls to its methods, which may run
+// concurrently, and by every server it is registered on, so it must be safe
+// for concurrent use. Registering a value whose methods have pointer
+// receivers is an error: register a pointer instead.
 func (s *Server) RegisterService(receiver interface{}, name string) error {
-	return s.services.register(receiver, name)
+	return s.services.register(receiver, name, false)
+}
+
+// RegisterServiceStrict is like RegisterService, but it fails instead of
+// ignoring the exported methods that look like handlers, taking an
+// http.Request argument, and don't satisfy the rules, e.g. because of a
+// typo in their signature. The error names each of them and why it was
+// rejected, and nothing is registered.
+func (s *Server) RegisterServiceStrict(receiver interface{}, name string) error {
+	if err := checkHandlers(receiver); err != nil {
+		return err
+	}
+	return s.services.register(receiver, name, false)
+}
+
+// RegisterServiceMerge adds the methods of the receiver to the service with
+// the given name, registering it if needed. This allows to split a service
+// across several types, e.g. "Account.Create" and "Account.Delete".
+//
+// The rules to extract the methods are the same as RegisterService. It is
+// an error if a method is already defined in the service.
+func (s *Server) RegisterServiceMerge(receiver interface{}, name string) error {
+	return s.services.register(receiver, name, true)
+}
+
+// RegisterServiceWithOptions is like RegisterService, configured with the
+// given options. With OnConflict(ConflictSkip) or
+// OnConflict(ConflictOverwrite), the methods are merged into the service
+// with the given name if it's already defined, as with
+// RegisterServiceMerge, and the methods already defined are kept or
+// replaced instead of failing the registration. Their names, in dotted
+// notation, are returned so they can be reported.
+func (s *Server) RegisterServiceWithOptions(receiver interface{}, name string, opts ...ServiceOption) ([]string, error) {
+	var o serviceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return s.services.registerService(receiver, name, false, o.conflict)
+}
+
+// Mount adds the services registered on sub to s under namespace, so the
+// method "Service.Method" of sub is called on s as
+// "namespace.Service.Method", e.g. to compose independently built groups of
+// services. Its aliases are mounted as well. It's an error if s already has
+// a service or alias under the namespace; nothing is mounted then.
+//
+// The methods are shared with sub, including their settings such as
+// timeouts or pooling, but the services registered on sub afterwards aren't
+// mounted. The requests are handled by s: the codecs of sub are inherited
+// for the content types s has no codec for, the hooks of sub aren't called.
+func (s *Server) Mount(namespace string, sub *Server) error {
+	for _, part := range strings.Split(namespace, ".") {
+		if part == "" {
+			return fmt.Errorf("rpc: namespace ill-formed: %q", namespace)
+		}
+	}
+	if sub == s {
+		return errors.New("rpc: can't mount a server on itself")
+	}
+	if err := s.services.mount(namespace, sub.services); err != nil {
+		return err
+	}
+	sub.codecsMu.RLock()
+	defer sub.codecsMu.RUnlock()
+	s.codecsMu.Lock()
+	defer s.codecsMu.Unlock()
+	for mediaType, codec := range sub.codecs {
+		if _, ok := s.codecs[mediaType]; !ok {
+			s.codecs[mediaType] = codec
+		}
+	}
+	for _, pc := range sub.paramCodecs {
+		if !hasParamCodec(s.paramCodecs, pc) {
+			s.paramCodecs = append(s.paramCodecs, pc)
+		}
+	}
+	return nil
+}
+
+// hasParamCodec returns true if codecs has a codec for the content type of
+// pc.
+func hasParamCodec(codecs []paramCodec, pc paramCodec) bool {
+	for _, c := range codecs {
+		if c.mediaType == pc.mediaType && equalParams(c.params, pc.params) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterFastMethod registers fn as the method with the given name, in
+// dotted notation as in "Service.Method", adding it to the service if it
+// already exists.
+//
+// The method is called directly, avoiding the cost of the reflection based
+// call of the methods registered with RegisterService, which makes it
+// suitable for hot methods and for adapters generated by tools. argsType
+// and replyType are the types of *args and *reply, e.g.
+// reflect.TypeOf((*Args)(nil)); the codec decodes the request into a new
+// *args and fn receives values of these types. A nil replyType registers a
+// method without reply.
+func (s *Server) RegisterFastMethod(name string, argsType, replyType reflect.Type, fn FastFunc) error {
+	return s.services.registerFast(name, argsType, replyType, fn)
+}
+
+// RegisterFuncs registers standalone functions as methods, without a
+// receiver type: each function of funcs is registered with RegisterFastMethod
+// as the method named prefix + key, so a prefix like "Math." puts them in
+// the "Math" service. The functions must have the signature of a service
+// method, func(*http.Request, *Args, *Reply) error, optionally without the
+// reply, and are called through reflection.
+//
+// Functions with an unsuitable signature or name are not registered and
+// reported in the returned error, one per key; the others are registered.
+func (s *Server) RegisterFuncs(prefix string, funcs map[string]interface{}) error {
+	return s.services.registerFuncs(prefix, funcs)
+}
+
+// RegisterAliasWithTransform registers alias, in dotted notation as in
+// "Service.Method", as another name of the target method. Calls of the
+// alias have their params adapted by transform, e.g. to accept the params
+// of an older version of the API, before the codec reads them into the
+// args of the target; calls of the target are not affected. A nil
+// transform leaves the params as is.
+//
+// Transforms need codecs implementing ParamsTransformer, like the json and
+// json2 ones. A transform error is written with status 400.
+//
+// Aliases are resolved before the methods, so a method can't be registered
+// afterwards under the name of an alias, nor an alias under the name of a
+// method.
+func (s *Server) RegisterAliasWithTransform(alias, target string, transform func(json.RawMessage) (json.RawMessage, error)) error {
+	return s.services.registerAlias(alias, target, transform)
+}
+
+// Validate checks that every alias stands for a registered method and that
+// none shadows a method, e.g. to verify the setup of the server at startup
+// or in tests. The error joins one error per inconsistency, nil if there
+// is none.
+func (s *Server) Validate() error {
+	return s.services.validate()
+}
+
+// errValidatorPanic is the error written when the validator panics; the
+// panic value is only logged.
+var errValidatorPanic = errors.New("rpc: internal error validating the request")
+
+// validate calls the validator with i and args. A panic of the validator is
+// logged and returned as errValidatorPanic, with panicked set.
+func (s *Server) validate(i *RequestInfo, args reflect.Value) (errValue []reflect.Value, panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.logf("rpc: validator panicked for method=%s: %v\n%s", i.Method, v, debug.Stack())
+			err := errValidatorPanic
+			errValue, panicked = []reflect.Value{reflect.ValueOf(&err).Elem()}, true
+		}
+	}()
+	return s.validateFunc.Call([]reflect.Value{reflect.ValueOf(i), args}), false
+}
+
+// after records the outcome of the call in i and calls the after function,
+// if any.
+func (s *Server) after(i *RequestInfo, err error, status int) {
+	i.Error = err
+	i.StatusCode = status
+	if s.afterFunc != nil && !(i.IsNotification && s.skipNotified) {
+		s.afterFunc(i)
+	}
+}
+
+// handleError returns the status and error to write for err, as rewritten
+// by the error handler, if any.
+func (s *Server) handleError(i *RequestInfo, status int, err error) (int, error) {
+	if s.errorHandler == nil {
+		return status, err
+	}
+	newStatus, newErr := s.errorHandler(i, status, err)
+	if newStatus == 0 {
+		newStatus = status
+	}
+	if newErr == nil {
+		newErr = err
+	}
+	return newStatus, newErr
+}
+
+// writeError writes err with codecReq after passing it to the error
+// handler, and returns the status written. An error implementing
+// HTTPStatuser, such as a RetryableError, is written with its status, even
+// by codecs writing errors with 200 OK.
+func (s *Server) writeError(w http.ResponseWriter, codecReq CodecRequest, i *RequestInfo, status int, err error) int {
+	status, err = s.handleError(i, status, err)
+	return s.writeCodecError(w, codecReq, status, err, false)
+}
+
+// writeStatusError is writeError for the errors of the HTTP request rather
+// than of the call, e.g. 405 Method Not Allowed, which are written with
+// their status even by codecs writing errors with 200 OK.
+func (s *Server) writeStatusError(w http.ResponseWriter, codecReq CodecRequest, i *RequestInfo, status int, err error) int {
+	status, err = s.handleError(i, status, err)
+	return s.writeCodecError(w, codecReq, status, err, true)
+}
+
+// writeCodecError writes the handled err with codecReq, forcing the status
+// on the response if force is set, and returns the status written.
+func (s *Server) writeCodecError(w http.ResponseWriter, codecReq CodecRequest, status int, err error, force bool) int {
+	var statuser HTTPStatuser
+	if errors.As(err, &statuser) {
+		if code := statuser.HTTPStatus(); code >= 400 && code <= 599 {
+			status = code
+			force = true
+		}
+	}
+	var retry *RetryableError
+	if errors.As(err, &retry) {
+		if after := retry.retryAfter(); after != "" {
+			w.Header().Set("Retry-After", after)
+		}
+	}
+	if force {
+		w = &successResponseWriter{ResponseWriter: w, status: status}
+	}
+	codecReq.WriteError(w, status, err)
+	return status
+}
+
+// transformParams adapts the params of the codec request with transform, if
+// not nil, returning the status to write the error with on failure.
+func transformParams(codecReq CodecRequest, transform func(json.RawMessage) (json.RawMessage, error)) (int, error) {
+	if transform == nil {
+		return 0, nil
+	}
+	pt, ok := codecReq.(ParamsTransformer)
+	if !ok {
+		return http.StatusInternalServerError, errors.New("rpc: the codec doesn't support transforming params")
+	}
+	if err := pt.TransformParams(transform); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// SetMethodPooling enables or disables reuse of the args and reply values
+// allocated for each call of the given method.
+//
+// When enabled, the values are zeroed before being handed to the method and
+// recycled once the response has been written. Only enable it for methods
+// that don't retain references to their args or reply after returning.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) SetMethodPooling(method string, enabled bool) error {
+	return s.services.setPooled(method, enabled)
+}
+
+// RegisterCacheable enables ETag handling for the given idempotent method.
+//
+// Successful responses of the method are buffered to compute an ETag over
+// the encoded body. If the request has a matching "If-None-Match" header,
+// 304 Not Modified is returned without a body. Other methods are written
+// directly, without buffering.
+//
+// Cacheable methods are considered read-only, so they also accept HEAD
+// requests, answered with the status and headers, including Content-Length
+// and ETag, but without a body. Other methods reject HEAD with 405. They
+// also accept GET requests if enabled with EnableGet.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) RegisterCacheable(method string) error {
+	return s.services.setCacheable(method)
+}
+
+// RegisterAsyncMethod marks the given method as asynchronous, e.g. a
+// fire-and-forget method enqueueing work. Its successful responses are
+// written with status 202 Accepted instead of 200 OK, telling the client the
+// call was accepted for processing rather than done. The body is the reply
+// encoded by the codec as usual, so such methods should keep it minimal or
+// omit the reply argument. Errors keep their status.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) RegisterAsyncMethod(method string) error {
+	return s.services.setAsync(method)
+}
+
+// RegisterNotification marks the given method as a notification, a
+// fire-and-forget method whose reply isn't expected, whatever the codec.
+// Its successful responses are written with status 204 No Content and no
+// body: the reply isn't encoded and the codec's WriteResponse isn't called.
+// Errors are written by the codec as usual.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) RegisterNotification(method string) error {
+	return s.services.setNotification(method)
+}
+
+// Use registers a middleware wrapping the invocation of the given method.
+//
+// The middleware receives the next MethodFunc in the chain and returns the
+// one to be called instead, so it is allowed to inspect or modify the args,
+// short-circuit the call by returning an error without calling next, and
+// observe the reply after next returns. Middleware registered first is the
+// outermost. Methods without middleware are invoked directly.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (s *Server) Use(method string, mw func(next MethodFunc) MethodFunc) error {
+	return s.services.use(method, mw)
+}
+
+// ValidateService checks the methods of receiver with the same rules as
+// RegisterService, without registering it.
+//
+// It returns the names of the methods that would be registered and, for
+// each method that would be ignored, the reason why. The error is non-nil
+// if no method would be registered.
+func ValidateService(receiver interface{}) (accepted []string, rejected map[string]string, err error) {
+	rcvrType := reflect.TypeOf(receiver)
+	if rcvrType == nil {
+		return nil, nil, errors.New("rpc: no receiver")
+	}
+	rejected = make(map[string]string)
+	for i := 0; i < rcvrType.NumMethod(); i++ {
+		method := rcvrType.Method(i)
+		if _, _, reason := checkMethod(method); reason != "" {
+			rejected[method.Name] = reason
+		} else {
+			accepted = append(accepted, method.Name)
+		}
+	}
+	if len(accepted) == 0 {
+		err = fmt.Errorf("rpc: %q has no exported methods of suitable type", rcvrType.String())
+	}
+	return accepted, rejected, err
 }
 
 // HasMethod returns true if the given method is registered.
@@ -143,98 +1269,486 @@ func (s *Server) HasMethod(method string) bool {
 	return false
 }
 
+// FindMethodFold returns the registered method matching method regardless
+// of case, as in "service1.multiply" for "Service1.Multiply", e.g. for codecs
+// accepting methods in any case. An exact match is preferred; if several
+// methods match otherwise, none is returned. The lookup scans the services,
+// unlike HasMethod.
+func (s *Server) FindMethodFold(method string) (string, bool) {
+	return s.services.getFold(method)
+}
+
 // ServeHTTP
+//
+// If the request context is done by the time the method would be called,
+// e.g. because the client disconnected during a slow before function, the
+// method is skipped and the context error is written using the codec, with
+// status 499 if the request was canceled or 408 Request Timeout if its
+// deadline passed. A method running past its timeout, see SetMethodTimeout,
+// gets a 504 Gateway Timeout error.
+//
+// Requests with an HTTP method that isn't accepted are rejected with 405
+// Method Not Allowed and an "Allow" header listing the accepted ones: POST,
+// HEAD and GET if enabled, see RegisterCacheable and EnableGet, or the
+// methods declared by the codec, see HTTPMethodSupporter.
+//
+// The request goes through the HTTP middleware registered with
+// UseMiddleware, if any, before being dispatched.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		WriteError(w, http.StatusMethodNotAllowed, "rpc: POST method required, received "+r.Method)
+	r = s.withRequestID(w, r)
+	if s.handler != nil {
+		s.handler.ServeHTTP(w, r)
 		return
 	}
-	contentType := r.Header.Get("Content-Type")
-	idx := strings.Index(contentType, ";")
-	if idx != -1 {
-		contentType = contentType[:idx]
+	s.serveHTTP(w, r)
+}
+
+// UseMiddleware wraps the dispatch of the requests in the given standard
+// net/http middleware, e.g. from third party packages, the first one being
+// the outermost. Subsequent calls add middleware inside the ones already
+// registered.
+//
+// The middleware is outermost: it runs before anything else the server
+// does for the request, including the health check, the codec lookup and
+// the functions registered with RegisterInterceptFunc, RegisterBeforeFunc
+// and the like, and after the response has been written and the
+// AfterFunc called. A middleware can answer the request itself, in which
+// case none of them are called.
+func (s *Server) UseMiddleware(mw ...func(http.Handler) http.Handler) {
+	s.middleware = append(s.middleware, mw...)
+	var h http.Handler = http.HandlerFunc(s.serveHTTP)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
 	}
-	var codec Codec
-	if contentType == "" && len(s.codecs) == 1 {
-		// If Content-Type is not set and only one codec has been registered,
-		// then default to that codec.
-		for _, c := range s.codecs {
-			codec = c
+	s.handler = h
+}
+
+// serveHTTP dispatches the request, see ServeHTTP.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	for k, v := range s.headers {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	if s.healthPath != "" && r.Method == "GET" && r.URL.Path == s.healthPath {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, `{"status":"ok"}`)
+		return
+	}
+	if s.options && r.Method == "OPTIONS" {
+		h := w.Header()
+		h.Set("Allow", "POST, OPTIONS")
+		h.Set("Accept-Post", strings.Join(s.RegisteredCodecs(), ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// Count the request and response sizes if needed, for the metrics and
+	// the access log.
+	var method string
+	var body *countingReader
+	var cw *countingResponseWriter
+	if s.metrics != nil || s.accessLog != nil {
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		cw = &countingResponseWriter{ResponseWriter: w}
+		w = cw
+	}
+	if s.accessLog != nil {
+		start := time.Now()
+		defer func() {
+			s.accessLog.log(start, method, requestID(r), cw.statusCode(), body.count(), cw.n)
+		}()
+	}
+	contentType := mediaType(r)
+	codec, codecName := s.requestCodec(r)
+	switch {
+	case codec == nil && codecName != "":
+		s.observeCodec(codecName, CodecNegotiationFailed)
+	case codecName != "":
+		s.observeCodec(codecName, CodecByContentType)
+	case codec != nil && contentType == "":
+		s.observeCodec(contentType, CodecDefaulted)
+	case codec != nil:
+		s.observeCodec(contentType, CodecByContentType)
+	}
+	if codec == nil && codecName != "" {
+		s.writeEarlyError(w, r, s.codecFor(""), http.StatusUnsupportedMediaType, "rpc: unrecognized codec: "+codecName)
+		return
+	}
+	if ct, ok := codec.(ContentTyper); ok {
+		// Hint the response type to the wrapping handlers; the codec
+		// sets it again when writing.
+		w.Header().Set("Content-Type", ct.ResponseContentType())
+	}
+	supported, declared := supportedMethods(codec)
+	if declared && !containsMethod(supported, r.Method) {
+		w.Header().Set("Allow", strings.Join(supported, ", "))
+		s.writeEarlyError(w, r, codec, http.StatusMethodNotAllowed, "rpc: method "+r.Method+" not allowed, supported: "+strings.Join(supported, ", "))
+		return
+	}
+	if !declared && r.Method != "POST" && r.Method != "HEAD" && !(s.allowGet && r.Method == "GET") {
+		allow := "POST, HEAD"
+		if s.allowGet {
+			allow = "POST, GET, HEAD"
 		}
-	} else if codec = s.codecs[strings.ToLower(contentType)]; codec == nil {
-		WriteError(w, http.StatusUnsupportedMediaType, "rpc: unrecognized Content-Type: "+contentType)
+		w.Header().Set("Allow", allow)
+		s.writeEarlyError(w, r, codec, http.StatusMethodNotAllowed, "rpc: POST method required, received "+r.Method)
 		return
 	}
+	// Decompress the request body if enabled.
+	inflated, errInflate := decompressRequest(r, s.maxInflated)
+	if codec == nil && len(s.fallback) > 0 && errInflate == nil {
+		var err error
+		if codec, err = s.fallbackCodec(r); err != nil {
+			s.observeCodec(contentType, CodecNegotiationFailed)
+			s.writeEarlyError(w, r, s.codecFor(""), inflated.status(http.StatusBadRequest), err.Error())
+			return
+		}
+		if codec != nil {
+			s.observeCodec(contentType, CodecFallback)
+		}
+		if ct, ok := codec.(ContentTyper); ok {
+			w.Header().Set("Content-Type", ct.ResponseContentType())
+		}
+	}
+	if codec == nil {
+		s.observeCodec(contentType, CodecNegotiationFailed)
+		s.writeEarlyError(w, r, s.codecFor(""), http.StatusUnsupportedMediaType, "rpc: unrecognized Content-Type: "+contentType)
+		return
+	}
+	if s.clientCert {
+		r = withClientCert(r)
+	}
+	if errInflate != nil {
+		s.writeEarlyError(w, r, codec, http.StatusBadRequest, errInflate.Error())
+		return
+	}
+	// Capture the payloads of sampled requests.
+	if s.capture != nil && s.capture.sample() {
+		reqBody, err := bufferBody(r)
+		if err != nil {
+			s.writeEarlyError(w, r, codec, inflated.status(http.StatusBadRequest), "rpc: reading request body: "+err.Error())
+			return
+		}
+		capw := &captureResponseWriter{ResponseWriter: w}
+		w = capw
+		sink := s.capture.sink
+		defer func() {
+			sink(method, reqBody, capw.body())
+		}()
+	}
+	// Call the registered Pre-Decode Intercept Function
+	if s.preIntercept != nil {
+		if req := s.preIntercept(r); req != nil {
+			r = req
+		}
+	}
+	// Transform the raw body before the codec reads it.
+	if s.bodyFunc != nil {
+		if err := s.transformBody(r); err != nil {
+			s.writeEarlyError(w, r, codec, inflated.status(http.StatusBadRequest), err.Error())
+			return
+		}
+	}
 	// Create a new codec request.
 	codecReq := codec.NewRequest(r)
+	defer closeCodecRequest(codecReq)
 	// Get service method to be called.
 	method, errMethod := codecReq.Method()
+	if errMethod == nil && method == "" {
+		errMethod = errEmptyMethod
+	}
 	if errMethod != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errMethod)
+		s.writeError(w, codecReq, &RequestInfo{Request: r, RequestID: requestID(r)}, inflated.status(http.StatusBadRequest), errMethod)
 		return
 	}
-	serviceSpec, methodSpec, errGet := s.services.get(method)
+	// Resolve aliases to their method, adapting the params.
+	alias, isAlias := s.services.alias(method)
+	if isAlias {
+		method = alias.target
+		if status, err := transformParams(codecReq, alias.transform); err != nil {
+			s.writeError(w, codecReq, &RequestInfo{Request: r, Method: method, RequestID: requestID(r)}, status, err)
+			return
+		}
+	}
+	requestInfo := &RequestInfo{
+		Request:   r,
+		Method:    method,
+		RequestID: requestID(r),
+	}
+	if c, ok := codecReq.(CorrelationIDer); ok {
+		requestInfo.CorrelationID = c.CorrelationID()
+	}
+	if n, ok := codecReq.(Notifier); ok {
+		requestInfo.IsNotification = n.Notification()
+	}
+	// The metrics get the method only once resolved, since the name sent
+	// by the client is unbounded.
+	var observed string
+	if s.metrics != nil {
+		start := time.Now()
+		defer func() {
+			s.metrics.ObserveRequestSize(observed, body.count())
+			s.metrics.ObserveResponseSize(observed, cw.n)
+			if m, ok := s.metrics.(CallMetrics); ok {
+				status := requestInfo.StatusCode
+				if status == 0 {
+					status = cw.statusCode()
+				}
+				m.ObserveCall(observed, status, requestInfo.Error, time.Since(start))
+			}
+		}()
+	}
+	methodSpec, resolved, errGet := s.resolveMethod(r, method)
 	if errGet != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errGet)
+		// A configured status is written even by codecs writing errors
+		// with 200 OK, e.g. for gateways routing on it.
+		var statusCode int
+		if s.notFound != 0 {
+			statusCode = s.writeStatusError(w, codecReq, requestInfo, s.notFound, errGet)
+		} else {
+			statusCode = s.writeError(w, codecReq, requestInfo, http.StatusBadRequest, errGet)
+		}
+		s.after(requestInfo, errGet, statusCode)
 		return
 	}
+	method = resolved
+	requestInfo.Method = method
+	observed = method
+
+	// HEAD and GET are only allowed for cacheable methods, unless declared
+	// by the codec, HEAD without a response body.
+	if r.Method == "HEAD" || r.Method == "GET" {
+		if !declared && !methodSpec.cacheable.Load() {
+			errHead := fmt.Errorf("rpc: POST method required, received %s for %q", r.Method, method)
+			w.Header().Set("Allow", "POST")
+			statusCode := s.writeStatusError(w, codecReq, requestInfo, http.StatusMethodNotAllowed, errHead)
+			s.after(requestInfo, errHead, statusCode)
+			return
+		}
+		if r.Method == "HEAD" {
+			w = headResponseWriter{w}
+		}
+	}
+
+	// Wait for the scheduler to admit the call
+	if s.scheduler != nil {
+		if errSched := s.scheduler.acquire(r.Context(), method, int(methodSpec.weight.Load())); errSched != nil {
+			statusCode := http.StatusServiceUnavailable
+			switch errSched {
+			case context.Canceled:
+				statusCode = statusClientClosedRequest
+			case context.DeadlineExceeded:
+				statusCode = http.StatusRequestTimeout
+			}
+			statusCode = s.writeError(w, codecReq, requestInfo, statusCode, errSched)
+			s.after(requestInfo, errSched, statusCode)
+			return
+		}
+		defer s.scheduler.release(method)
+	}
 
 	// Call the registered Intercept Function
 	if s.interceptFunc != nil {
-		req := s.interceptFunc(&RequestInfo{
-			Request: r,
-			Method:  method,
-		})
-		if req != nil {
-			r = req
+		if req := s.interceptFunc(requestInfo); req != nil {
+			requestInfo.Request = req
 		}
+		r = requestInfo.Request
 	}
 
-	requestInfo := &RequestInfo{
-		Request: r,
-		Method:  method,
+	// Call the registered Response Intercept Function
+	if s.interceptResp != nil {
+		if rw := s.interceptResp(w, requestInfo); rw != nil {
+			w = rw
+		}
 	}
 
 	// Call the registered Before Function
 	if s.beforeFunc != nil {
 		s.beforeFunc(requestInfo)
+		r = requestInfo.Request
 	}
 
-	// Close request body after Intercept and Before Function if it exists
-	// if it's already closed, error still would be nil
-	if r.Body != nil {
-		r.Body.Close()
+	// Call the registered Before Hook, which may short-circuit the request
+	if s.beforeHook != nil {
+		errHook := s.beforeHook(requestInfo)
+		r = requestInfo.Request
+		if errHook != nil {
+			statusCode := requestInfo.StatusCode
+			if statusCode == 0 {
+				statusCode = http.StatusBadRequest
+			}
+			statusCode = s.writeError(w, codecReq, requestInfo, statusCode, errHook)
+			s.after(requestInfo, errHook, statusCode)
+			return
+		}
 	}
 
 	// Update codec request with request values after Intercept and Before functions if they exist
-	if s.interceptFunc != nil || s.beforeFunc != nil {
+	if s.interceptFunc != nil || s.beforeFunc != nil || s.beforeHook != nil {
 		codecReq = codec.NewRequest(r)
+		defer closeCodecRequest(codecReq)
+		if isAlias {
+			if status, err := transformParams(codecReq, alias.transform); err != nil {
+				s.writeError(w, codecReq, requestInfo, status, err)
+				return
+			}
+		}
 	}
 
-	// Decode the args.
-	args := reflect.New(methodSpec.argsType)
-	if errRead := codecReq.ReadRequest(args.Interface()); errRead != nil {
-		codecReq.WriteError(w, http.StatusBadRequest, errRead)
+	// Decode the args, unless the method reads the body itself, converting
+	// them if the method has an adapter for the codec.
+	var args reflect.Value
+	var errRead error
+	codecType, _ := parseContentType(r.Header.Get("Content-Type"))
+	if codecName != "" {
+		codecType, _ = parseContentType(codecName)
+	}
+	adapter := methodSpec.adapter(codecType)
+	decodeStart := time.Now()
+	switch {
+	case methodSpec.stream:
+		var body io.Reader = r.Body
+		if sr, ok := codecReq.(StreamRequestReader); ok {
+			body = sr.StreamParams()
+		}
+		args = streamArgs(methodSpec.argsType, body)
+	case adapter != nil:
+		wire := reflect.New(adapter.ArgsType.Elem())
+		args = methodSpec.newArgs()
+		if errRead = codecReq.ReadRequest(wire.Interface()); errRead == nil {
+			errRead = adapter.Args(wire.Interface(), args.Interface())
+		}
+	default:
+		args = methodSpec.newArgs()
+		errRead = codecReq.ReadRequest(args.Interface())
+	}
+	requestInfo.DecodeDuration = time.Since(decodeStart)
+	// Close the request body once the args are decoded, codecs such as
+	// protorpc reading it only then; if it's already closed, error still
+	// would be nil. Methods reading the body themselves need it open.
+	if r.Body != nil && !methodSpec.stream {
+		r.Body.Close()
+	}
+	if errRead != nil {
+		s.writeError(w, codecReq, requestInfo, inflated.status(http.StatusBadRequest), errRead)
+		methodSpec.release(args, reflect.Value{})
 		return
 	}
+	if defaults := methodSpec.defaults.Load(); defaults != nil {
+		applyDefaults(args.Elem(), *defaults)
+	}
+
+	// Call the registered Intercept With Args Function
+	if s.interceptArgs != nil {
+		if req := s.interceptArgs(requestInfo, args.Interface()); req != nil {
+			requestInfo.Request = req
+		}
+		r = requestInfo.Request
+	}
 
 	// Prepare the reply, we need it even if validation fails
-	reply := reflect.New(methodSpec.replyType)
+	reply := methodSpec.newReply()
 	errValue := []reflect.Value{nilErrorValue}
+	errStatus := 0
+	invalid := false // the validator rejected the args
 
 	// Call the registered Validator Function
 	if s.validateFunc.IsValid() {
-		errValue = s.validateFunc.Call([]reflect.Value{reflect.ValueOf(requestInfo), args})
+		validateStart := time.Now()
+		var panicked bool
+		errValue, panicked = s.validate(requestInfo, args)
+		requestInfo.ValidateDuration = time.Since(validateStart)
+		r = requestInfo.Request
+		if panicked {
+			errStatus = http.StatusInternalServerError
+		}
+		invalid = !panicked && !errValue[0].IsNil()
+	}
+
+	// Skip the method if the client is gone or the deadline has passed.
+	if errValue[0].IsNil() {
+		if errCtx := r.Context().Err(); errCtx != nil {
+			errStatus = http.StatusRequestTimeout
+			if errCtx == context.Canceled {
+				errStatus = statusClientClosedRequest
+			}
+			errValue = []reflect.Value{reflect.ValueOf(&errCtx).Elem()}
+		}
+	}
+
+	// Short-circuit the method while its circuit breaker is open.
+	breaker := methodSpec.breaker.Load()
+	if errValue[0].IsNil() && breaker != nil && !breaker.allow() {
+		errStatus = http.StatusServiceUnavailable
+		errCircuit := errCircuitOpen
+		errValue = []reflect.Value{reflect.ValueOf(&errCircuit).Elem()}
 	}
 
 	// If still no errors after validation, call the method
+	var pw *progressWriter
 	if errValue[0].IsNil() {
-		errValue = methodSpec.method.Func.Call([]reflect.Value{
-			serviceSpec.rcvr,
-			reflect.ValueOf(r),
-			args,
-			reply,
-		})
+		callReq := withMethod(r, method)
+		timeout := time.Duration(methodSpec.timeout.Load())
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(callReq.Context(), timeout)
+			defer cancel()
+			callReq = callReq.WithContext(ctx)
+		}
+		if methodSpec.progress && wantsProgress(r) {
+			pw = &progressWriter{w: w}
+			callReq = callReq.WithContext(context.WithValue(callReq.Context(), progressKey{}, Progress(pw.progress)))
+		}
+		var recorded bool
+		if breaker != nil {
+			// A panicking method counts as a failure too.
+			defer func() {
+				if !recorded {
+					breaker.record(true)
+				}
+			}()
+		}
+		start := time.Now()
+		if methodSpec.fast == nil && !methodSpec.hasMiddleware() {
+			in := []reflect.Value{
+				methodSpec.rcvr,
+				reflect.ValueOf(callReq),
+				args,
+			}
+			if reply.IsValid() {
+				in = append(in, reply)
+			}
+			if methodSpec.progress {
+				in = append(in, reflect.ValueOf(progressFrom(callReq)))
+			}
+			errValue = methodSpec.method.Func.Call(in)
+		} else {
+			var replyValue interface{}
+			if reply.IsValid() {
+				replyValue = reply.Interface()
+			}
+			if err := methodSpec.methodFunc()(callReq, args.Interface(), replyValue); err != nil {
+				errValue = []reflect.Value{reflect.ValueOf(&err).Elem()}
+			}
+		}
+		if pw != nil {
+			pw.close()
+		}
+		if timeout > 0 && callReq.Context().Err() == context.DeadlineExceeded && r.Context().Err() == nil {
+			errTimeout := fmt.Errorf("rpc: method %q timed out after %s", method, timeout)
+			errValue = []reflect.Value{reflect.ValueOf(&errTimeout).Elem()}
+			errStatus = http.StatusGatewayTimeout
+		}
+		if breaker != nil {
+			breaker.record(!errValue[0].IsNil())
+			recorded = true
+		}
+		elapsed := time.Since(start)
+		requestInfo.MethodDuration = elapsed
+		if s.slowThreshold > 0 && elapsed > s.slowThreshold {
+			s.logf("rpc: slow method=%s duration=%s threshold=%s", method, elapsed, s.slowThreshold)
+		}
 	}
 
 	// Extract the result to error if needed.
@@ -244,28 +1758,302 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if errInter != nil {
 		statusCode = http.StatusBadRequest
 		errResult = errInter.(error)
+		if es, ok := codecReq.(ErrorStatuser); ok && !invalid {
+			statusCode = es.ErrorStatus(errResult)
+		}
+		if errStatus != 0 {
+			statusCode = errStatus
+		}
+		var multi *MultiError
+		if errors.As(errResult, &multi) {
+			statusCode = http.StatusBadRequest
+		}
+	}
+
+	// Methods without a reply argument respond with a nil reply.
+	var replyValue interface{}
+	if reply.IsValid() {
+		replyValue = reply.Interface()
+	}
+	if adapter != nil && adapter.Reply != nil && errResult == nil {
+		var errAdapt error
+		if replyValue, errAdapt = adapter.Reply(replyValue); errAdapt != nil {
+			statusCode = http.StatusInternalServerError
+			errResult = errAdapt
+		}
+	}
+	if s.replyFunc != nil && errResult == nil {
+		replyValue = s.replyFunc(requestInfo, replyValue)
+	}
+	raw, isRaw := replyValue.(*RawResponse)
+	if _, ok := codecReq.(RawResponseWriter); isRaw && !ok && errResult == nil {
+		statusCode = http.StatusInternalServerError
+		errResult = fmt.Errorf("rpc: the codec doesn't support the RawResponse of %q", method)
+	}
+	stream, isStream := replyValue.(ReplyStream)
+	if _, ok := codecReq.(StreamResponseWriter); isStream && !ok && errResult == nil {
+		statusCode = http.StatusInternalServerError
+		errResult = fmt.Errorf("rpc: the codec doesn't support the ReplyStream of %q", method)
 	}
 
 	// Prevents Internet Explorer from MIME-sniffing a response away
 	// from the declared content-type
-	w.Header().Set("x-content-type-options", "nosniff")
+	if !s.allowSniff {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+
+	// Declare the error trailer before the body is written.
+	if s.errorTrailer != "" {
+		w.Header().Add("Trailer", s.errorTrailer)
+		w = &errorTrailerWriter{ResponseWriter: w, name: s.errorTrailer}
+	}
+
+	// Tell the codec which method was dispatched.
+	if setter, ok := codecReq.(ResolvedMethodSetter); ok {
+		setter.SetResolvedMethod(method)
+	}
+
+	// Let the override replace the encoder selected by the codec.
+	if setter, ok := codecReq.(EncoderSetter); ok && s.encOverride != nil {
+		if enc := s.encOverride(requestInfo); enc != nil {
+			setter.SetEncoder(enc)
+		}
+	}
 
 	// Encode the response.
-	if errResult == nil {
-		codecReq.WriteResponse(w, reply.Interface())
+	encodeStart := time.Now()
+	if pw != nil {
+		// Write the outcome after the progress updates.
+		errWritten := errResult
+		if errResult != nil {
+			statusCode, errWritten = s.handleError(requestInfo, statusCode, errResult)
+		}
+		if err := pw.finish(statusCode, replyValue, errWritten); err != nil {
+			s.logf("rpc: error writing the progress of method=%s: %v", method, err)
+		}
+	} else if errResult == nil && methodSpec.notify.Load() {
+		statusCode = http.StatusNoContent
+		w.Header().Del("Content-Type")
+		w.WriteHeader(statusCode)
+	} else if errResult == nil {
+		if locator, ok := replyValue.(Locator); ok {
+			if location := locator.Location(); location != "" {
+				w.Header().Set("Location", location)
+				statusCode = http.StatusCreated
+			}
+		}
+		if methodSpec.async.Load() {
+			statusCode = http.StatusAccepted
+		}
+		if statusCode != http.StatusOK {
+			w = &successResponseWriter{ResponseWriter: w, status: statusCode}
+		}
+		if headerer, ok := replyValue.(Headerer); ok {
+			for k, v := range headerer.Headers() {
+				w.Header()[k] = v
+			}
+		}
+		writeResponse := codecReq.WriteResponse
+		if isRaw {
+			writeResponse = func(w http.ResponseWriter, _ interface{}) {
+				codecReq.(RawResponseWriter).WriteRawResponse(w, raw)
+			}
+		} else if isStream {
+			writeResponse = func(w http.ResponseWriter, _ interface{}) {
+				codecReq.(StreamResponseWriter).WriteStreamResponse(w, stream)
+			}
+		}
+		// Responses are buffered when cacheable, and when limited unless
+		// streamed, so that a response too large is replaced by an error.
+		target := w
+		cacheable := methodSpec.cacheable.Load()
+		var bw *bufferedResponseWriter
+		if cacheable || (s.maxResponse > 0 && !isStream) {
+			bw = &bufferedResponseWriter{w: w}
+			target = bw
+		}
+		var lw *limitResponseWriter
+		if s.maxResponse > 0 {
+			lw = &limitResponseWriter{ResponseWriter: target, limit: s.maxResponse}
+			target = lw
+		}
+		writeResponse(target, replyValue)
+		switch {
+		case lw != nil && lw.exceeded:
+			s.logf("rpc: response of method=%s exceeds %d bytes", method, s.maxResponse)
+			errResult = errResponseTooLarge
+			if bw != nil {
+				// Nothing was written, but the codec may have set
+				// the headers of the response.
+				w.Header().Del("Content-Encoding")
+				w.Header().Del("Content-Length")
+				statusCode = s.writeError(w, codecReq, requestInfo, http.StatusInternalServerError, errResult)
+			} else {
+				_, errTrailer := s.handleError(requestInfo, statusCode, errResult)
+				WriteErrorTrailer(w, errTrailer)
+			}
+		case cacheable:
+			bw.writeCacheable(r)
+		case bw != nil:
+			bw.flush()
+		}
 	} else {
-		codecReq.WriteError(w, statusCode, errResult)
+		errWrite := errResult
+		if invalid {
+			errWrite = invalidParams(errResult)
+		}
+		statusCode = s.writeError(w, codecReq, requestInfo, statusCode, errWrite)
 	}
+	requestInfo.EncodeDuration = time.Since(encodeStart)
+	methodSpec.release(args, reply)
 
 	// Call the registered After Function
-	if s.afterFunc != nil {
-		s.afterFunc(&RequestInfo{
-			Request:    r,
-			Method:     method,
-			Error:      errResult,
-			StatusCode: statusCode,
-		})
+	s.after(requestInfo, errResult, statusCode)
+}
+
+// ResponseContentType returns the Content-Type of the response the server
+// writes for the request, as reported by the codec chosen for it. It returns
+// an empty string if there is no such codec or it doesn't implement
+// ContentTyper.
+func (s *Server) ResponseContentType(r *http.Request) string {
+	codec, _ := s.requestCodec(r)
+	if ct, ok := codec.(ContentTyper); ok {
+		return ct.ResponseContentType()
+	}
+	return ""
+}
+
+// supportedMethods returns the HTTP methods declared by codec, if it
+// implements HTTPMethodSupporter.
+func supportedMethods(codec Codec) ([]string, bool) {
+	if ms, ok := codec.(HTTPMethodSupporter); ok {
+		return ms.SupportedMethods(), true
+	}
+	return nil, false
+}
+
+// containsMethod reports whether the HTTP method is in methods.
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the "Content-Type" header from the request, excluding
+// the parameters such as the charset definition.
+func mediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = contentType[:idx]
+	}
+	return contentType
+}
+
+// codecFor returns the codec registered for the given "Content-Type" header
+// value, or nil if there is none. If the header is empty and only one codec
+// has been registered, then it defaults to that codec.
+func (s *Server) codecFor(contentType string) Codec {
+	mediaType, params := parseContentType(contentType)
+	s.codecsMu.RLock()
+	defer s.codecsMu.RUnlock()
+	if mediaType == "" && len(s.codecs)+len(s.paramCodecs) == 1 {
+		for _, c := range s.codecs {
+			return c
+		}
+		return s.paramCodecs[0].codec
+	}
+	var codec Codec
+	matched := 0
+	for _, pc := range s.paramCodecs {
+		if pc.mediaType == mediaType && len(pc.params) > matched && matchParams(pc.params, params) {
+			codec = pc.codec
+			matched = len(pc.params)
+		}
 	}
+	if codec != nil {
+		return codec
+	}
+	return s.codecs[mediaType]
+}
+
+// parseContentType returns the lower-cased media type and the parameters of
+// a "Content-Type" header value. Parameters that can't be parsed are
+// ignored.
+func parseContentType(contentType string) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+		if idx := strings.Index(mediaType, ";"); idx != -1 {
+			mediaType = mediaType[:idx]
+		}
+		return strings.ToLower(strings.TrimSpace(mediaType)), nil
+	}
+	return mediaType, params
+}
+
+// matchParams returns true if all the wanted parameters are in params with
+// the same values.
+func matchParams(want, params map[string]string) bool {
+	for k, v := range want {
+		if pv, ok := params[k]; !ok || pv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// equalParams returns true if a and b hold the same parameters.
+func equalParams(a, b map[string]string) bool {
+	return len(a) == len(b) && matchParams(a, b)
+}
+
+// writeEarlyError writes an error detected before the request is handed to
+// a codec, after passing it to the error handler. The error is written in
+// the format of codec when it's not nil, falling back to plain text if there
+// is no codec or it didn't write any response, e.g. because it treated the
+// request as a notification.
+func (s *Server) writeEarlyError(w http.ResponseWriter, r *http.Request, codec Codec, status int, msg string) {
+	status, err := s.handleError(&RequestInfo{Request: r, RequestID: requestID(r)}, status, errors.New(msg))
+	if codec != nil {
+		if r.Body == nil {
+			r.Body = http.NoBody
+		}
+		// The status is forced on codecs writing errors with 200 OK.
+		rw := &writeTracker{ResponseWriter: &successResponseWriter{ResponseWriter: w, status: status}}
+		codecReq := codec.NewRequest(r)
+		codecReq.WriteError(rw, status, err)
+		closeCodecRequest(codecReq)
+		if rw.written {
+			return
+		}
+	}
+	WriteError(w, status, err.Error())
+}
+
+// closeCodecRequest closes codecReq if it implements io.Closer.
+func closeCodecRequest(codecReq CodecRequest) {
+	if c, ok := codecReq.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// writeTracker records whether anything was written to the ResponseWriter.
+type writeTracker struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeTracker) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writeTracker) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
 }
 
 func WriteError(w http.ResponseWriter, status int, msg string) {
@@ -7,13 +7,22 @@ package rpc
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode"
 )
 
 type Service1Request struct {
@@ -396,3 +405,2863 @@ func TestValidationFails(t *testing.T) {
 		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
 	}
 }
+
+func TestValidationPanics(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{1, 2}, "mock")
+	var logs bytes.Buffer
+	s.SetLogger(log.New(&logs, "", 0))
+	s.RegisterValidateRequestFunc(func(_ *RequestInfo, v interface{}) error {
+		var m map[string]int
+		m["boom"] = v.(*Service1Request).A
+		return nil
+	})
+	var status int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		status = i.StatusCode
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock; dummy")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 500 || status != 500 {
+		t.Errorf("Status was %d (%d for the after func), should be 500.", w.Status, status)
+	}
+	if w.Body != errValidatorPanic.Error() {
+		t.Errorf("Response body was %s, should be %s.", w.Body, errValidatorPanic)
+	}
+	if !strings.Contains(logs.String(), "validator panicked for method=Service1.Multiply: assignment to entry in nil map") {
+		t.Errorf("Expected the panic to be logged, got %q", logs.String())
+	}
+
+	if _, err := CallMethod(s, "Service1.Multiply", Service1Request{1, 2}); err != errValidatorPanic {
+		t.Errorf("CallMethod returned %v, should return %v", err, errValidatorPanic)
+	}
+}
+
+func TestValidationModifiesArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(req *Service1Request)
+		expected int
+	}{
+		{"default", func(req *Service1Request) { req.B = 5 }, 10},
+		{"zero", func(req *Service1Request) { req.A = 0 }, 0},
+	}
+	for _, tt := range tests {
+		s := NewServer()
+		if err := s.RegisterService(new(Service1), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		s.RegisterCodec(MockCodec{2, 3}, "mock")
+		modify := tt.modify
+		s.RegisterValidateRequestFunc(func(_ *RequestInfo, v interface{}) error {
+			modify(v.(*Service1Request))
+			return nil
+		})
+
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock; dummy")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if w.Status != 200 {
+			t.Errorf("%s: Status was %d, should be 200.", tt.name, w.Status)
+		}
+		if w.Body != strconv.Itoa(tt.expected) {
+			t.Errorf("%s: Response body was %s, should be %d.", tt.name, w.Body, tt.expected)
+		}
+	}
+}
+
+func TestConcurrentRegisterAndDispatch(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.RegisterService(new(Service1), "Concurrent"+strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			r, err := http.NewRequest("POST", "", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.Header.Set("Content-Type", "mock")
+			w := NewMockResponseWriter()
+			s.ServeHTTP(w, r)
+			if w.Status != 200 {
+				t.Errorf("Status was %d, should be 200.", w.Status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		if method := "Concurrent" + strconv.Itoa(i) + ".Multiply"; !s.HasMethod(method) {
+			t.Errorf("Expected to be registered: %s", method)
+		}
+	}
+}
+
+func TestConcurrentRegisterCodec(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			s.RegisterCodec(MockCodec{2, 3}, "mock"+strconv.Itoa(i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			s.RegisteredCodecs()
+		}()
+		go func() {
+			defer wg.Done()
+			r, err := http.NewRequest("POST", "", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.Header.Set("Content-Type", "mock")
+			w := NewMockResponseWriter()
+			s.ServeHTTP(w, r)
+			if w.Status != 200 {
+				t.Errorf("Status was %d, should be 200.", w.Status)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(s.RegisteredCodecs()); n != 11 {
+		t.Errorf("Expected 11 codecs, got %d", n)
+	}
+}
+
+func BenchmarkConcurrentDispatch(b *testing.B) {
+	s := NewServer()
+	methods := make([]string, 8)
+	for i := range methods {
+		name := "Bench" + strconv.Itoa(i)
+		if err := s.RegisterService(new(Service1), name); err != nil {
+			b.Fatal(err)
+		}
+		methods[i] = name + ".Multiply"
+	}
+
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	bodies := make([]string, len(methods))
+	for i, method := range methods {
+		bodies[i] = `{"method": "` + method + `", "params": {"A": 2, "B": 3}}`
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			r, err := http.NewRequest("POST", "", strings.NewReader(bodies[i%len(bodies)]))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			r.Header.Set("Content-Type", "application/json")
+			w := NewMockResponseWriter()
+			s.ServeHTTP(w, r)
+			if w.Status != http.StatusOK {
+				b.Errorf("Status was %d, should be 200: %s", w.Status, w.Body)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func TestMethodPooling(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMethodPooling("Service1.Unknown", true); err == nil {
+		t.Error("Expected error enabling pooling on an unknown method")
+	}
+	if err := s.SetMethodPooling("Service1.Multiply", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []MockCodec{{2, 3}, {4, 5}, {0, 0}} {
+		s.RegisterCodec(c, "mock")
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if expected := strconv.Itoa(c.A * c.B); w.Body != expected {
+			t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+		}
+	}
+}
+
+func benchmarkServeHTTP(b *testing.B, pooled bool) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		b.Fatal(err)
+	}
+	if err := s.SetMethodPooling("Service1.Multiply", pooled); err != nil {
+		b.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B)       { benchmarkServeHTTP(b, false) }
+func BenchmarkServeHTTPPooled(b *testing.B) { benchmarkServeHTTP(b, true) }
+
+func multiplyFast(r *http.Request, args, reply interface{}) error {
+	req, res := args.(*Service1Request), reply.(*Service1Response)
+	res.Result = req.A * req.B
+	return nil
+}
+
+func TestMethodNotFoundError(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"Service1.Missing", "Missing.Multiply", "Multiply"} {
+		err := s.SetMethodPooling(method, true)
+		if !errors.Is(err, ErrMethodNotFound) {
+			t.Errorf("%s: Error %v should match ErrMethodNotFound", method, err)
+		}
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var errs MultiError
+	if errs.Err() != nil {
+		t.Error("Expected a nil error without entries")
+	}
+	errRequired := errors.New("A: required")
+	errs.Add(errRequired)
+	errs.Add(errors.New("B: must be positive"))
+	if !errors.Is(errs.Err(), errRequired) {
+		t.Error("Expected the MultiError to match its entries")
+	}
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		return errs.Err()
+	})
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Service1.Multiply","params":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "A: required; B: must be positive") {
+		t.Errorf("Response was %d %s, should be 400 with all messages.", w.Code, w.Body)
+	}
+}
+
+func TestAliasWithTransform(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var methods []string
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		methods = append(methods, i.Method)
+	})
+	// V1.Multiply takes the factors as an array.
+	transform := func(params json.RawMessage) (json.RawMessage, error) {
+		var factors []int
+		if err := json.Unmarshal(params, &factors); err != nil {
+			return nil, err
+		}
+		if len(factors) != 2 {
+			return nil, errors.New("two factors required")
+		}
+		return json.Marshal(&Service1Request{A: factors[0], B: factors[1]})
+	}
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Missing", transform); err == nil {
+		t.Error("Expected an error for an unknown target")
+	}
+	if err := s.RegisterAliasWithTransform("Service1.Multiply", "Service1.Multiply", transform); err == nil {
+		t.Error("Expected an error for an alias shadowing a method")
+	}
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", transform); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", nil); err == nil {
+		t.Error("Expected an error for a duplicate alias")
+	}
+	if err := s.RegisterService(new(Service1), "V1"); err == nil {
+		t.Error("Expected an error for a method shadowed by an alias")
+	}
+	if err := s.RegisterFastMethod("V1.Multiply", reflect.TypeOf(&Service1Request{}), reflect.TypeOf(&Service1Response{}), func(r *http.Request, args, reply interface{}) error { return nil }); err == nil {
+		t.Error("Expected an error for a fast method shadowed by an alias")
+	}
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		body     string
+		code     int
+		response string
+	}{
+		{`{"method":"V1.Multiply","params":[3,4]}`, 200, `{"Result":12}`},
+		{`{"method":"Service1.Multiply","params":{"A":3,"B":5}}`, 200, `{"Result":15}`},
+		{`{"method":"V1.Multiply","params":[3]}`, 400, `{"error":"two factors required"}`},
+	}
+	for _, tt := range tests {
+		w := call(tt.body)
+		if body := strings.TrimSpace(w.Body.String()); w.Code != tt.code || body != tt.response {
+			t.Errorf("%s: Response was %d %s, should be %d %s.", tt.body, w.Code, body, tt.code, tt.response)
+		}
+	}
+	if strings.Join(methods, ",") != "Service1.Multiply,Service1.Multiply" {
+		t.Errorf("After function got methods %q, should be the target.", methods)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Expected no error without aliases, got %v.", err)
+	}
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Expected no error for a valid alias, got %v.", err)
+	}
+
+	// The registration prevents these, but the checks don't rely on it.
+	s.services.aliases["V1.Missing"] = methodAlias{target: "Service1.Missing"}
+	s.services.aliases["Service1.Multiply"] = methodAlias{target: "Service1.Multiply"}
+	expected := `rpc: alias "Service1.Multiply" shadows a method` + "\n" +
+		`rpc: alias "V1.Missing" stands for a missing method "Service1.Missing"`
+	if err := s.Validate(); err == nil || err.Error() != expected {
+		t.Errorf("Error was %v, should be %q.", err, expected)
+	}
+}
+
+func TestRegisterFastMethod(t *testing.T) {
+	s := NewServer()
+	argsType := reflect.TypeOf((*Service1Request)(nil))
+	replyType := reflect.TypeOf((*Service1Response)(nil))
+	if err := s.RegisterFastMethod("Service1", argsType, replyType, multiplyFast); err == nil {
+		t.Error("Expected error for an ill-formed method name")
+	}
+	if err := s.RegisterFastMethod("Service1.Multiply", reflect.TypeOf(Service1Request{}), replyType, multiplyFast); err == nil {
+		t.Error("Expected error for a non-pointer args type")
+	}
+	if err := s.RegisterFastMethod("Service1.Multiply", argsType, replyType, multiplyFast); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFastMethod("Service1.Multiply", argsType, replyType, multiplyFast); err == nil {
+		t.Error("Expected error for a duplicate method")
+	}
+	if !s.HasMethod("Service1.Multiply") {
+		t.Error("Expected to be registered: Service1.Multiply")
+	}
+	var calls int
+	if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			calls++
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{4, 5}, "mock")
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 || w.Body != "20" {
+		t.Errorf("Response was %d %s, should be 200 20.", w.Status, w.Body)
+	}
+	if calls != 1 {
+		t.Errorf("Middleware was called %d times, should be 1.", calls)
+	}
+}
+
+func TestRegisterFuncs(t *testing.T) {
+	s := NewServer()
+	err := s.RegisterFuncs("Service1.", map[string]interface{}{
+		"Multiply": func(r *http.Request, req *Service1Request, res *Service1Response) error {
+			res.Result = req.A * req.B
+			return nil
+		},
+		"Notify": func(r *http.Request, req *Service1Request) error {
+			return errors.New("notified")
+		},
+		"Bad":     func(req *Service1Request) error { return nil },
+		"NotFunc": 42,
+	})
+	if err == nil {
+		t.Fatal("Expected an error for the unsuitable functions")
+	}
+	for _, expected := range []string{"Bad: rpc: unsuitable function", "NotFunc: rpc: int is not a function"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Error %q should contain %q", err, expected)
+		}
+	}
+	for method, registered := range map[string]bool{
+		"Service1.Multiply": true,
+		"Service1.Notify":   true,
+		"Service1.Bad":      false,
+		"Service1.NotFunc":  false,
+	} {
+		if s.HasMethod(method) != registered {
+			t.Errorf("HasMethod(%q) should be %v", method, registered)
+		}
+	}
+	s.RegisterCodec(MockCodec{4, 5}, "mock")
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 || w.Body != "20" {
+		t.Errorf("Response was %d %s, should be 200 20.", w.Status, w.Body)
+	}
+}
+
+func BenchmarkServeHTTPFast(b *testing.B) {
+	s := NewServer()
+	err := s.RegisterFastMethod("Service1.Multiply", reflect.TypeOf((*Service1Request)(nil)),
+		reflect.TypeOf((*Service1Response)(nil)), multiplyFast)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ServeHTTP(w, r)
+	}
+}
+
+func TestBeforeHook(t *testing.T) {
+	const expected = "unauthorized"
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var afterInfo *RequestInfo
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		afterInfo = i
+	})
+	s.RegisterValidateRequestFunc(func(_ *RequestInfo, _ interface{}) error {
+		t.Error("Validator should not be called when the before hook fails")
+		return nil
+	})
+	s.RegisterBeforeHook(func(i *RequestInfo) error {
+		if i.Request.Header.Get("Authorization") == "" {
+			i.StatusCode = http.StatusUnauthorized
+			return errors.New(expected)
+		}
+		return nil
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusUnauthorized {
+		t.Errorf("Status was %d, should be 401.", w.Status)
+	}
+	if w.Body != expected {
+		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+	}
+	if afterInfo == nil || afterInfo.Error == nil || afterInfo.StatusCode != http.StatusUnauthorized {
+		t.Errorf("After func should be called with the hook error, got %+v", afterInfo)
+	}
+
+	s.RegisterValidateRequestFunc(func(_ *RequestInfo, _ interface{}) error { return nil })
+	r.Header.Set("Authorization", "token")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 {
+		t.Errorf("Status was %d, should be 200.", w.Status)
+	}
+	if w.Body != "6" {
+		t.Errorf("Response body was %s, should be 6.", w.Body)
+	}
+}
+
+func TestInterceptWithArgs(t *testing.T) {
+	type tenantKey struct{}
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.RegisterInterceptWithArgsFunc(func(i *RequestInfo, v interface{}) *http.Request {
+		req := v.(*Service1Request)
+		return i.Request.WithContext(context.WithValue(i.Request.Context(), tenantKey{}, req.A))
+	})
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, _ interface{}) error {
+		if tenant := i.Request.Context().Value(tenantKey{}); tenant != 2 {
+			return fmt.Errorf("tenant was %v, should be 2", tenant)
+		}
+		return nil
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 {
+		t.Errorf("Status was %d, should be 200: %s", w.Status, w.Body)
+	}
+	if w.Body != "6" {
+		t.Errorf("Response body was %s, should be 6.", w.Body)
+	}
+}
+
+func TestSetContextValue(t *testing.T) {
+	type userKey struct{}
+	type traceKey struct{}
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		i.SetContextValue(userKey{}, "alice")
+	})
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, _ interface{}) error {
+		i.SetContextValue(traceKey{}, "t1")
+		return nil
+	})
+	var user, trace interface{}
+	if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			user = r.Context().Value(userKey{})
+			trace = r.Context().Value(traceKey{})
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 {
+		t.Errorf("Status was %d, should be 200: %s", w.Status, w.Body)
+	}
+	if user != "alice" || trace != "t1" {
+		t.Errorf("Method saw user %v and trace %v, should be alice and t1.", user, trace)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	r, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 while the health check is disabled.", w.Status)
+	}
+
+	s.SetHealthCheckPath("/healthz")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 {
+		t.Errorf("Status was %d, should be 200.", w.Status)
+	}
+	if w.Body != `{"status":"ok"}` {
+		t.Errorf("Response body was %s, should be {\"status\":\"ok\"}.", w.Body)
+	}
+
+	r, err = http.NewRequest("GET", "/rpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 for other paths.", w.Status)
+	}
+}
+
+func TestSniffProtection(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	call := func() *MockResponseWriter {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if h := call().Header().Get("X-Content-Type-Options"); h != "nosniff" {
+		t.Errorf("X-Content-Type-Options was %q, should be nosniff by default.", h)
+	}
+	// The header is written once, in canonical form, even if also set by
+	// default.
+	s.SetDefaultResponseHeaders(http.Header{"X-Content-Type-Options": {"nosniff"}})
+	if h := call().Header(); len(h["X-Content-Type-Options"]) != 1 || h["x-content-type-options"] != nil {
+		t.Errorf("Headers were %v, should have a single canonical X-Content-Type-Options.", h)
+	}
+	s.SetDefaultResponseHeaders(nil)
+	s.SetSniffProtection(false)
+	if h := call().Header().Values("X-Content-Type-Options"); len(h) != 0 {
+		t.Errorf("X-Content-Type-Options was %q, should be absent when disabled.", h)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.RegisterCodec(MockCodec{2, 3}, "application/json")
+
+	r, err := http.NewRequest("OPTIONS", "/rpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 while OPTIONS is disabled.", w.Status)
+	}
+
+	s.EnableOptions()
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusNoContent {
+		t.Errorf("Status was %d, should be 204.", w.Status)
+	}
+	if allow := w.Header().Get("Allow"); allow != "POST, OPTIONS" {
+		t.Errorf("Allow was %q, should be \"POST, OPTIONS\".", allow)
+	}
+	if accept := w.Header().Get("Accept-Post"); accept != "application/json, mock" {
+		t.Errorf("Accept-Post was %q, should be \"application/json, mock\".", accept)
+	}
+	if w.Body != "" {
+		t.Errorf("Response body was %q, should be empty.", w.Body)
+	}
+}
+
+func TestMethodMiddleware(t *testing.T) {
+	const expected = "admin role required"
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	if err := s.Use("Service1.Unknown", func(next MethodFunc) MethodFunc { return next }); err == nil {
+		t.Error("Expected error registering middleware on an unknown method")
+	}
+
+	var calls []string
+	s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			calls = append(calls, "outer")
+			if r.Header.Get("X-Role") != "admin" {
+				return errors.New(expected)
+			}
+			return next(r, args, reply)
+		}
+	})
+	s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			calls = append(calls, "inner")
+			args.(*Service1Request).B = 5
+			err := next(r, args, reply)
+			reply.(*Service1Response).Result++
+			return err
+		}
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 400 {
+		t.Errorf("Status was %d, should be 400.", w.Status)
+	}
+	if w.Body != expected {
+		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+	}
+
+	calls = nil
+	r.Header.Set("X-Role", "admin")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 {
+		t.Errorf("Status was %d, should be 200.", w.Status)
+	}
+	if w.Body != "11" {
+		t.Errorf("Response body was %s, should be 11.", w.Body)
+	}
+	if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+		t.Errorf("Middleware calls were %v, should be [outer inner].", calls)
+	}
+}
+
+func TestMethodMiddlewareConcurrent(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				r, err := http.NewRequest("POST", "", nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				r.Header.Set("Content-Type", "mock")
+				w := NewMockResponseWriter()
+				s.ServeHTTP(w, r)
+				if w.Status != 200 {
+					t.Errorf("Status was %d, should be 200.", w.Status)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc { return next }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestTestCodec(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	tests := []struct {
+		body   string
+		status int
+		reply  string
+	}{
+		{`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`, 200, `{"Result":6}`},
+		{`{"method": "Service1.Unknown", "params": {}}`, 400, `{"error":"rpc: can't find method \"Service1.Unknown\""}`},
+		{`not json`, 400, `{"error":"invalid character 'o' in literal null (expecting 'u')"}`},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if w.Status != test.status {
+			t.Errorf("Status was %d, should be %d.", w.Status, test.status)
+		}
+		if w.Body != test.reply {
+			t.Errorf("Response body was %s, should be %s.", w.Body, test.reply)
+		}
+	}
+}
+
+func TestEarlyErrorsUseCodec(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	r, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405.", w.Status)
+	}
+	if expected := `{"error":"rpc: POST method required, received GET"}`; w.Body != expected {
+		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+	}
+
+	r, err = http.NewRequest("POST", "", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "text/xml")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("Status was %d, should be 415.", w.Status)
+	}
+	if expected := `{"error":"rpc: unrecognized Content-Type: text/xml"}`; w.Body != expected {
+		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+	}
+
+	// Without a codec to determine, errors are written as plain text.
+	s.RegisterCodec(NewTestCodec(), "application/x-json")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if expected := "rpc: unrecognized Content-Type: text/xml"; w.Body != expected {
+		t.Errorf("Response body was %s, should be %s.", w.Body, expected)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type was %s, should be text/plain.", ct)
+	}
+}
+
+type Service3 struct {
+}
+
+func (t *Service3) Sleep(r *http.Request, req *time.Duration, res *struct{}) error {
+	time.Sleep(*req)
+	return nil
+}
+
+func TestSlowRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewServer()
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.SetLogger(log.New(&buf, "", 0))
+
+	call := func(d time.Duration) {
+		body := fmt.Sprintf(`{"method": "Service3.Sleep", "params": %d}`, d)
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if w.Status != 200 {
+			t.Errorf("Status was %d, should be 200.", w.Status)
+		}
+	}
+
+	call(5 * time.Millisecond)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log while disabled, got %q", buf.String())
+	}
+
+	s.SetSlowRequestThreshold(time.Millisecond)
+	call(0)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log for a fast method, got %q", buf.String())
+	}
+	call(5 * time.Millisecond)
+	if !strings.Contains(buf.String(), "rpc: slow method=Service3.Sleep duration=") {
+		t.Errorf("Expected a slow method log, got %q", buf.String())
+	}
+}
+
+type CachedResponse struct {
+	Value string
+}
+
+func (r *CachedResponse) Headers() http.Header {
+	return http.Header{
+		"Cache-Control": {"max-age=60"},
+		"Content-Type":  {"text/plain"},
+	}
+}
+
+type Service4 struct {
+}
+
+func (t *Service4) Get(r *http.Request, req *struct{}, res *CachedResponse) error {
+	res.Value = "cached"
+	return nil
+}
+
+func TestReplyHeaders(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service4), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "Service4.Get"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != `{"Value":"cached"}` {
+		t.Errorf("Response body was %s, should be {\"Value\":\"cached\"}.", w.Body)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("Cache-Control was %q, should be max-age=60.", cc)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type was %q, should be set by the codec.", ct)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterCacheable("Service1.Unknown"); err == nil {
+		t.Error("Expected error registering an unknown method as cacheable")
+	}
+
+	call := func(ifNoneMatch string) *MockResponseWriter {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call(""); w.Header().Get("ETag") != "" {
+		t.Errorf("Expected no ETag for a method not registered as cacheable.")
+	}
+
+	if err := s.RegisterCacheable("Service1.Multiply"); err != nil {
+		t.Fatal(err)
+	}
+	w := call("")
+	etag := w.Header().Get("ETag")
+	if w.Status != 200 || etag == "" {
+		t.Fatalf("Status was %d with ETag %q, should be 200 with an ETag.", w.Status, etag)
+	}
+	if w.Body != `{"Result":6}` {
+		t.Errorf("Response body was %s, should be {\"Result\":6}.", w.Body)
+	}
+
+	if w = call(`"other", ` + etag); w.Status != http.StatusNotModified {
+		t.Errorf("Status was %d, should be 304.", w.Status)
+	}
+	if w.Body != "" {
+		t.Errorf("Response body was %s, should be empty.", w.Body)
+	}
+	if w = call(`"other"`); w.Status != 200 {
+		t.Errorf("Status was %d, should be 200.", w.Status)
+	}
+}
+
+func TestGetCacheable(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	get := func() *MockResponseWriter {
+		r, err := http.NewRequest("GET", "", strings.NewReader(`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if err := s.RegisterCacheable("Service1.Multiply"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(); w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 unless GET is enabled.", w.Status)
+	}
+	s.EnableGet()
+	if w := get(); w.Status != 200 || w.Body != `{"Result":6}` || w.Header().Get("ETag") == "" {
+		t.Errorf("Response was %d %s, should be 200 with a body and an ETag.", w.Status, w.Body)
+	}
+
+	s = NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.EnableGet()
+	if w := get(); w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 for a method not registered as cacheable.", w.Status)
+	}
+}
+
+func TestHeadCacheable(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	head := func() *MockResponseWriter {
+		r, err := http.NewRequest("HEAD", "", strings.NewReader(`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := head(); w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405 for a method not registered as cacheable.", w.Status)
+	}
+
+	if err := s.RegisterCacheable("Service1.Multiply"); err != nil {
+		t.Fatal(err)
+	}
+	w := head()
+	if w.Status != 200 || w.Header().Get("ETag") == "" {
+		t.Errorf("Status was %d with ETag %q, should be 200 with an ETag.", w.Status, w.Header().Get("ETag"))
+	}
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(`{"Result":6}`)) {
+		t.Errorf("Content-Length was %q, should be the length of the body.", cl)
+	}
+	if w.Body != "" {
+		t.Errorf("Response body was %s, should be empty.", w.Body)
+	}
+
+	r, err := http.NewRequest("PUT", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %d, should be 405.", w.Status)
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	s := NewServer()
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterCodec(MockCodec{}, "mock")
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if ct := s.ResponseContentType(r); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type was %q, should be application/json.", ct)
+	}
+	r.Header.Set("Content-Type", "mock")
+	if ct := s.ResponseContentType(r); ct != "" {
+		t.Errorf("Content-Type was %q, should be empty for a codec without ContentTyper.", ct)
+	}
+	r.Header.Set("Content-Type", "unknown")
+	if ct := s.ResponseContentType(r); ct != "" {
+		t.Errorf("Content-Type was %q, should be empty for an unknown codec.", ct)
+	}
+}
+
+func TestMethodNamer(t *testing.T) {
+	s := NewServer()
+	s.SetMethodNamer(func(service, method string) string {
+		var b strings.Builder
+		for i, r := range method {
+			if unicode.IsUpper(r) {
+				if i > 0 {
+					b.WriteByte('_')
+				}
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	})
+	if err := s.RegisterService(new(Service4), "Cache"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("Cache.get") || s.HasMethod("Cache.Get") {
+		t.Errorf("Expected to be registered as Cache.get only")
+	}
+	if !s.HasMethod("Service3.sleep") {
+		t.Errorf("Expected to be registered: Service3.sleep")
+	}
+
+	s.SetMethodNamer(nil)
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("Service1.Multiply") {
+		t.Errorf("Expected to be registered: Service1.Multiply")
+	}
+}
+
+type ServiceÜnicode struct {
+}
+
+func (t *ServiceÜnicode) Ñandú(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A + req.B
+	return nil
+}
+
+func TestUnicodeMethodName(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(ServiceÜnicode), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("ServiceÜnicode.Ñandú") {
+		t.Fatal("Expected to be registered: ServiceÜnicode.Ñandú")
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "ServiceÜnicode.Ñandú", "params": {"A": 2, "B": 3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != `{"Result":5}` {
+		t.Errorf("Response body was %s, should be {\"Result\":5}.", w.Body)
+	}
+}
+
+type Service5 struct {
+}
+
+func (t *Service5) Valid(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return nil
+}
+
+func (t *Service5) NoRequest(req *Service1Request, res *Service1Response) error {
+	return nil
+}
+
+func (t *Service5) ValueArgs(r *http.Request, req Service1Request, res *Service1Response) error {
+	return nil
+}
+
+func (t *Service5) NoError(r *http.Request, req *Service1Request, res *Service1Response) {
+}
+
+func TestValidateService(t *testing.T) {
+	accepted, rejected, err := ValidateService(new(Service5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 1 || accepted[0] != "Valid" {
+		t.Errorf("Accepted methods were %v, should be [Valid].", accepted)
+	}
+	expected := map[string]string{
+		"NoRequest": "first argument type *rpc.Service1Request is not *http.Request",
+		"ValueArgs": "args type rpc.Service1Request is not a pointer",
+		"NoError":   "method has 0 return values, needs 1",
+	}
+	for name, reason := range expected {
+		if rejected[name] != reason {
+			t.Errorf("Rejection reason for %s was %q, should be %q.", name, rejected[name], reason)
+		}
+	}
+	if len(rejected) != len(expected) {
+		t.Errorf("Rejected methods were %v, should be %v.", rejected, expected)
+	}
+
+	if _, _, err := ValidateService(new(Service2)); err == nil {
+		t.Error("Expected error on service2")
+	}
+}
+
+type Service6 struct {
+}
+
+func (t Service6) Value(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return nil
+}
+
+func (t *Service6) Pointer(r *http.Request, req *Service1Request, res *Service1Response) error {
+	return nil
+}
+
+func TestRegisterServiceStrict(t *testing.T) {
+	s := NewServer()
+	err := s.RegisterServiceStrict(new(Service5), "")
+	expected := "rpc: *rpc.Service5 has unsuitable methods: " +
+		"NoError: method has 0 return values, needs 1; " +
+		"ValueArgs: args type rpc.Service1Request is not a pointer"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Error was %v, should be %q.", err, expected)
+	}
+	if s.HasMethod("Service5.Valid") {
+		t.Error("Expected not to be registered: Service5.Valid")
+	}
+
+	err = s.RegisterServiceStrict(Service6{}, "")
+	expected = "rpc: rpc.Service6 has unsuitable methods: Pointer: method has a pointer receiver, register a pointer"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Error was %v, should be %q.", err, expected)
+	}
+
+	if err := s.RegisterServiceStrict(new(Service6), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.HasMethod("Service6.Value") || !s.HasMethod("Service6.Pointer") {
+		t.Error("Expected Service6 methods to be registered")
+	}
+}
+
+func TestRegisterValueReceiver(t *testing.T) {
+	s := NewServer()
+	err := s.RegisterService(Service1{}, "")
+	if err == nil {
+		t.Fatal("Expected error registering a value receiver")
+	}
+	if expected := `rpc: "Service1" has methods with pointer receivers, register a pointer to rpc.Service1`; err.Error() != expected {
+		t.Errorf("Error was %q, should be %q.", err, expected)
+	}
+	if s.HasMethod("Service1.Multiply") {
+		t.Error("Expected not to be registered: Service1.Multiply")
+	}
+}
+
+type AccountCreate struct {
+}
+
+func (t *AccountCreate) Create(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = 1
+	return nil
+}
+
+type AccountDelete struct {
+}
+
+func (t *AccountDelete) Delete(r *http.Request, req *Service1Request, res *Service1Response) error {
+	res.Result = 2
+	return nil
+}
+
+func TestRegisterServiceWithOptions(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), "Calc"); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	multiply := func() string {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Calc.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w.Body
+	}
+
+	if _, err := s.RegisterServiceWithOptions(new(Service1V2), "Calc"); err == nil {
+		t.Error("Expected error registering an existing service by default")
+	}
+	conflicts, err := s.RegisterServiceWithOptions(new(Service1V2), "Calc", OnConflict(ConflictSkip))
+	if err != nil || !reflect.DeepEqual(conflicts, []string{"Calc.Multiply"}) {
+		t.Errorf("Conflicts were %v, %v, should be Calc.Multiply.", conflicts, err)
+	}
+	if body := multiply(); body != `{"Result":20}` {
+		t.Errorf("Response body was %s, should be served by the skipped service.", body)
+	}
+	conflicts, err = s.RegisterServiceWithOptions(new(AccountCreate), "Calc", OnConflict(ConflictSkip))
+	if err != nil || len(conflicts) != 0 || !s.HasMethod("Calc.Create") {
+		t.Errorf("Conflicts were %v, %v, should be none with Calc.Create added.", conflicts, err)
+	}
+	conflicts, err = s.RegisterServiceWithOptions(new(Service1V2), "Calc", OnConflict(ConflictOverwrite))
+	if err != nil || !reflect.DeepEqual(conflicts, []string{"Calc.Multiply"}) {
+		t.Errorf("Conflicts were %v, %v, should be Calc.Multiply.", conflicts, err)
+	}
+	if body := multiply(); body != `{"Result":200}` {
+		t.Errorf("Response body was %s, should be served by the overwriting service.", body)
+	}
+}
+
+func TestRegisterServiceMerge(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterServiceMerge(new(AccountCreate), "Account"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterServiceMerge(new(AccountDelete), "Account"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterService(new(AccountDelete), "Account"); err == nil {
+		t.Error("Expected error registering an existing service without merge")
+	}
+	if err := s.RegisterServiceMerge(new(AccountDelete), "Account"); err == nil {
+		t.Error("Expected error merging an already defined method")
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	for method, expected := range map[string]string{
+		"Account.Create": `{"Result":1}`,
+		"Account.Delete": `{"Result":2}`,
+	} {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "`+method+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if w.Body != expected {
+			t.Errorf("Response body for %s was %s, should be %s.", method, w.Body, expected)
+		}
+	}
+}
+
+// MethodEchoCodec writes the resolved method as the response.
+type MethodEchoCodec struct {
+}
+
+func (c MethodEchoCodec) NewRequest(*http.Request) CodecRequest {
+	return &MethodEchoCodecRequest{}
+}
+
+type MethodEchoCodecRequest struct {
+	MockCodecRequest
+	method string
+}
+
+func (r *MethodEchoCodecRequest) SetResolvedMethod(method string) {
+	r.method = method
+}
+
+func (r *MethodEchoCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	w.Write([]byte(r.method))
+}
+
+func TestResolvedMethod(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MethodEchoCodec{}, "mock")
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != "Service1.Multiply" {
+		t.Errorf("Response body was %s, should be Service1.Multiply.", w.Body)
+	}
+}
+
+type sizeMetrics struct {
+	requests  map[string]int64
+	responses map[string]int64
+}
+
+func (m *sizeMetrics) ObserveRequestSize(method string, size int64) {
+	m.requests[method] += size
+}
+
+func (m *sizeMetrics) ObserveResponseSize(method string, size int64) {
+	m.responses[method] += size
+}
+
+func TestSizeMetrics(t *testing.T) {
+	m := &sizeMetrics{requests: map[string]int64{}, responses: map[string]int64{}}
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.SetMetrics(m)
+
+	body := `{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`
+	r, err := http.NewRequest("POST", "", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != `{"Result":6}` {
+		t.Fatalf("Response body was %s, should be {\"Result\":6}.", w.Body)
+	}
+	if size := m.requests["Service1.Multiply"]; size != int64(len(body)) {
+		t.Errorf("Request size was %d, should be %d.", size, len(body))
+	}
+	if size := m.responses["Service1.Multiply"]; size != int64(len(w.Body)) {
+		t.Errorf("Response size was %d, should be %d.", size, len(w.Body))
+	}
+}
+
+type callMetrics struct {
+	sizeMetrics
+	calls []string
+}
+
+func (m *callMetrics) ObserveCall(method string, status int, err error, duration time.Duration) {
+	m.calls = append(m.calls, fmt.Sprintf("%s %d %v", method, status, err))
+}
+
+func TestCallMetrics(t *testing.T) {
+	m := &callMetrics{sizeMetrics: sizeMetrics{requests: map[string]int64{}, responses: map[string]int64{}}}
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		if args.(*Service1Request).B == 0 {
+			return errors.New("B must not be zero")
+		}
+		return nil
+	})
+	s.SetMetrics(m)
+
+	for _, body := range []string{
+		`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`,
+		`{"method": "Service1.Multiply", "params": {"A": 2, "B": 0}}`,
+		`{"method": "Service1.Missing", "params": {}}`,
+	} {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ServeHTTP(NewMockResponseWriter(), r)
+	}
+	expected := []string{
+		"Service1.Multiply 200 <nil>",
+		"Service1.Multiply 400 B must not be zero",
+		` 400 rpc: can't find method "Service1.Missing"`,
+	}
+	if strings.Join(m.calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Calls were %q, should be %q.", m.calls, expected)
+	}
+	if _, ok := m.requests["Service1.Missing"]; ok {
+		t.Errorf("Unresolved method was reported by name, should be empty.")
+	}
+}
+
+// conflictError is reported with 409 Conflict.
+type conflictError struct{}
+
+func (conflictError) Error() string   { return "version conflict" }
+func (conflictError) HTTPStatus() int { return http.StatusConflict }
+
+func TestHTTPStatuserMetrics(t *testing.T) {
+	m := &callMetrics{sizeMetrics: sizeMetrics{requests: map[string]int64{}, responses: map[string]int64{}}}
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		return conflictError{}
+	})
+	s.SetMetrics(m)
+	var status int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		status = i.StatusCode
+	})
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusConflict || status != http.StatusConflict {
+		t.Errorf("Status was %d (after func %d), should be 409.", w.Status, status)
+	}
+	if expected := "Service1.Multiply 409 version conflict"; len(m.calls) != 1 || m.calls[0] != expected {
+		t.Errorf("Calls were %q, should be [%q].", m.calls, expected)
+	}
+}
+
+type codecMetrics struct {
+	sizeMetrics
+	selections []string
+}
+
+func (m *codecMetrics) ObserveCodec(contentType string, selection CodecSelection) {
+	m.selections = append(m.selections, fmt.Sprintf("%s %s", contentType, selection))
+}
+
+func TestCodecMetrics(t *testing.T) {
+	m := &codecMetrics{sizeMetrics: sizeMetrics{requests: map[string]int64{}, responses: map[string]int64{}}}
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.SetMetrics(m)
+
+	body := `{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`
+	for _, contentType := range []string{"application/json", "", "text/plain"} {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", contentType)
+		s.ServeHTTP(NewMockResponseWriter(), r)
+	}
+	s.SetCodecFallbackChain("application/json")
+	r, err := http.NewRequest("POST", "", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "text/plain")
+	s.ServeHTTP(NewMockResponseWriter(), r)
+
+	expected := []string{
+		"application/json content_type",
+		" default",
+		"text/plain failed",
+		"text/plain fallback",
+	}
+	if strings.Join(m.selections, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Selections were %q, should be %q.", m.selections, expected)
+	}
+}
+
+func TestMethodNotFoundStatus(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var status int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		status = i.StatusCode
+	})
+
+	call := func() *MockResponseWriter {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method": "Service1.Unknown"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call(); w.Status != http.StatusBadRequest || status != http.StatusBadRequest {
+		t.Errorf("Status was %d (after func %d), should be 400.", w.Status, status)
+	}
+	s.SetMethodNotFoundStatus(http.StatusNotFound)
+	if w := call(); w.Status != http.StatusNotFound || status != http.StatusNotFound {
+		t.Errorf("Status was %d (after func %d), should be 404.", w.Status, status)
+	}
+}
+
+// StreamingCodec writes part of the response, then fails.
+type StreamingCodec struct {
+}
+
+func (c StreamingCodec) NewRequest(*http.Request) CodecRequest {
+	return &StreamingCodecRequest{}
+}
+
+type StreamingCodecRequest struct {
+	MockCodecRequest
+}
+
+func (r *StreamingCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	w.Write([]byte("partial"))
+	if !WriteErrorTrailer(w, errors.New("stream failed")) {
+		w.Write([]byte(" untrailed"))
+	}
+}
+
+func TestErrorTrailer(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(StreamingCodec{}, "mock")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	call := func() (*http.Response, string) {
+		res, err := http.Post(ts.URL, "mock", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(b)
+	}
+
+	if _, body := call(); body != "partial untrailed" {
+		t.Errorf("Response body was %q, should be %q.", body, "partial untrailed")
+	}
+
+	s.SetErrorTrailer("X-RPC-Error")
+	res, body := call()
+	if body != "partial" {
+		t.Errorf("Response body was %q, should be %q.", body, "partial")
+	}
+	if trailer := res.Trailer.Get("X-RPC-Error"); trailer != "stream failed" {
+		t.Errorf("Trailer was %q, should be %q.", trailer, "stream failed")
+	}
+}
+
+func TestRegisterCodecWithParameters(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{1, 1}, "application/json")
+	s.RegisterCodec(MockCodec{2, 2}, "application/json; format=compact")
+	s.RegisterCodec(MockCodec{3, 3}, "application/json; format=compact; version=2")
+
+	tests := map[string]string{
+		"application/json":                                    "1",
+		"application/json; charset=utf-8":                     "1",
+		"application/json; format=full":                       "1",
+		"application/json; format=compact":                    "4",
+		"Application/JSON; charset=utf-8; FORMAT=compact":     "4",
+		"application/json; format=compact; version=2":         "9",
+		"application/json; version=2":                         "1",
+		"application/json; version=2; format=compact; x=y":    "9",
+		"application/json; format=\"compact\"; version=\"2\"": "9",
+	}
+	for contentType, expected := range tests {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", contentType)
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if w.Body != expected {
+			t.Errorf("Response body for %q was %s, should be %s.", contentType, w.Body, expected)
+		}
+	}
+}
+
+func TestGzipRequest(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	compress := func(body string) *bytes.Buffer {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte(body))
+		gw.Close()
+		return &buf
+	}
+	const body = `{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}}`
+
+	// The decompression is disabled by default.
+	r, err := http.NewRequest("POST", "", compress(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusBadRequest {
+		t.Errorf("Status was %d, should be 400.", w.Status)
+	}
+
+	s.SetRequestDecompression(1 << 10)
+	r, err = http.NewRequest("POST", "", compress(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != `{"Result":6}` {
+		t.Errorf("Response body was %s, should be {\"Result\":6}.", w.Body)
+	}
+
+	// A small payload decompressing past the limit is rejected.
+	bomb := compress(`{"method": "Service1.Multiply", "params": {"A": 2, "B": 3}, "padding": "` + strings.Repeat("0", 10<<20) + `"}`)
+	if bomb.Len() > 1<<20 {
+		t.Fatalf("Compressed body was %d bytes, should be smaller.", bomb.Len())
+	}
+	r, err = http.NewRequest("POST", "", bomb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status was %d, should be 413.", w.Status)
+	}
+
+	r, err = http.NewRequest("POST", "", strings.NewReader("not gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Encoding", "gzip")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusBadRequest {
+		t.Errorf("Status was %d, should be 400.", w.Status)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		t.Error("Before func should be removed by Reset")
+	})
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", nil); err != nil {
+		t.Fatal(err)
+	}
+	s.SetMethodNamer(func(service, method string) string { return strings.ToLower(method) })
+	s.Reset()
+
+	if s.HasMethod("Service1.Multiply") {
+		t.Error("Expected Service1.Multiply to be removed")
+	}
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != http.StatusUnsupportedMediaType {
+		t.Errorf("Status was %d, should be 415.", w.Status)
+	}
+
+	// The server can be set up again.
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterAliasWithTransform("V1.Multiply", "Service1.Multiply", nil); err != nil {
+		t.Errorf("Expected the aliases to be removed, got %v.", err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Status != 200 || w.Body != "6" {
+		t.Errorf("Response was %d %s, should be 200 6.", w.Status, w.Body)
+	}
+}
+
+func TestRequestInfoShared(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var seen []*RequestInfo
+	s.RegisterInterceptFunc(func(i *RequestInfo) *http.Request {
+		seen = append(seen, i)
+		i.Set("span", "abc")
+		return nil
+	})
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		seen = append(seen, i)
+	})
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, _ interface{}) error {
+		seen = append(seen, i)
+		return nil
+	})
+	var span interface{}
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		seen = append(seen, i)
+		span = i.Get("span")
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	s.ServeHTTP(NewMockResponseWriter(), r)
+	if len(seen) != 4 {
+		t.Fatalf("Hooks were called %d times, should be 4.", len(seen))
+	}
+	for _, i := range seen[1:] {
+		if i != seen[0] {
+			t.Error("Expected the same RequestInfo in every hook")
+		}
+	}
+	if span != "abc" {
+		t.Errorf("Value was %v, should be abc.", span)
+	}
+	if seen[0].Get("missing") != nil {
+		t.Error("Expected nil for a missing value")
+	}
+}
+
+func TestReplyTransformFunc(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterReplyTransformFunc(func(i *RequestInfo, reply interface{}) interface{} {
+		return map[string]interface{}{
+			"method":  i.Method,
+			"doubled": reply.(*Service1Response).Result * 2,
+		}
+	})
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		if args.(*Service1Request).B == 0 {
+			return errors.New("B must not be zero")
+		}
+		return nil
+	})
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(`{"method":"Service1.Multiply","params":{"A":2,"B":3}}`)
+	if body := strings.TrimSpace(w.Body.String()); body != `{"doubled":12,"method":"Service1.Multiply"}` {
+		t.Errorf("Response body was %s, should be the transformed reply.", body)
+	}
+	w = call(`{"method":"Service1.Multiply","params":{"A":2,"B":0}}`)
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "doubled") {
+		t.Errorf("Response was %d %s, errors should not be transformed.", w.Code, w.Body)
+	}
+}
+
+func TestCodecFallbackChain(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	const body = `{"method":"Service1.Multiply","params":{"A":4,"B":5}}`
+	if w := call(body); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Status was %d, should be 415 without a fallback chain.", w.Code)
+	}
+
+	s.SetCodecFallbackChain("application/xml", "application/json")
+	w := call(body)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"Result":20}` {
+		t.Errorf("Response was %d %s, should be served by the JSON codec.", w.Code, w.Body)
+	}
+	if w := call("not json"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Status was %d, should be 415 when no codec reads the method.", w.Code)
+	}
+}
+
+func TestTimingBreakdown(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			time.Sleep(20 * time.Millisecond)
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	var info *RequestInfo
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		info = i
+	})
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":2,"B":3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if info.DecodeDuration <= 0 || info.EncodeDuration <= 0 {
+		t.Errorf("Decode and encode durations should be set, got %s and %s", info.DecodeDuration, info.EncodeDuration)
+	}
+	if info.ValidateDuration < 10*time.Millisecond {
+		t.Errorf("Validate duration was %s, should be at least 10ms", info.ValidateDuration)
+	}
+	if info.MethodDuration < 20*time.Millisecond {
+		t.Errorf("Method duration was %s, should be at least 20ms", info.MethodDuration)
+	}
+}
+
+type RawService struct {
+}
+
+func (t *RawService) Report(r *http.Request, req *Service1Request, res *RawResponse) error {
+	res.ContentType = "text/csv"
+	res.Body = []byte("a,b\n1,2\n")
+	return nil
+}
+
+func TestRawResponseUnsupported(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(RawService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var afterErr error
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		afterErr = i.Error
+	})
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"RawService.Report","params":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status was %d, should be 500.", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "doesn't support the RawResponse") || afterErr == nil {
+		t.Errorf("Expected an unsupported RawResponse error, got %s", w.Body)
+	}
+}
+
+type StreamReply struct {
+}
+
+func (r *StreamReply) Next() (interface{}, error) {
+	return nil, io.EOF
+}
+
+func (t *RawService) Stream(r *http.Request, req *Service1Request, res *StreamReply) error {
+	return nil
+}
+
+func TestReplyStreamUnsupported(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(RawService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"RawService.Stream","params":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "doesn't support the ReplyStream") {
+		t.Errorf("Response was %d %s, should be an unsupported ReplyStream error.", w.Code, w.Body)
+	}
+}
+
+func TestCanceledContextSkipsMethod(t *testing.T) {
+	s := NewServer()
+	var called bool
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			called = true
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		ctx    context.Context
+		status int
+		body   string
+	}{
+		{canceled, 499, context.Canceled.Error()},
+		{expired, http.StatusRequestTimeout, context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		called = false
+		r, err := http.NewRequestWithContext(tt.ctx, "POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		if called {
+			t.Error("Method should not be called with a done context")
+		}
+		if w.Status != tt.status || w.Body != tt.body {
+			t.Errorf("Response was %d %s, should be %d %s.", w.Status, w.Body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestMethodTimeout(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use("Service1.Multiply", func(next MethodFunc) MethodFunc {
+		return func(r *http.Request, args, reply interface{}) error {
+			if _, ok := r.Context().Deadline(); ok {
+				<-r.Context().Done()
+			}
+			return next(r, args, reply)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+	var afterStatus int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		afterStatus = i.StatusCode
+	})
+
+	if err := s.SetMethodTimeout("Service1.Missing", time.Second); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+	if err := s.SetMethodTimeout("Service1.Multiply", -time.Second); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+
+	call := func() *MockResponseWriter {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "mock")
+		w := NewMockResponseWriter()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call(); w.Status != 200 || w.Body != "6" {
+		t.Errorf("Response was %d %s, should be 200 6 without a timeout.", w.Status, w.Body)
+	}
+
+	if err := s.SetMethodTimeout("Service1.Multiply", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	w := call()
+	if w.Status != http.StatusGatewayTimeout || !strings.Contains(w.Body, "timed out") {
+		t.Errorf("Response was %d %s, should be a 504 timeout error.", w.Status, w.Body)
+	}
+	if afterStatus != http.StatusGatewayTimeout {
+		t.Errorf("After function got status %d, should be 504.", afterStatus)
+	}
+
+	if err := s.SetMethodTimeout("Service1.Multiply", 0); err != nil {
+		t.Fatal(err)
+	}
+	if w := call(); w.Status != 200 {
+		t.Errorf("Status was %d, should be 200 once the timeout is removed.", w.Status)
+	}
+}
+
+// captureWriter records the body written through it.
+type captureWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestResponseInterception(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	var capture *captureWriter
+	s.RegisterResponseInterceptFunc(func(w http.ResponseWriter, i *RequestInfo) http.ResponseWriter {
+		if i.Method != "Service1.Multiply" {
+			t.Errorf("Method was %s, should be Service1.Multiply.", i.Method)
+		}
+		w.Header().Set("X-Intercepted", "1")
+		capture = &captureWriter{ResponseWriter: w}
+		return capture
+	})
+
+	r, err := http.NewRequest("POST", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "mock")
+	w := NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != "6" || capture.body.String() != "6" {
+		t.Errorf("Bodies were %s and %s, should be 6.", w.Body, capture.body.String())
+	}
+	if w.Header().Get("X-Intercepted") != "1" {
+		t.Error("Expected the header set by the intercept function")
+	}
+
+	// A nil writer keeps the original one.
+	s.RegisterResponseInterceptFunc(func(w http.ResponseWriter, i *RequestInfo) http.ResponseWriter {
+		return nil
+	})
+	w = NewMockResponseWriter()
+	s.ServeHTTP(w, r)
+	if w.Body != "6" {
+		t.Errorf("Response body was %s, should be 6.", w.Body)
+	}
+}
+
+func TestRegisteredCodecs(t *testing.T) {
+	s := NewServer()
+	if codecs := s.RegisteredCodecs(); len(codecs) != 0 {
+		t.Errorf("Codecs were %v, should be empty.", codecs)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterCodec(MockCodec{}, "mock")
+	s.RegisterCodec(MockCodec{}, "Application/JSON; Format=compact; b=1")
+
+	expected := []string{"application/json", "application/json; b=1; format=compact", "mock"}
+	if codecs := s.RegisteredCodecs(); strings.Join(codecs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Codecs were %q, should be %q.", codecs, expected)
+	}
+}
+
+func TestBodyTransformFunc(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterBodyTransformFunc(func(r *http.Request, body []byte) ([]byte, error) {
+		var envelope struct{ Payload json.RawMessage }
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, err
+		}
+		if envelope.Payload == nil {
+			return nil, errors.New("missing payload")
+		}
+		return envelope.Payload, nil
+	})
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(`{"Payload":{"method":"Service1.Multiply","params":{"A":4,"B":5}}}`)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"Result":20}` {
+		t.Errorf("Response was %d %s, should be decoded from the unwrapped body.", w.Code, w.Body)
+	}
+	w = call(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing payload") {
+		t.Errorf("Response was %d %s, should be 400 when the transform fails.", w.Code, w.Body)
+	}
+}
+
+func TestPreDecodeInterceptFunc(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var order []string
+	s.RegisterPreDecodeInterceptFunc(func(r *http.Request) *http.Request {
+		order = append(order, "pre")
+		if r.Header.Get("X-Sealed") == "" {
+			return nil
+		}
+		// Replace the sealed body with the actual call.
+		req := r.Clone(r.Context())
+		req.Body = io.NopCloser(strings.NewReader(`{"method":"Service1.Multiply","params":{"A":6,"B":7}}`))
+		return req
+	})
+	s.RegisterBodyTransformFunc(func(r *http.Request, body []byte) ([]byte, error) {
+		order = append(order, "body")
+		return body, nil
+	})
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		order = append(order, "after")
+	})
+
+	r, err := http.NewRequest("POST", "", strings.NewReader("sealed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Sealed", "1")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"Result":42}` {
+		t.Errorf("Response was %d %s, should be decoded from the replaced request.", w.Code, w.Body)
+	}
+	if strings.Join(order, ",") != "pre,body,after" {
+		t.Errorf("Hooks ran in order %q, should be pre, body, after.", order)
+	}
+}
+
+func TestRegisterAsyncMethod(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterAsyncMethod("Service1.Missing"); err == nil {
+		t.Error("Expected an error for an unknown method")
+	}
+	if err := s.RegisterAsyncMethod("Service1.Multiply"); err != nil {
+		t.Fatal(err)
+	}
+	var status int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		status = i.StatusCode
+	})
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`)
+	if w.Code != http.StatusAccepted || status != http.StatusAccepted {
+		t.Errorf("Status was %d, %d in the after func, should be 202.", w.Code, status)
+	}
+	w = call(`{"method":"Service1.Multiply","params":{"A":"x"}}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status was %d, should be 400 for errors.", w.Code)
+	}
+}
+
+func TestAcceptsMultipart(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/json, multipart/mixed", true},
+		{"Multipart/Mixed; q=0.5", true},
+		{"multipart/mixed;q=0", false},
+		{"multipart/form-data", false},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("POST", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Accept", tt.accept)
+		if got := AcceptsMultipart(r); got != tt.expected {
+			t.Errorf("AcceptsMultipart(%q) was %v, should be %v.", tt.accept, got, tt.expected)
+		}
+	}
+}
+
+// getCodec is the test codec, also accepting GET requests.
+type getCodec struct {
+	Codec
+}
+
+func (c getCodec) SupportedMethods() []string {
+	return []string{"GET", "POST"}
+}
+
+func TestHTTPMethodSupporter(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(getCodec{NewTestCodec()}, "application/json")
+
+	call := func(method string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	// GET is allowed without EnableGet, for methods not cacheable.
+	for _, method := range []string{"GET", "POST"} {
+		if w := call(method); w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"Result":20}` {
+			t.Errorf("%s: response was %d %s, should be 200.", method, w.Code, w.Body)
+		}
+	}
+	for _, method := range []string{"HEAD", "PUT"} {
+		w := call(method)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+			t.Errorf("%s: status was %d with Allow %q, should be 405 with GET, POST.", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestDefaultResponseHeaders(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	defaults := http.Header{
+		"X-Api-Version": {"2"},
+		"Cache-Control": {"no-store"},
+		"Content-Type":  {"text/plain"},
+	}
+	s.SetDefaultResponseHeaders(defaults)
+	defaults.Set("X-Api-Version", "3")
+
+	call := func(body string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, body := range []string{`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`, `{"method":"Service1.Missing"}`} {
+		h := call(body).Header()
+		if h.Get("X-Api-Version") != "2" || h.Get("Cache-Control") != "no-store" {
+			t.Errorf("Headers were %v, should include the defaults.", h)
+		}
+		if ct := h.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+			t.Errorf("Content-Type was %q, the codec's should take precedence.", ct)
+		}
+	}
+
+	s.SetDefaultResponseHeaders(nil)
+	if h := call(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`).Header(); h.Get("X-Api-Version") != "" {
+		t.Errorf("Headers were %v, should not include removed defaults.", h)
+	}
+}
+
+func TestCodecHeader(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	s.RegisterCodec(MockCodec{2, 3}, "mock")
+
+	call := func(contentType, codec string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", contentType)
+		if codec != "" {
+			r.Header.Set("X-RPC-Codec", codec)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call("text/plain", "application/json"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Status was %d, should be 415 without a codec header set.", w.Code)
+	}
+	s.SetCodecHeader("X-RPC-Codec")
+	tests := []struct {
+		contentType string
+		codec       string
+		status      int
+		body        string
+	}{
+		{"text/plain", "application/json", 200, `{"Result":20}`},
+		{"application/json", "mock", 200, "6"},
+		{"mock", "", 200, "6"},
+		{"application/json", "application/xml", http.StatusUnsupportedMediaType, "rpc: unrecognized codec: application/xml"},
+	}
+	for _, tt := range tests {
+		w := call(tt.contentType, tt.codec)
+		if w.Code != tt.status || strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("%s, %s: response was %d %s, should be %d %s.", tt.contentType, tt.codec, w.Code, w.Body, tt.status, tt.body)
+		}
+	}
+}
+
+func TestUseMiddleware(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var order []string
+	s.RegisterBeforeFunc(func(i *RequestInfo) {
+		order = append(order, "before")
+	})
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		order = append(order, "after")
+	})
+	named := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" in")
+				w.Header().Set("X-"+name, "1")
+				next.ServeHTTP(w, r)
+				order = append(order, name+" out")
+			})
+		}
+	}
+	s.UseMiddleware(named("A"), named("B"))
+	s.UseMiddleware(named("C"))
+	// A middleware answering the request itself.
+	s.UseMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	call := func(auth string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("token")
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"Result":20}` || w.Header().Get("X-A") != "1" {
+		t.Errorf("Response was %d %s, should be 200 through the middleware.", w.Code, w.Body)
+	}
+	expected := "A in,B in,C in,before,after,C out,B out,A out"
+	if strings.Join(order, ",") != expected {
+		t.Errorf("Order was %q, should be %q.", order, expected)
+	}
+	order = nil
+	if w := call(""); w.Code != http.StatusUnauthorized || strings.Join(order, ",") != "A in,B in,C in,C out,B out,A out" {
+		t.Errorf("Response was %d with order %q, should be 401 without calling the hooks.", w.Code, order)
+	}
+}
+
+func TestMethodNotAllowedAllow(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+
+	call := func(method string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(method, "", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":4,"B":5}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("GET")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST, HEAD" {
+		t.Errorf("Response was %d with Allow %q, should be 405 with POST, HEAD.", w.Code, w.Header().Get("Allow"))
+	}
+	s.EnableGet()
+	if w := call("PUT"); w.Header().Get("Allow") != "POST, GET, HEAD" {
+		t.Errorf("Allow was %q, should be POST, GET, HEAD once GET is enabled.", w.Header().Get("Allow"))
+	}
+	// GET and HEAD are only allowed for cacheable methods.
+	if w := call("GET"); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+		t.Errorf("Response was %d with Allow %q, should be 405 with POST.", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	errSecret := errors.New("connection to db:5432 refused")
+	s.RegisterValidateRequestFunc(func(i *RequestInfo, args interface{}) error {
+		if args.(*Service1Request).A < 0 {
+			return errSecret
+		}
+		return nil
+	})
+	type call struct {
+		method string
+		status int
+	}
+	var handled []call
+	s.RegisterErrorHandler(func(i *RequestInfo, status int, err error) (int, error) {
+		handled = append(handled, call{i.Method, status})
+		switch {
+		case errors.Is(err, errSecret):
+			return http.StatusInternalServerError, errors.New("internal error")
+		case errors.Is(err, ErrMethodNotFound):
+			return http.StatusNotFound, nil
+		}
+		return status, err
+	})
+	var after *RequestInfo
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		after = i
+	})
+
+	tests := []struct {
+		contentType, method, params string
+		status                      int
+		body                        string
+		handled                     []call
+	}{
+		{"application/json", "Service1.Multiply", `{"A":4,"B":5}`, 200, `{"Result":20}`, nil},
+		{"application/json", "Service1.Multiply", `{"A":-4,"B":5}`, 500, `{"error":"internal error"}`, []call{{"Service1.Multiply", 400}}},
+		{"application/json", "Service1.Missing", `{}`, 404, `{"error":"rpc: can't find method \"Service1.Missing\""}`, []call{{"Service1.Missing", 400}}},
+		{"text/xml", "Service1.Multiply", `{}`, 415, `{"error":"rpc: unrecognized Content-Type: text/xml"}`, []call{{"", 415}}},
+	}
+	for _, tt := range tests {
+		handled, after = nil, nil
+		body := `{"method":"` + tt.method + `","params":` + tt.params + `}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("Response was %d %s, should be %d %s.", w.Code, w.Body, tt.status, tt.body)
+		}
+		if !reflect.DeepEqual(handled, tt.handled) {
+			t.Errorf("Error handler got %v, should get %v.", handled, tt.handled)
+		}
+		if after != nil && after.StatusCode != tt.status {
+			t.Errorf("After function got status %d, should get the written %d.", after.StatusCode, tt.status)
+		}
+	}
+	handled, after = nil, nil
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"Service1.Multiply","params":{"A":-1}}`))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	if after == nil || after.Error != errSecret {
+		t.Errorf("After function should get the original error.")
+	}
+}
+
+func TestEmptyMethod(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	// The test codec reads an empty method without an error.
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	var handled error
+	s.RegisterErrorHandler(func(i *RequestInfo, status int, err error) (int, error) {
+		handled = err
+		return status, err
+	})
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"params":{"A":4,"B":5}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"rpc: method malformed: empty method name"}` {
+		t.Errorf("Response was %d %s, should be 400 with an empty method error.", w.Code, w.Body)
+	}
+	if !errors.Is(handled, ErrMethodMalformed) {
+		t.Errorf("Error was %v, should match ErrMethodMalformed.", handled)
+	}
+}
+
+func TestMount(t *testing.T) {
+	billing := NewServer()
+	billing.RegisterCodec(NewTestCodec(), "application/json")
+	if err := billing.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := billing.RegisterAliasWithTransform("Calc.times", "Service1.Multiply", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Mount("acme.billing", billing); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"Service1.Multiply", "acme.billing.Service1.Multiply"} {
+		if !s.HasMethod(method) {
+			t.Errorf("Expected to be registered: %s", method)
+		}
+	}
+	// The codec of the mounted server is inherited.
+	for _, method := range []string{"acme.billing.Service1.Multiply", "acme.billing.Calc.times"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"method":"`+method+`","params":{"A":4,"B":5}}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != `{"Result":20}` {
+			t.Errorf("Response of %s was %d %s, should be 200 with 20.", method, w.Code, w.Body)
+		}
+	}
+	// The settings of the methods are shared.
+	if err := billing.SetMethodTimeout("Service1.Multiply", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, _ := s.services.get("acme.billing.Service1.Multiply"); m.timeout.Load() != int64(time.Second) {
+		t.Error("Expected the timeout set on the mounted server to apply.")
+	}
+
+	if err := s.Mount("acme.billing", billing); err == nil {
+		t.Error("Expected an error mounting the namespace again.")
+	}
+	for _, namespace := range []string{"", "acme.", ".billing"} {
+		if err := s.Mount(namespace, billing); err == nil {
+			t.Errorf("Expected an error for namespace %q.", namespace)
+		}
+	}
+	if err := s.Mount("self", s); err == nil {
+		t.Error("Expected an error mounting a server on itself.")
+	}
+}
+
+type CreateArgs struct {
+	ID string
+}
+
+type CreateReply struct {
+	ID string
+}
+
+func (r *CreateReply) Location() string {
+	if r.ID == "" {
+		return ""
+	}
+	return "/items/" + r.ID
+}
+
+type ItemService struct {
+}
+
+func (t *ItemService) Create(r *http.Request, args *CreateArgs, reply *CreateReply) error {
+	reply.ID = args.ID
+	return nil
+}
+
+func (t *ItemService) Import(r *http.Request, args *CreateArgs, reply *CreateReply) error {
+	reply.ID = args.ID
+	return nil
+}
+
+func TestLocator(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(ItemService), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterCodec(NewTestCodec(), "application/json")
+	if err := s.RegisterAsyncMethod("ItemService.Import"); err != nil {
+		t.Fatal(err)
+	}
+	var status int
+	s.RegisterAfterFunc(func(i *RequestInfo) {
+		status = i.StatusCode
+	})
+
+	tests := []struct {
+		body, location string
+		status         int
+	}{
+		{`{"method":"ItemService.Create","params":{"ID":"42"}}`, "/items/42", http.StatusCreated},
+		{`{"method":"ItemService.Create","params":{}}`, "", http.StatusOK},
+		{`{"method":"ItemService.Import","params":{"ID":"7"}}`, "/items/7", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.status || status != tt.status {
+			t.Errorf("Status was %d, %d in the after func, should be %d for %s.", w.Code, status, tt.status, tt.body)
+		}
+		if l := w.Header().Get("Location"); l != tt.location {
+			t.Errorf("Location was %q, should be %q for %s.", l, tt.location, tt.body)
+		}
+	}
+}
+
+func TestFindMethodFold(t *testing.T) {
+	s := NewServer()
+	if err := s.RegisterService(new(Service1), ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, expected string
+	}{
+		{"Service1.Multiply", "Service1.Multiply"},
+		{"service1.multiply", "Service1.Multiply"},
+		{"SERVICE1.MULTIPLY", "Service1.Multiply"},
+		{"Service1.Divide", ""},
+		{"Multiply", ""},
+	}
+	for _, test := range tests {
+		method, ok := s.FindMethodFold(test.method)
+		if method != test.expected || ok != (test.expected != "") {
+			t.Errorf("FindMethodFold(%q) = %q, %v, should be %q", test.method, method, ok, test.expected)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// A method whose args are a *json.Decoder or an *io.Reader reads the
+// request body itself instead of receiving args decoded by the codec, e.g.
+// to import a payload too large to be held in memory:
+//
+//	func (t *T) Import(r *http.Request, dec *json.Decoder, reply *Reply) error
+//
+// The decoder, or the reader, is set on the request body as left by the
+// codec. Codecs taking the method from the URL, such as protorpc, leave it
+// unread, so the method streams it; codecs decoding an envelope, such as
+// json2, have already read it and implement StreamRequestReader, so the
+// method reads the params from memory. The codec's ReadRequest is not
+// called, and such methods can't have defaults set with SetMethodDefaults.
+var (
+	typeOfDecoder = reflect.TypeOf(json.Decoder{})
+	typeOfReader  = reflect.TypeOf((*io.Reader)(nil)).Elem()
+)
+
+// isStream reports whether argsType, the type pointed to by the args of a
+// method, makes the method read the request body itself.
+func isStream(argsType reflect.Type) bool {
+	return argsType == typeOfDecoder || argsType == typeOfReader
+}
+
+// streamArgs returns the args of a method reading the request body itself:
+// a *json.Decoder or an *io.Reader on body, depending on argsType.
+func streamArgs(argsType reflect.Type, body io.Reader) reflect.Value {
+	if body == nil {
+		body = http.NoBody
+	}
+	if argsType == typeOfDecoder {
+		return reflect.ValueOf(json.NewDecoder(body))
+	}
+	v := reflect.New(typeOfReader)
+	v.Elem().Set(reflect.ValueOf(&body).Elem())
+	return v
+}
@@ -0,0 +1,148 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pathCodec takes the method from the URL path and leaves the body to the
+// method, writing the reply as JSON.
+type pathCodec struct{}
+
+func (pathCodec) NewRequest(r *http.Request) CodecRequest {
+	return pathCodecRequest{path.Base(r.URL.Path)}
+}
+
+type pathCodecRequest struct {
+	method string
+}
+
+func (c pathCodecRequest) Method() (string, error) {
+	return c.method, nil
+}
+
+func (c pathCodecRequest) ReadRequest(args interface{}) error {
+	return fmt.Errorf("rpc: ReadRequest called for %T", args)
+}
+
+func (c pathCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (c pathCodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	io.WriteString(w, err.Error())
+}
+
+type LoadRecord struct {
+	Name string
+}
+
+type LoadReply struct {
+	Count int
+	Bytes int
+}
+
+type LoadService struct {
+	// first, if not nil, is closed once the first record is decoded.
+	first chan struct{}
+}
+
+func (t *LoadService) Records(r *http.Request, dec *json.Decoder, reply *LoadReply) error {
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var record LoadRecord
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		if reply.Count == 0 && t.first != nil {
+			close(t.first)
+		}
+		reply.Count++
+	}
+	return nil
+}
+
+func (t *LoadService) Raw(r *http.Request, body *io.Reader, reply *LoadReply) error {
+	n, err := io.Copy(io.Discard, *body)
+	reply.Bytes = int(n)
+	return err
+}
+
+func TestStreamArgs(t *testing.T) {
+	service := &LoadService{first: make(chan struct{})}
+	s := NewServer()
+	s.RegisterCodec(pathCodec{}, "application/json")
+	if err := s.RegisterService(service, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second record is only written once the method has decoded the
+	// first one, which can't happen if the body is read beforehand.
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, `[{"Name":"a"},`)
+		select {
+		case <-service.first:
+			io.WriteString(pw, `{"Name":"b"}]`)
+			pw.Close()
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(fmt.Errorf("body was not streamed"))
+		}
+	}()
+	r := httptest.NewRequest("POST", "/LoadService.Records", pr)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"Count":2,"Bytes":0}`+"\n" {
+		t.Errorf("Records: got %d %s", w.Code, w.Body)
+	}
+
+	service.first = nil
+
+	body := strings.Repeat("x", 1<<16)
+	r = httptest.NewRequest("POST", "/LoadService.Raw", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"Count":0,"Bytes":65536}`+"\n" {
+		t.Errorf("Raw: got %d %s", w.Code, w.Body)
+	}
+
+	r = httptest.NewRequest("POST", "/LoadService.Records", strings.NewReader(`[{"Name":`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Truncated body: got %d %s, should be 400", w.Code, w.Body)
+	}
+
+	if err := s.SetMethodDefaults("LoadService.Raw", nil); err != nil {
+		t.Error(err)
+	}
+	var reader io.Reader = strings.NewReader("x")
+	if err := s.SetMethodDefaults("LoadService.Raw", &reader); err == nil {
+		t.Error("Expected an error for the defaults of a streaming method")
+	}
+
+	reply, err := CallMethod(s, "LoadService.Records", json.NewDecoder(strings.NewReader(`[{"Name":"c"}]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.(*LoadReply).Count != 1 {
+		t.Errorf("CallMethod got %+v, should count 1 record", reply)
+	}
+}
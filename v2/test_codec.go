@@ -0,0 +1,106 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// NewTestCodec returns a minimal JSON Codec, convenient to assert dispatch
+// behavior in unit tests without importing a codec package.
+//
+// Requests are JSON objects holding the method and its args:
+//
+//	{"method": "Service.Method", "params": {"A": 2, "B": 3}}
+//
+// The reply is written as JSON with status 200. Errors are written as
+// {"error": "message"} with the status chosen by the server.
+func NewTestCodec() Codec {
+	return &testCodec{}
+}
+
+type testCodec struct {
+}
+
+func (c *testCodec) ResponseContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (c *testCodec) NewRequest(r *http.Request) CodecRequest {
+	req := &testCodecRequest{}
+	if r.Body == nil {
+		req.err = errors.New("rpc: empty request body")
+		return req
+	}
+	// Restore the body, so the request can be read again once replaced
+	// by the before functions, as with the json2 codec.
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		req.err = err
+		return req
+	}
+	req.err = json.Unmarshal(b, &req.request)
+	return req
+}
+
+// testCodecRequest decodes and encodes a single request.
+type testCodecRequest struct {
+	request struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	err error
+}
+
+func (c *testCodecRequest) Method() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	return c.request.Method, nil
+}
+
+func (c *testCodecRequest) TransformParams(f func(json.RawMessage) (json.RawMessage, error)) error {
+	if c.err != nil {
+		return c.err
+	}
+	params, err := f(c.request.Params)
+	if err == nil {
+		c.request.Params = params
+	}
+	return err
+}
+
+func (c *testCodecRequest) ReadRequest(args interface{}) error {
+	if c.err == nil && len(c.request.Params) > 0 {
+		c.err = json.Unmarshal(c.request.Params, args)
+	}
+	return c.err
+}
+
+func (c *testCodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	c.write(w, http.StatusOK, reply)
+}
+
+func (c *testCodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	c.write(w, status, map[string]string{"error": err.Error()})
+}
+
+func (c *testCodecRequest) write(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,49 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"net/http"
+)
+
+// errorTrailerWriter declares an HTTP trailer to report errors occurring
+// after the response body has started.
+type errorTrailerWriter struct {
+	http.ResponseWriter
+	name string
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (w *errorTrailerWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// WriteErrorTrailer reports err in the error trailer declared by the server,
+// for errors occurring once the response status and headers have been sent,
+// e.g. when a streamed reply fails partway. It returns false if no trailer
+// has been declared for the response, see Server.SetErrorTrailer, in which
+// case the caller should report the error some other way.
+func WriteErrorTrailer(w http.ResponseWriter, err error) bool {
+	for {
+		switch tw := w.(type) {
+		case *errorTrailerWriter:
+			tw.Header().Set(tw.name, err.Error())
+			return true
+		case *countingResponseWriter:
+			w = tw.ResponseWriter
+		case *writeTracker:
+			w = tw.ResponseWriter
+		case *limitResponseWriter:
+			w = tw.ResponseWriter
+		case *successResponseWriter:
+			w = tw.ResponseWriter
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gorilla/rpc/urlencoded provides a codec for
+application/x-www-form-urlencoded requests, as sent by HTML forms and some
+legacy clients.
+
+To register the codec in a RPC server:
+
+	import (
+		"http"
+		"github.com/gorilla/rpc/v2"
+		"github.com/gorilla/rpc/v2/urlencoded"
+	)
+
+	func init() {
+		s := rpc.NewServer()
+		s.RegisterCodec(urlencoded.NewCodec(), "application/x-www-form-urlencoded")
+		// [...]
+		http.Handle("/rpc/", s)
+	}
+
+The method is taken from the "method" form field or, if absent, from the
+last element of the request path, e.g. "/rpc/Service.Method". The other
+fields are set on the args of the method, which must be a struct. A field
+is matched by its "form" tag or, without one, by its name, ignoring case:
+
+	type SearchArgs struct {
+		Query string `form:"q"`
+		Page  int
+		Tags  []string
+		Exact *bool
+	}
+
+Strings, booleans, integers and floats are supported, as well as pointers
+to and slices of them; repeated form fields fill slices. Fields tagged
+`form:"-"`, unexported fields and unknown form fields are ignored. A value
+that can't be converted is a 400 Bad Request error.
+
+Responses are JSON encoded, as {"result": reply} on success and
+{"error": message} on failure.
+
+Check the gorilla/rpc documentation for more details:
+
+	http://gorilla-web.appspot.com/pkg/rpc
+*/
+package urlencoded
@@ -0,0 +1,220 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlencoded
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+// ContentType is the Content-Type of the responses written by the codec.
+const ContentType = "application/json; charset=utf-8"
+
+// ----------------------------------------------------------------------------
+// Codec
+// ----------------------------------------------------------------------------
+
+// NewCodec returns a new application/x-www-form-urlencoded Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Codec creates a CodecRequest to process each request.
+type Codec struct {
+}
+
+// NewRequest returns a CodecRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	return &CodecRequest{
+		request: r,
+		err:     r.ParseForm(),
+	}
+}
+
+// ResponseContentType returns the Content-Type of the responses written by
+// the codec.
+func (c *Codec) ResponseContentType() string {
+	return ContentType
+}
+
+// ----------------------------------------------------------------------------
+// CodecRequest
+// ----------------------------------------------------------------------------
+
+// CodecRequest decodes and encodes a single request.
+type CodecRequest struct {
+	request *http.Request
+	err     error
+}
+
+// Method returns the RPC method for the current request.
+//
+// The method uses a dotted notation as in "Service.Method".
+func (c *CodecRequest) Method() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	if m := c.request.Form.Get("method"); m != "" {
+		return m, nil
+	}
+	if m := path.Base(c.request.URL.Path); path.Ext(m) != "" {
+		return m, nil
+	}
+	return "", errors.New("rpc: missing method")
+}
+
+// ReadRequest fills the request object for the RPC method.
+//
+// args must be a pointer to a struct, its fields are set from the form
+// fields other than "method".
+func (c *CodecRequest) ReadRequest(args interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	v := reflect.ValueOf(args)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("rpc: args must be a struct, not %T", args)
+	}
+	return decodeStruct(v.Elem(), c.request.Form)
+}
+
+// WriteResponse encodes the response and writes it to the ResponseWriter.
+func (c *CodecRequest) WriteResponse(w http.ResponseWriter, reply interface{}) {
+	c.writeServerResponse(w, http.StatusOK, map[string]interface{}{"result": reply})
+}
+
+// WriteError writes the error as {"error": message} with the given status.
+func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
+	c.writeServerResponse(w, status, map[string]interface{}{"error": err.Error()})
+}
+
+func (c *CodecRequest) writeServerResponse(w http.ResponseWriter, status int, res interface{}) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		rpc.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// ----------------------------------------------------------------------------
+// Decoding
+// ----------------------------------------------------------------------------
+
+// decodeStruct sets the fields of v from the matching form fields.
+func decodeStruct(v reflect.Value, form url.Values) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := decodeStruct(v.Field(i), form); err != nil {
+				return err
+			}
+			continue
+		}
+		name := field.Tag.Get("form")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		values := lookup(form, name, field.Name)
+		if len(values) == 0 {
+			continue
+		}
+		if err := decodeField(v.Field(i), values); err != nil {
+			if ne, ok := err.(*strconv.NumError); ok {
+				err = ne.Err
+			}
+			return fmt.Errorf("rpc: invalid value %q for field %q: %v", values[0], field.Name, err)
+		}
+	}
+	return nil
+}
+
+// lookup returns the values of the form field named tag or, if tag is
+// empty, of the one matching name regardless of case.
+func lookup(form url.Values, tag, name string) []string {
+	if tag != "" {
+		return form[tag]
+	}
+	if values, ok := form[name]; ok {
+		return values
+	}
+	for key, values := range form {
+		if key != "method" && strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// decodeField sets v from the values of a form field: slices get all the
+// values, other kinds the first one.
+func decodeField(v reflect.Value, values []string) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := decodeValue(s.Index(i), value); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := decodeValue(p.Elem(), values[0]); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	return decodeValue(v, values[0])
+}
+
+// decodeValue converts value to the kind of v and sets it.
+func decodeValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2012 The Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlencoded
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/rpc/v2"
+)
+
+type Paging struct {
+	Page  int
+	Limit uint8
+}
+
+type SearchArgs struct {
+	Query  string `form:"q"`
+	Tags   []string
+	Exact  *bool
+	Score  float64
+	Secret string `form:"-"`
+	Paging
+}
+
+type Search struct{}
+
+func (s *Search) Find(r *http.Request, args *SearchArgs, reply *SearchArgs) error {
+	*reply = *args
+	return nil
+}
+
+func execute(t *testing.T, s *rpc.Server, url string, form url.Values) (int, map[string]json.RawMessage) {
+	r := httptest.NewRequest("POST", url, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type was %q, should be %q", ct, ContentType)
+	}
+	var res map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+func TestSearch(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/x-www-form-urlencoded")
+	if err := s.RegisterService(new(Search), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	exact := true
+	expected := SearchArgs{Query: "go", Tags: []string{"a", "b"}, Exact: &exact, Score: 0.5, Paging: Paging{Page: 2, Limit: 10}}
+	form := url.Values{
+		"q":      {"go"},
+		"tags":   {"a", "b"},
+		"Exact":  {"true"},
+		"score":  {"0.5"},
+		"page":   {"2"},
+		"LIMIT":  {"10"},
+		"Secret": {"x"},
+		"other":  {"y"},
+	}
+	for _, target := range []string{"/rpc", "/rpc/Search.Find"} {
+		f := url.Values{}
+		for k, v := range form {
+			f[k] = v
+		}
+		if target == "/rpc" {
+			f.Set("method", "Search.Find")
+		}
+		code, res := execute(t, s, target, f)
+		if code != 200 {
+			t.Fatalf("Status was %d, should be 200: %s", code, res["error"])
+		}
+		var reply SearchArgs
+		if err := json.Unmarshal(res["result"], &reply); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reply, expected) {
+			t.Errorf("Reply was %+v, should be %+v", reply, expected)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "application/x-www-form-urlencoded")
+	if err := s.RegisterService(new(Search), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url   string
+		form  url.Values
+		error string
+	}{
+		{"/rpc", url.Values{"q": {"go"}}, "rpc: missing method"},
+		{"/rpc/Search.Find", url.Values{"page": {"two"}}, `rpc: invalid value "two" for field "Page": invalid syntax`},
+		{"/rpc/Search.Find", url.Values{"limit": {"300"}}, `rpc: invalid value "300" for field "Limit": value out of range`},
+		{"/rpc/Search.Find", url.Values{"exact": {"maybe"}}, `rpc: invalid value "maybe" for field "Exact": invalid syntax`},
+	}
+	for _, tt := range tests {
+		code, res := execute(t, s, tt.url, tt.form)
+		if code != 400 {
+			t.Errorf("Status was %d, should be 400", code)
+		}
+		var msg string
+		json.Unmarshal(res["error"], &msg)
+		if msg != tt.error {
+			t.Errorf("Error was %q, should be %q", msg, tt.error)
+		}
+	}
+}